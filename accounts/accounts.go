@@ -0,0 +1,103 @@
+package accounts
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"github.com/javierlopezdeancos/stipendivm/customers"
+	"github.com/javierlopezdeancos/stipendivm/notifications"
+)
+
+// LinkSender deliver login links to customers
+type LinkSender interface {
+	SendLink(c *stripe.Customer, link string, validFor time.Duration) error
+}
+
+// NotifierSender send login links as transactional emails in the customer locale
+type NotifierSender struct {
+	Notifier *notifications.Notifier
+}
+
+// SendLink Email a login link to a customer
+func (s *NotifierSender) SendLink(c *stripe.Customer, link string, validFor time.Duration) error {
+	r := notifications.CustomerRecipient(c)
+
+	return s.Notifier.Notify(notifications.MagicLink, r.Locale, r.Email, notifications.Data{
+		Name:     r.Name,
+		Link:     link,
+		ValidFor: fmt.Sprintf("%.0f min", validFor.Minutes()),
+	})
+}
+
+// Accounts passwordless login of the customers with links sent to their email
+type Accounts struct {
+	Links    *Links
+	Sessions *Sessions
+	Sender   LinkSender
+	LoginURL string
+	// Limiter login links requested per email and per IP address, unlimited when nil
+	Limiter *RateLimiter
+}
+
+// Default accounts used by the server
+var Default *Accounts
+
+// RequestLink Send a login link to the customer with an email, requested from ipAddress. Nothing is sent, and no error
+// returned, when there is none, so the response does not tell which emails have an account. Too many requests for
+// the email or from the IP address are a RateLimitError, whether the email has an account or not.
+func (a *Accounts) RequestLink(email string, ipAddress string) error {
+	email = customers.NormalizeEmail(email)
+
+	if a.Limiter != nil && !a.Limiter.Allow("email:"+email, "ip:"+ipAddress) {
+		return &RateLimitError{Window: a.Limiter.Window()}
+	}
+
+	c, err := customers.FindByEmail(email)
+
+	if err != nil {
+		return err
+	}
+
+	if c == nil {
+		fmt.Println("🔵 [INFO] Login link requested for an email without customer")
+		return nil
+	}
+
+	token, err := a.Links.Issue(c.ID)
+
+	if err != nil {
+		return err
+	}
+
+	link, err := url.Parse(a.LoginURL)
+
+	if err != nil {
+		return fmt.Errorf("accounts: invalid login URL %s: %v", a.LoginURL, err)
+	}
+
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	if err := a.Sender.SendLink(c, link.String(), a.Links.TTL()); err != nil {
+		return err
+	}
+
+	fmt.Printf("🔵 [INFO] Login link sent to customer %s\n", c.ID)
+
+	return nil
+}
+
+// Login Redeem a login link token for a session of its customer
+func (a *Accounts) Login(token string) (*Session, error) {
+	customerID, err := a.Links.Redeem(token)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return a.Sessions.Issue(customerID)
+}
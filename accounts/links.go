@@ -0,0 +1,153 @@
+package accounts
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/javierlopezdeancos/stipendivm/storage"
+)
+
+// Link errors reasons
+const (
+	LinkUnknown = "unknown"
+	LinkExpired = "expired"
+	LinkUsed    = "used"
+)
+
+// Link login link sent to a customer, only the hash of its token is kept
+type Link struct {
+	Hash       string    `json:"hash"`
+	CustomerID string    `json:"customerId"`
+	CreatedAt  time.Time `json:"createdAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	UsedAt     time.Time `json:"usedAt,omitempty"`
+}
+
+// LinkError a login link token that can not be used to log in
+type LinkError struct {
+	Reason string
+}
+
+func (e *LinkError) Error() string {
+	return "accounts: login link is " + e.Reason
+}
+
+// Links login links persisted on disk, every link can be used once before it expires
+type Links struct {
+	mu    sync.Mutex
+	path  string
+	ttl   time.Duration
+	links []Link
+	now   func() time.Time
+}
+
+// NewLinks Load the login links stored in path, an empty store if it does not exist yet, new links last ttl
+func NewLinks(path string, ttl time.Duration) (*Links, error) {
+	l := &Links{
+		path: path,
+		ttl:  ttl,
+		now:  time.Now,
+	}
+
+	if err := storage.ReadJSON(path, &l.links); err != nil {
+		return nil, fmt.Errorf("accounts: error loading login links: %v", err)
+	}
+
+	return l, nil
+}
+
+// Issue New login link token of a customer, the links expired a day ago are dropped
+func (l *Links) Issue(customerID string) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := make([]byte, 32)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("accounts: error generating login link token: %v", err)
+	}
+
+	token := hex.EncodeToString(b)
+	now := l.now().UTC()
+	previous := l.links
+	kept := []Link{}
+
+	for _, link := range l.links {
+		if now.Sub(link.ExpiresAt) < 24*time.Hour {
+			kept = append(kept, link)
+		}
+	}
+
+	l.links = append(kept, Link{
+		Hash:       hashToken(token),
+		CustomerID: customerID,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(l.ttl),
+	})
+
+	if err := l.save(); err != nil {
+		l.links = previous
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Redeem Use a login link token, the customer it belongs to
+func (l *Links) Redeem(token string) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	hash := hashToken(token)
+	now := l.now().UTC()
+
+	for i := range l.links {
+		link := &l.links[i]
+
+		if link.Hash != hash {
+			continue
+		}
+
+		if !link.UsedAt.IsZero() {
+			return "", &LinkError{Reason: LinkUsed}
+		}
+
+		if now.After(link.ExpiresAt) {
+			return "", &LinkError{Reason: LinkExpired}
+		}
+
+		link.UsedAt = now
+
+		if err := l.save(); err != nil {
+			link.UsedAt = time.Time{}
+			return "", err
+		}
+
+		return link.CustomerID, nil
+	}
+
+	return "", &LinkError{Reason: LinkUnknown}
+}
+
+// TTL How long new login links last
+func (l *Links) TTL() time.Duration {
+	return l.ttl
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func (l *Links) save() error {
+	if err := storage.WriteJSON(l.path, l.links); err != nil {
+		return fmt.Errorf("accounts: error saving login links: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package accounts
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RateLimitError too many login links requested for an email or from an IP address within the window
+type RateLimitError struct {
+	Window time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("accounts: too many login links requested in %s", e.Window)
+}
+
+// RateLimiter at most max requests of every key within a sliding window, kept in memory
+type RateLimiter struct {
+	mu       sync.Mutex
+	max      int
+	window   time.Duration
+	requests map[string][]time.Time
+	now      func() time.Time
+}
+
+// NewRateLimiter Limiter of max requests per key every window
+func NewRateLimiter(max int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		max:      max,
+		window:   window,
+		requests: map[string][]time.Time{},
+		now:      time.Now,
+	}
+}
+
+// Allow Count a request of every key, false without counting it when any of them already made max requests in the
+// window
+func (r *RateLimiter) Allow(keys ...string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	since := now.Add(-r.window)
+
+	// keys without requests in the window are dropped, the limiter does not grow with every key ever seen
+	for key, requests := range r.requests {
+		kept := requests[:0]
+
+		for _, t := range requests {
+			if t.After(since) {
+				kept = append(kept, t)
+			}
+		}
+
+		if len(kept) == 0 {
+			delete(r.requests, key)
+		} else {
+			r.requests[key] = kept
+		}
+	}
+
+	for _, key := range keys {
+		if len(r.requests[key]) >= r.max {
+			return false
+		}
+	}
+
+	for _, key := range keys {
+		r.requests[key] = append(r.requests[key], now)
+	}
+
+	return true
+}
+
+// Window How long requests are counted
+func (r *RateLimiter) Window() time.Duration {
+	return r.window
+}
@@ -0,0 +1,34 @@
+package accounts
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	now := time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(2, time.Hour)
+	limiter.now = func() time.Time { return now }
+
+	steps := []struct {
+		name    string
+		advance time.Duration
+		keys    []string
+		want    bool
+	}{
+		{"first link of the email", 0, []string{"email:ana@example.com", "ip:10.0.0.1"}, true},
+		{"second link of the email", time.Minute, []string{"email:ana@example.com", "ip:10.0.0.1"}, true},
+		{"third link of the email", time.Minute, []string{"email:ana@example.com", "ip:10.0.0.2"}, false},
+		{"another email from the same IP", time.Minute, []string{"email:luis@example.com", "ip:10.0.0.1"}, false},
+		{"another email and IP", 0, []string{"email:luis@example.com", "ip:10.0.0.2"}, true},
+		{"first links out of the window", time.Hour, []string{"email:ana@example.com", "ip:10.0.0.1"}, true},
+	}
+
+	for _, step := range steps {
+		now = now.Add(step.advance)
+
+		if got := limiter.Allow(step.keys...); got != step.want {
+			t.Fatalf("%s: Allow(%v) = %v, want %v", step.name, step.keys, got, step.want)
+		}
+	}
+}
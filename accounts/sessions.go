@@ -0,0 +1,135 @@
+package accounts
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+)
+
+// contextKey key of the session token in the request context
+const contextKey = "session"
+
+// minimumSecretLength shortest secret accepted to sign sessions, the length of the HS256 hash
+const minimumSecretLength = 32
+
+// Claims claims of a session token, its subject is the customer ID
+type Claims struct {
+	jwt.StandardClaims
+}
+
+// Session signed session token of a customer
+type Session struct {
+	Token      string    `json:"token"`
+	CustomerID string    `json:"customerId"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// Sessions sign and verify the session tokens of the customers
+type Sessions struct {
+	secret []byte
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+// NewSessions Sessions signed with a secret of at least 32 characters and lasting ttl
+func NewSessions(secret string, ttl time.Duration) (*Sessions, error) {
+	if len(secret) < minimumSecretLength {
+		return nil, fmt.Errorf("accounts: the session secret must have at least %d characters", minimumSecretLength)
+	}
+
+	return &Sessions{
+		secret: []byte(secret),
+		ttl:    ttl,
+		now:    time.Now,
+	}, nil
+}
+
+// Issue Sign a new session of a customer
+func (s *Sessions) Issue(customerID string) (*Session, error) {
+	now := s.now().UTC()
+	expiresAt := now.Add(s.ttl)
+
+	claims := Claims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   customerID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: expiresAt.Unix(),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+
+	if err != nil {
+		return nil, fmt.Errorf("accounts: error signing session of customer %s: %v", customerID, err)
+	}
+
+	return &Session{
+		Token:      token,
+		CustomerID: customerID,
+		ExpiresAt:  time.Unix(expiresAt.Unix(), 0).UTC(),
+	}, nil
+}
+
+// Authenticate Middleware tying the request to the customer of its bearer session token, the requests without one go
+// through as guests and the ones with an invalid or expired token are rejected
+func (s *Sessions) Authenticate() echo.MiddlewareFunc {
+	return s.middleware(func(c echo.Context) bool {
+		return !strings.HasPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+	})
+}
+
+// Require Middleware rejecting the requests without a valid bearer session token
+func (s *Sessions) Require() echo.MiddlewareFunc {
+	return s.middleware(middleware.DefaultSkipper)
+}
+
+// RequireCustomer Middleware rejecting the requests without a valid bearer session token of the customer in the path
+// parameter param, a session of another customer is forbidden
+func (s *Sessions) RequireCustomer(param string) echo.MiddlewareFunc {
+	require := s.Require()
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return require(func(c echo.Context) error {
+			if CustomerID(c) != c.Param(param) {
+				return echo.NewHTTPError(http.StatusForbidden, "Sorry, you can only access your own account")
+			}
+
+			return next(c)
+		})
+	}
+}
+
+func (s *Sessions) middleware(skipper middleware.Skipper) echo.MiddlewareFunc {
+	return middleware.JWTWithConfig(middleware.JWTConfig{
+		Skipper:       skipper,
+		SigningKey:    s.secret,
+		SigningMethod: middleware.AlgorithmHS256,
+		ContextKey:    contextKey,
+		Claims:        &Claims{},
+		ErrorHandler: func(err error) error {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Sorry, you need to log in")
+		},
+	})
+}
+
+// CustomerID Customer the request is authenticated as, empty for guests
+func CustomerID(c echo.Context) string {
+	token, ok := c.Get(contextKey).(*jwt.Token)
+
+	if !ok {
+		return ""
+	}
+
+	claims, ok := token.Claims.(*Claims)
+
+	if !ok {
+		return ""
+	}
+
+	return claims.Subject
+}
@@ -0,0 +1,459 @@
+package addressbook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/javierlopezdeancos/stipendivm/customers"
+	"github.com/javierlopezdeancos/stipendivm/storage"
+)
+
+// Kinds of default address
+const (
+	Billing  = "billing"
+	Shipping = "shipping"
+)
+
+// Entry address saved in the address book of a customer
+type Entry struct {
+	ID              string            `json:"id"`
+	CustomerID      string            `json:"customerId"`
+	Label           string            `json:"label,omitempty"`
+	Name            string            `json:"name"`
+	Phone           string            `json:"phone,omitempty"`
+	Address         customers.Address `json:"address"`
+	DefaultBilling  bool              `json:"defaultBilling"`
+	DefaultShipping bool              `json:"defaultShipping"`
+	CreatedAt       time.Time         `json:"createdAt"`
+	UpdatedAt       time.Time         `json:"updatedAt"`
+}
+
+// Change partial update of an entry, only the fields given are changed
+type Change struct {
+	Label   *string            `json:"label"`
+	Name    *string            `json:"name"`
+	Phone   *string            `json:"phone"`
+	Address *customers.Address `json:"address"`
+}
+
+// NotFoundError an address that is not in the book of the customer
+type NotFoundError struct {
+	ID string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("addressbook: address %s not found", e.ID)
+}
+
+// Book address books of every customer persisted on disk
+type Book struct {
+	mu      sync.Mutex
+	path    string
+	entries []Entry
+	now     func() time.Time
+}
+
+// Default book used by the server
+var Default *Book
+
+// NewBook Load the address books stored in path, an empty book if it does not exist yet
+func NewBook(path string) (*Book, error) {
+	b := &Book{
+		path: path,
+		now:  time.Now,
+	}
+
+	if err := storage.ReadJSON(path, &b.entries); err != nil {
+		return nil, fmt.Errorf("addressbook: error loading addresses: %v", err)
+	}
+
+	return b, nil
+}
+
+// List Addresses of a customer, oldest first
+func (b *Book) List(customerID string) []Entry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	list := []Entry{}
+
+	for _, e := range b.entries {
+		if e.CustomerID == customerID {
+			list = append(list, e)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+
+	return list
+}
+
+// Get Get an address of a customer
+func (b *Book) Get(customerID string, id string) (*Entry, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	i := b.find(customerID, id)
+
+	if i < 0 {
+		return nil, &NotFoundError{ID: id}
+	}
+
+	found := b.entries[i]
+
+	return &found, nil
+}
+
+// DefaultFor Default billing or shipping address of a customer, ok is false when it has none
+func (b *Book) DefaultFor(customerID string, kind string) (Entry, bool) {
+	for _, e := range b.List(customerID) {
+		if (kind == Billing && e.DefaultBilling) || (kind == Shipping && e.DefaultShipping) {
+			return e, true
+		}
+	}
+
+	return Entry{}, false
+}
+
+// Add Save a new normalized address of a customer, the first one is its default billing and shipping address
+func (b *Book) Add(customerID string, e Entry) (*Entry, error) {
+	address, fields := customers.NormalizeAddress(e.Address, "address")
+	fields = append(fields, requiredName(e.Name)...)
+	fields = append(fields, requiredStreet(address.Street)...)
+
+	if len(fields) > 0 {
+		return nil, &customers.ValidationError{Fields: fields}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	previous := b.snapshot()
+	now := b.now().UTC()
+
+	e.ID = newID()
+	e.CustomerID = customerID
+	e.Address = address
+	e.CreatedAt = now
+	e.UpdatedAt = now
+
+	first := true
+
+	for _, other := range b.entries {
+		if other.CustomerID == customerID {
+			first = false
+		}
+	}
+
+	if first {
+		e.DefaultBilling = true
+		e.DefaultShipping = true
+	}
+
+	b.entries = append(b.entries, e)
+
+	if e.DefaultBilling {
+		b.setDefault(customerID, e.ID, Billing)
+	}
+
+	if e.DefaultShipping {
+		b.setDefault(customerID, e.ID, Shipping)
+	}
+
+	if err := b.save(); err != nil {
+		b.entries = previous
+		return nil, err
+	}
+
+	return &e, nil
+}
+
+// Update Change the fields of an address given in the change, the address is normalized again
+func (b *Book) Update(customerID string, id string, change Change) (*Entry, error) {
+	var address customers.Address
+	fields := []customers.FieldError{}
+
+	if change.Address != nil {
+		normalized, addressFields := customers.NormalizeAddress(*change.Address, "address")
+		fields = append(fields, addressFields...)
+		fields = append(fields, requiredStreet(normalized.Street)...)
+		address = normalized
+	}
+
+	if change.Name != nil {
+		fields = append(fields, requiredName(*change.Name)...)
+	}
+
+	if len(fields) > 0 {
+		return nil, &customers.ValidationError{Fields: fields}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	i := b.find(customerID, id)
+
+	if i < 0 {
+		return nil, &NotFoundError{ID: id}
+	}
+
+	previous := b.entries[i]
+	e := &b.entries[i]
+
+	if change.Label != nil {
+		e.Label = *change.Label
+	}
+
+	if change.Name != nil {
+		e.Name = *change.Name
+	}
+
+	if change.Phone != nil {
+		e.Phone = *change.Phone
+	}
+
+	if change.Address != nil {
+		e.Address = address
+	}
+
+	e.UpdatedAt = b.now().UTC()
+
+	if err := b.save(); err != nil {
+		b.entries[i] = previous
+		return nil, err
+	}
+
+	updated := *e
+
+	return &updated, nil
+}
+
+// Delete Remove an address of a customer, the oldest address left takes its defaults
+func (b *Book) Delete(customerID string, id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	i := b.find(customerID, id)
+
+	if i < 0 {
+		return &NotFoundError{ID: id}
+	}
+
+	previous := b.snapshot()
+	deleted := b.entries[i]
+	b.entries = append(b.entries[:i], b.entries[i+1:]...)
+
+	if oldest := b.oldest(customerID); oldest != "" {
+		if deleted.DefaultBilling {
+			b.setDefault(customerID, oldest, Billing)
+		}
+
+		if deleted.DefaultShipping {
+			b.setDefault(customerID, oldest, Shipping)
+		}
+	}
+
+	if err := b.save(); err != nil {
+		b.entries = previous
+		return err
+	}
+
+	return nil
+}
+
+// SetDefault Make an address the default billing or shipping address of its customer
+func (b *Book) SetDefault(customerID string, id string, kind string) (*Entry, error) {
+	if kind != Billing && kind != Shipping {
+		return nil, fmt.Errorf("addressbook: unknown default kind %q, use %s or %s", kind, Billing, Shipping)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	i := b.find(customerID, id)
+
+	if i < 0 {
+		return nil, &NotFoundError{ID: id}
+	}
+
+	previous := b.snapshot()
+	b.setDefault(customerID, id, kind)
+
+	if err := b.save(); err != nil {
+		b.entries = previous
+		return nil, err
+	}
+
+	updated := b.entries[i]
+
+	return &updated, nil
+}
+
+// DeleteCustomer Remove every address of a customer, the number of addresses removed
+func (b *Book) DeleteCustomer(customerID string) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	previous := b.snapshot()
+	kept := []Entry{}
+
+	for _, e := range b.entries {
+		if e.CustomerID != customerID {
+			kept = append(kept, e)
+		}
+	}
+
+	removed := len(b.entries) - len(kept)
+
+	if removed == 0 {
+		return 0, nil
+	}
+
+	b.entries = kept
+
+	if err := b.save(); err != nil {
+		b.entries = previous
+		return 0, err
+	}
+
+	return removed, nil
+}
+
+// SyncDefaults Save the default billing address of a customer as its Stripe address and the default shipping one as
+// its Stripe shipping details, for invoices and Stripe receipts
+func (b *Book) SyncDefaults(customerID string) error {
+	change := customers.CustomerChange{}
+
+	if e, ok := b.DefaultFor(customerID, Billing); ok {
+		address := e.Address
+		change.Address = &address
+	}
+
+	if e, ok := b.DefaultFor(customerID, Shipping); ok {
+		change.Shipping = &customers.Shipping{
+			Address: e.Address,
+			Name:    e.Name,
+			Phone:   e.Phone,
+		}
+	}
+
+	if change.Address == nil && change.Shipping == nil {
+		return nil
+	}
+
+	_, err := customers.Update(customerID, change)
+
+	return err
+}
+
+// requiredName error of a missing name of who receives the orders, needed to ship to an address
+func requiredName(name string) []customers.FieldError {
+	if strings.TrimSpace(name) != "" {
+		return nil
+	}
+
+	return []customers.FieldError{{
+		Field:   "name",
+		Code:    customers.FieldRequired,
+		Message: "The name of who receives the orders is required",
+	}}
+}
+
+// requiredStreet error of a missing street, needed to ship to an address
+func requiredStreet(street string) []customers.FieldError {
+	if strings.TrimSpace(street) != "" {
+		return nil
+	}
+
+	return []customers.FieldError{{
+		Field:   "address.street",
+		Code:    customers.FieldRequired,
+		Message: "The street is required",
+	}}
+}
+
+// setDefault make an entry the only default of its kind of its customer
+func (b *Book) setDefault(customerID string, id string, kind string) {
+	now := b.now().UTC()
+
+	for i := range b.entries {
+		e := &b.entries[i]
+
+		if e.CustomerID != customerID {
+			continue
+		}
+
+		isDefault := e.ID == id
+
+		switch kind {
+		case Billing:
+			if e.DefaultBilling != isDefault {
+				e.DefaultBilling = isDefault
+				e.UpdatedAt = now
+			}
+		case Shipping:
+			if e.DefaultShipping != isDefault {
+				e.DefaultShipping = isDefault
+				e.UpdatedAt = now
+			}
+		}
+	}
+}
+
+// oldest ID of the oldest address of a customer, empty when it has none
+func (b *Book) oldest(customerID string) string {
+	var oldest *Entry
+
+	for i := range b.entries {
+		e := &b.entries[i]
+
+		if e.CustomerID == customerID && (oldest == nil || e.CreatedAt.Before(oldest.CreatedAt)) {
+			oldest = e
+		}
+	}
+
+	if oldest == nil {
+		return ""
+	}
+
+	return oldest.ID
+}
+
+func (b *Book) find(customerID string, id string) int {
+	for i, e := range b.entries {
+		if e.ID == id && e.CustomerID == customerID {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (b *Book) snapshot() []Entry {
+	return append([]Entry{}, b.entries...)
+}
+
+func newID() string {
+	b := make([]byte, 8)
+
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("addressbook: error generating address ID: %v", err))
+	}
+
+	return "addr_" + hex.EncodeToString(b)
+}
+
+func (b *Book) save() error {
+	if err := storage.WriteJSON(b.path, b.entries); err != nil {
+		return fmt.Errorf("addressbook: error saving addresses: %v", err)
+	}
+
+	return nil
+}
@@ -1,14 +1,19 @@
 package main
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"path"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/labstack/echo"
@@ -17,10 +22,26 @@ import (
 	"github.com/stripe/stripe-go/v72"
 	"github.com/stripe/stripe-go/v72/webhook"
 
+	"github.com/javierlopezdeancos/stipendivm/accounts"
+	"github.com/javierlopezdeancos/stipendivm/addressbook"
 	"github.com/javierlopezdeancos/stipendivm/config"
+	"github.com/javierlopezdeancos/stipendivm/consents"
 	"github.com/javierlopezdeancos/stipendivm/customers"
+	"github.com/javierlopezdeancos/stipendivm/disputes"
+	"github.com/javierlopezdeancos/stipendivm/events"
 	"github.com/javierlopezdeancos/stipendivm/inventory"
+	"github.com/javierlopezdeancos/stipendivm/invoices"
+	"github.com/javierlopezdeancos/stipendivm/limits"
+	"github.com/javierlopezdeancos/stipendivm/notifications"
+	"github.com/javierlopezdeancos/stipendivm/orders"
 	"github.com/javierlopezdeancos/stipendivm/payments"
+	"github.com/javierlopezdeancos/stipendivm/privacy"
+	"github.com/javierlopezdeancos/stipendivm/quotes"
+	"github.com/javierlopezdeancos/stipendivm/reconciliation"
+	"github.com/javierlopezdeancos/stipendivm/risk"
+	"github.com/javierlopezdeancos/stipendivm/sweeper"
+	"github.com/javierlopezdeancos/stipendivm/trade"
+	"github.com/javierlopezdeancos/stipendivm/vat"
 	"github.com/javierlopezdeancos/stipendivm/webhooks"
 	"github.com/javierlopezdeancos/stipendivm/wine"
 )
@@ -28,6 +49,15 @@ import (
 func main() {
 	rootDirectory := flag.String("root", "./", "Root directory of the Stipendivm server to Quantvm stripe payments")
 	environment := flag.String("env", "dev", "Type of environment to start Stipendivm server")
+	sweep := flag.Bool("sweep", false, "Cancel abandoned payment intents once and exit")
+	dryRun := flag.Bool("dry-run", false, "With -sweep or -merge-customers, only list what would be changed")
+	mergeCustomers := flag.Bool("merge-customers", false, "Merge the customers sharing an email into the oldest one and exit")
+	reconcile := flag.Bool("reconcile", false, "Print the reconciliation report of Stripe balance transactions and orders and exit")
+	from := flag.String("from", "", "With -reconcile, first day of the report, YYYY-MM-DD")
+	to := flag.String("to", "", "With -reconcile, last day of the report, YYYY-MM-DD, the from day by default")
+	format := flag.String("format", "json", "With -reconcile, json or csv")
+	table := flag.String("table", reconciliation.TableDays, "With -reconcile and -format csv, days or unmatched")
+	fixture := flag.String("fixture", "", "With -reconcile, read the balance transactions and payouts from a recorded fixture file")
 
 	flag.Parse()
 
@@ -47,287 +77,2014 @@ func main() {
 		panic("STRIPE_SECRET_KEY must be in environment")
 	}
 
+	// point to stripe-mock, http://localhost:12111, to run without a Stripe account
+	if apiURL := os.Getenv("STRIPE_API_URL"); apiURL != "" {
+		stripe.SetBackend(stripe.APIBackend, stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{
+			URL: stripe.String(apiURL),
+		}))
+	}
+
 	config.PublicDirectory = path.Join(*rootDirectory, "public")
+	config.DataDirectory = path.Join(*rootDirectory, "data")
+
+	if err := openStores(); err != nil {
+		panic(err)
+	}
+
+	sweeperConfig := config.GetSweeper()
+
+	// the server holds the reservations, run a sweep that cancels with the server stopped or with POST /admin/sweeps
+	if *sweep {
+		sweeper.Sweep(sweeperConfig.MaxAge, *dryRun).Print()
+		return
+	}
+
+	// the server holds the orders, run a merge that changes them with the server stopped or with
+	// POST /admin/customer-merges
+	if *mergeCustomers {
+		customers.Merge(*dryRun, orders.Default.ReassignCustomer).Print()
+		return
+	}
+
+	if *reconcile {
+		if err := printReconciliation(*from, *to, *format, *table, *fixture); err != nil {
+			fmt.Printf("🔴 [ERROR] %v\n", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if err := openAccounts(); err != nil {
+		panic(err)
+	}
+
+	if v := config.GetVATValidation(); v.Online {
+		vat.Default = vat.NewChecker(vat.NewVIES(v.Timeout), v.CacheTTL)
+	}
+
+	if sweeperConfig.Interval > 0 {
+		go sweeper.Run(sweeperConfig.Interval, sweeperConfig.MaxAge)
+	}
+
 	server := getServer()
 
-	port := os.Getenv("PORT")
+	port := os.Getenv("PORT")
+
+	if port == "" {
+		server.Logger.Fatal(server.Start(":4567"))
+	}
+
+	server.Logger.Fatal(server.Start(":" + port))
+}
+
+// printReconciliation print the reconciliation report of a date range, from Stripe or a recorded fixture
+func printReconciliation(from string, to string, format string, table string, fixture string) error {
+	if to == "" {
+		to = from
+	}
+
+	start, end, err := reconciliation.ParseRange(from, to)
+
+	if err != nil {
+		return err
+	}
+
+	var source reconciliation.Source = reconciliation.StripeSource{}
+
+	if fixture != "" {
+		source, err = reconciliation.LoadFixture(fixture)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	report, err := reconciliation.Reconcile(source, start, end, orders.Default.List(nil))
+
+	if err != nil {
+		return err
+	}
+
+	if format == "csv" {
+		return report.WriteCSV(os.Stdout, table)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(report)
+}
+
+// openStores open the local stores and senders the server and its commands use
+func openStores() error {
+	registry, err := invoices.NewRegistry(path.Join(config.DataDirectory, "invoices.json"), config.GetIssuer())
+
+	if err != nil {
+		return err
+	}
+
+	invoices.Default = registry
+
+	mailer := config.GetMailer()
+
+	if !path.IsAbs(mailer.Directory) {
+		mailer.Directory = path.Join(config.DataDirectory, mailer.Directory)
+	}
+
+	notifier, err := notifications.NewNotifier(mailer)
+
+	if err != nil {
+		return err
+	}
+
+	notifications.Default = notifier
+
+	orderStore, err := orders.NewStore(path.Join(config.DataDirectory, "orders.json"))
+
+	if err != nil {
+		return err
+	}
+
+	orders.Default = orderStore
+
+	reservations, err := inventory.NewReservationStore(path.Join(config.DataDirectory, "reservations.json"))
+
+	if err != nil {
+		return err
+	}
+
+	inventory.Reservations = reservations
+
+	events.Default = events.NewHub(20, time.Hour)
+
+	ledger, err := risk.NewLedger(path.Join(config.DataDirectory, "risk.json"), config.GetRisk())
+
+	if err != nil {
+		return err
+	}
+
+	risk.Default = ledger
+
+	consentLedger, err := consents.NewLedger(path.Join(config.DataDirectory, "consents.json"), config.GetPolicyVersions())
+
+	if err != nil {
+		return err
+	}
+
+	consents.Default = consentLedger
+
+	sentLog, err := notifications.NewLog(path.Join(config.DataDirectory, "notifications.json"))
+
+	if err != nil {
+		return err
+	}
+
+	notifications.Default.Log = sentLog
+
+	privacyLog, err := privacy.NewLog(path.Join(config.DataDirectory, "privacy-requests.json"))
+
+	if err != nil {
+		return err
+	}
+
+	privacy.Default = privacyLog
+
+	book, err := addressbook.NewBook(path.Join(config.DataDirectory, "addresses.json"))
+
+	if err != nil {
+		return err
+	}
+
+	addressbook.Default = book
+
+	priceLists, err := trade.NewPriceLists(path.Join(config.DataDirectory, "price-lists.json"))
+
+	if err != nil {
+		return err
+	}
+
+	trade.Default = priceLists
+
+	return nil
+}
+
+// openAccounts open the login links and sessions of the customers, only the server needs them
+func openAccounts() error {
+	auth := config.GetAuth()
+
+	links, err := accounts.NewLinks(path.Join(config.DataDirectory, "login-links.json"), auth.LinkTTL)
+
+	if err != nil {
+		return err
+	}
+
+	sessions, err := accounts.NewSessions(auth.Secret, auth.SessionTTL)
+
+	if err != nil {
+		return err
+	}
+
+	accounts.Default = &accounts.Accounts{
+		Links:    links,
+		Sessions: sessions,
+		Sender:   &accounts.NotifierSender{Notifier: notifications.Default},
+		LoginURL: auth.LoginURL,
+		Limiter:  accounts.NewRateLimiter(auth.LinkRateLimit, auth.LinkRateWindow),
+	}
+
+	return nil
+}
+
+type listing struct {
+	Data interface{} `json:"data"`
+}
+
+// Types to different environments
+var Types = map[string]string{
+	"test":       "test",
+	"production": "prod",
+}
+
+func getWines(c echo.Context) error {
+	wines, err := inventory.ListWines()
+
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, listing{wines})
+}
+
+func getWineSkus(c echo.Context) error {
+	skus, err := inventory.ListSKUs(c.Param("wine_id"))
+
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, listing{skus})
+}
+
+func getWine(c echo.Context) error {
+	wine, err := inventory.RetrieveWine(c.Param("wine_id"))
+
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	return c.JSON(http.StatusOK, wine)
+}
+
+func getWinePrice(c echo.Context) error {
+	price, err := inventory.ListPrices(c.Param("wine_id"))
+
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, price)
+}
+
+func getPrices(c echo.Context) error {
+	prices, err := inventory.ListPrices()
+
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, listing{prices})
+}
+
+type RequestErrorMetaWine struct {
+	Id        string
+	Stock     string
+	Limit     string `json:",omitempty"`
+	Max       int64  `json:",omitempty"`
+	Purchased int64  `json:",omitempty"`
+	Minimum   int64  `json:",omitempty"`
+	Requested int64  `json:",omitempty"`
+}
+
+type RequestErrorMeta struct {
+	Wines  []RequestErrorMetaWine
+	Fields []customers.FieldError `json:",omitempty"`
+}
+
+type RequestCustomError struct {
+	Code    string
+	Message string
+	Meta    RequestErrorMeta
+}
+
+// ageVerificationError checkout or customer rejected because the customer age could not be verified
+func ageVerificationError(err *customers.AgeError) *RequestCustomError {
+	messages := map[string]string{
+		customers.AgeNotDeclared: "Sorry, you must declare your date of birth and that you are of legal age to buy wine",
+		customers.AgeInvalid:     "Sorry, the date of birth is not valid",
+		customers.AgeUnderage:    fmt.Sprint("Sorry, you must be at least ", err.MinimumAge, " years old to buy wine in ", err.Country),
+	}
+
+	return &RequestCustomError{
+		Code:    err.Code,
+		Message: messages[err.Code],
+	}
+}
+
+// customerValidationError customer rejected because some of its fields are not valid
+func customerValidationError(err *customers.ValidationError) *RequestCustomError {
+	return &RequestCustomError{
+		Code:    "invalid_fields",
+		Message: "Sorry, some customer fields are not valid",
+		Meta: RequestErrorMeta{
+			Fields: err.Fields,
+		},
+	}
+}
+
+// purchaseLimitError explain which wines of the cart are over which purchase limit
+func purchaseLimitError(violations []limits.Violation, names map[string]string) *RequestCustomError {
+	reasons := map[string]string{
+		limits.PerOrder:     "per order",
+		limits.PerCustomer:  "per customer",
+		limits.PerHousehold: "per household",
+	}
+
+	e := &RequestCustomError{
+		Code: "purchase_limit",
+		Meta: RequestErrorMeta{
+			Wines: []RequestErrorMetaWine{},
+		},
+	}
+
+	for _, v := range violations {
+		e.Meta.Wines = append(e.Meta.Wines, RequestErrorMetaWine{
+			Id:        v.Wine,
+			Limit:     v.Limit,
+			Max:       v.Max,
+			Purchased: v.Purchased,
+		})
+	}
+
+	v := violations[0]
+	e.Message = fmt.Sprint(
+		"Sorry, the wine ", names[v.Wine], " is limited to ", v.Max, " bottles ", reasons[v.Limit],
+	)
+
+	if v.Purchased > 0 {
+		e.Message += fmt.Sprint(" and ", v.Purchased, " have already been bought")
+	}
+
+	return e
+}
+
+// minimumQuantityError explain which wines of the cart, or the cart itself, are under the minimums of the trade price
+// list
+func minimumQuantityError(minimums []trade.Minimum, names map[string]string) *RequestCustomError {
+	e := &RequestCustomError{
+		Code: "minimum_quantity",
+		Meta: RequestErrorMeta{
+			Wines: []RequestErrorMetaWine{},
+		},
+	}
+
+	for _, m := range minimums {
+		if m.Wine == "" {
+			continue
+		}
+
+		e.Meta.Wines = append(e.Meta.Wines, RequestErrorMetaWine{
+			Id:        m.Wine,
+			Minimum:   m.Minimum,
+			Requested: m.Requested,
+		})
+	}
+
+	m := minimums[0]
+
+	if m.Wine == "" {
+		e.Message = fmt.Sprint("Sorry, trade orders must have at least ", m.Minimum, " bottles")
+	} else {
+		e.Message = fmt.Sprint("Sorry, the wine ", names[m.Wine], " is sold to trade in at least ", m.Minimum, " bottles")
+	}
+
+	return e
+}
+
+func hasWineAtLeastOneBottle(quantity int64) bool {
+	return quantity > 0
+}
+func getPaymentIntent(c echo.Context) error {
+	/* **********************************************************
+	// mock the error to no wine bottle stock
+
+	noStockError := &RequestCustomError{
+		Message: "A wine in shopping cart has quantity great than stock",
+		Meta: RequestErrorMeta{
+			Wines: []RequestErrorMetaWine{
+				{
+					Id:    "product-wine-bottle-75cl-cristal-sel-d-aiz-yenda-albarinio-godello",
+					Stock: "5",
+				},
+			},
+		},
+	}
+
+	return c.JSON(http.StatusNotAcceptable, noStockError)
+
+	***********************************************************/
+
+	ir := new(payments.IntentCreationRequest)
+	err := c.Bind(ir)
+
+	if err != nil {
+		return err
+	}
+
+	// the customer is the one logged in, a customer ID sent by the client is only accepted when it is the same
+	customerID := accounts.CustomerID(c)
+
+	if ir.CustomerID != "" && ir.CustomerID != customerID {
+		return c.JSON(http.StatusUnauthorized, loginRequiredError())
+	}
+
+	if ir.ShippingAddressID != "" && customerID == "" {
+		return c.JSON(http.StatusUnauthorized, loginRequiredError())
+	}
+
+	ir.CustomerID = customerID
+
+	return checkout(c, ir)
+}
+
+func shippingAddressRequiredError() *RequestCustomError {
+	return &RequestCustomError{
+		Code:    "shipping_address_required",
+		Message: "Sorry, orders shipped without Spanish VAT need a shipping address of your address book",
+	}
+}
+
+func loginRequiredError() *RequestCustomError {
+	return &RequestCustomError{
+		Code:    "login_required",
+		Message: "Sorry, you need to log in to buy with your account",
+	}
+}
+
+// requirePaymentIntentOwner let only who checked out a payment intent change or confirm it. The payment intent of a
+// customer needs its session, a guest has none and proves the payment intent is its own with its client secret, sent
+// in the X-Client-Secret header.
+func requirePaymentIntentOwner(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		order, ok := orders.Default.FindByPaymentIntent(c.Param("id"))
+
+		if !ok {
+			return c.JSON(http.StatusNotFound, &RequestCustomError{Message: "Sorry, the payment does not exist"})
+		}
+
+		if order.CustomerID != "" {
+			switch accounts.CustomerID(c) {
+			case order.CustomerID:
+				return next(c)
+			case "":
+				return c.JSON(http.StatusUnauthorized, loginRequiredError())
+			default:
+				return c.JSON(http.StatusForbidden, &RequestCustomError{Message: "Sorry, you can only change your own payments"})
+			}
+		}
+
+		pi, err := payments.RetrieveIntent(order.PaymentIntentID)
+
+		if err != nil {
+			return err
+		}
+
+		secret := c.Request().Header.Get("X-Client-Secret")
+
+		if secret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(pi.ClientSecret)) != 1 {
+			return c.JSON(http.StatusUnauthorized, &RequestCustomError{
+				Code:    "client_secret_required",
+				Message: "Sorry, the payment can only be changed from its checkout",
+			})
+		}
+
+		return next(c)
+	}
+}
+
+// checkout check the stock of the cart wines and create its payment intent
+func checkout(c echo.Context, ir *payments.IntentCreationRequest) error {
+	var customer *stripe.Customer
+
+	if ir.CustomerID != "" {
+		var err error
+		customer, err = customers.Retrieve(ir.CustomerID)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	account, err := trade.AccountOf(customer)
+
+	if err != nil {
+		return err
+	}
+
+	if ir.PayByInvoice && account == nil {
+		return c.JSON(http.StatusForbidden, &RequestCustomError{
+			Code:    "trade_account_required",
+			Message: "Sorry, only trade accounts can pay by invoice",
+		})
+	}
+
+	if account != nil {
+		ir.UnitAmount = account.UnitAmount
+	}
+
+	ir.Buyer = vat.Default.BuyerOf(customer)
+
+	err = checkoutShipping(ir)
+
+	if _, ok := err.(*addressbook.NotFoundError); ok {
+		return c.JSON(http.StatusNotFound, &RequestCustomError{
+			Code:    "address_not_found",
+			Message: "Sorry, the shipping address is not in your address book",
+		})
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// VAT is only taken out for the destination the wines are really shipped to
+	if ir.Shipping == nil && quotes.IsZeroRated(ir.Buyer, ir.Destination) {
+		return c.JSON(http.StatusUnprocessableEntity, shippingAddressRequiredError())
+	}
+
+	assessment, err := assessCheckout(c, ir, customer)
+
+	if err != nil {
+		return err
+	}
+
+	if assessment.Decision == risk.Block {
+		return c.JSON(http.StatusForbidden, &RequestCustomError{
+			Code:    "checkout_blocked",
+			Message: "Sorry, we can not accept this payment right now, please contact us to complete your order",
+		})
+	}
+
+	ir.RequestThreeDSecure = assessment.Decision == risk.Challenge
+
+	err = customers.VerifyCheckoutAge(ir.CustomerID, ir.Destination.Country)
+
+	if ageError, ok := err.(*customers.AgeError); ok {
+		return c.JSON(http.StatusForbidden, ageVerificationError(ageError))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var wines []inventory.Item = ir.Items
+	rules := map[string]limits.Rule{}
+	names := map[string]string{}
+	stock := map[string]int64{}
+
+	for _, w := range inventory.Merge(wines) {
+		product, err := inventory.RetrieveWine(w.Parent)
+
+		if err != nil {
+			return err
+		}
+
+		wineHasAtLeastOneBottle := hasWineAtLeastOneBottle(w.Quantity)
+
+		if !wineHasAtLeastOneBottle {
+			noMoreThanOneBottleSelectedError := &RequestCustomError{
+				Message: fmt.Sprint(
+					"Sorry,the wine ", product.Name, " there are not bottles selected to create a paymen intent",
+				),
+			}
+
+			return c.JSON(http.StatusNotAcceptable, noMoreThanOneBottleSelectedError)
+		}
+
+		jsonMetadataProduct, err := json.Marshal(product.Metadata)
+
+		if err != nil {
+			return err
+		}
+
+		wineMetadata := wine.Metadata{}
+
+		if err := json.Unmarshal(jsonMetadataProduct, &wineMetadata); err != nil {
+			return err
+		}
+
+		quantity, err := strconv.Atoi(wineMetadata.Quantity)
+
+		if err != nil {
+			return err
+		}
+
+		rules[w.Parent] = limits.RuleFromMetadata(wineMetadata)
+		names[w.Parent] = product.Name
+		stock[w.Parent] = int64(quantity)
+
+		quantity -= int(inventory.Reservations.Reserved(w.Parent, ""))
+
+		if quantity < int(w.Quantity) {
+			return c.JSON(http.StatusNotAcceptable, noWineStockError(product.Name, w.Quantity))
+		}
+	}
+
+	household := ""
+
+	if ir.Shipping != nil {
+		household = customers.AddressHousehold(ir.Shipping.Address)
+	} else if customer != nil {
+		household = customers.Household(customer)
+	}
+
+	violations := limits.Check(wines, rules, ir.CustomerID, household, orders.Default.List(nil), time.Now())
+
+	if len(violations) > 0 {
+		return c.JSON(http.StatusNotAcceptable, purchaseLimitError(violations, names))
+	}
+
+	if account != nil {
+		if minimums := account.CheckMinimums(wines); len(minimums) > 0 {
+			return c.JSON(http.StatusNotAcceptable, minimumQuantityError(minimums, names))
+		}
+	}
+
+	// invoiced orders are not paid at checkout, the invoice is only sent once its wines are reserved and the client
+	// shows the order and its invoice instead
+	if ir.PayByInvoice {
+		order, err := trade.CreateInvoice(account, ir)
+
+		if err != nil {
+			return err
+		}
+
+		recorded, err := recordCheckout(order.PaymentIntentID, order.ID, ir.Items, stock, household, assessment)
+
+		if err != nil {
+			trade.Void(order)
+		}
+
+		if stockError, ok := err.(*inventory.StockError); ok {
+			return c.JSON(http.StatusNotAcceptable, noWineStockError(names[stockError.Wine], stockError.Requested))
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := trade.Send(recorded); err != nil {
+			trade.Void(recorded)
+			return err
+		}
+
+		return c.JSON(http.StatusCreated, map[string]*orders.Order{
+			"order": recorded,
+		})
+	}
+
+	pi, err := payments.CreateIntent(ir)
+
+	if paymentMethodError, ok := err.(*config.PaymentMethodError); ok {
+		return c.JSON(http.StatusBadRequest, &RequestCustomError{Message: paymentMethodError.Error()})
+	}
+
+	if err != nil {
+		return err
+	}
+
+	orderID := pi.Metadata[payments.MetadataOrderID]
+
+	// the stock is checked again when it is reserved, other checkouts may have taken the last bottles meanwhile
+	if _, err := recordCheckout(pi.ID, orderID, ir.Items, stock, household, assessment); err != nil {
+		if stockError, ok := err.(*inventory.StockError); ok {
+			if err := payments.CancelIntent(pi.ID); err != nil {
+				fmt.Printf("🔴 [ERROR] %v\n", err)
+			}
+
+			if _, err := orders.Default.UpdateStatus(orderID, orders.StatusCanceled); err != nil {
+				fmt.Printf("🔴 [ERROR] %v\n", err)
+			}
+
+			return c.JSON(http.StatusNotAcceptable, noWineStockError(names[stockError.Wine], stockError.Requested))
+		}
+
+		return err
+	}
+
+	// a saved payment method is only charged once its wines are reserved, a checkout without stock never charges it.
+	// A payment intent that can not be confirmed is canceled, its webhook releases the wines.
+	if ir.PaymentMethodID != "" {
+		confirmed, err := payments.ConfirmSavedIntent(pi, ir.OffSession)
+
+		if err != nil {
+			if err := payments.CancelIntent(pi.ID); err != nil {
+				fmt.Printf("🔴 [ERROR] %v\n", err)
+			}
+
+			return err
+		}
+
+		pi = confirmed
+	}
+
+	return c.JSON(
+		http.StatusOK,
+		map[string]*stripe.PaymentIntent{
+			"paymentIntent": pi,
+		},
+	)
+}
+
+// noWineStockError a wine without stock enough for the bottles of a checkout
+func noWineStockError(name string, quantity int64) *RequestCustomError {
+	return &RequestCustomError{
+		Message: fmt.Sprint(
+			"Sorry, the wine ",
+			name,
+			", not have stock enough to create your payment order with ",
+			quantity,
+			" bottles",
+		),
+	}
+}
+
+// recordCheckout reserve the wines of a checkout out of their stock and keep its household and risk assessment in its
+// order, the order updated
+func recordCheckout(paymentIntentID string, orderID string, items []inventory.Item, stock map[string]int64, household string, assessment *risk.Assessment) (*orders.Order, error) {
+	if err := inventory.Reservations.Reserve(paymentIntentID, items, stock); err != nil {
+		return nil, err
+	}
+
+	order, err := orders.Default.Update(orderID, func(o *orders.Order) {
+		o.Household = household
+		o.Risk = &orders.Risk{
+			AssessmentID: assessment.ID,
+			Score:        assessment.Score,
+			Decision:     string(assessment.Decision),
+			Review:       assessment.Decision != risk.Allow,
+		}
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := risk.Default.Attach(assessment.ID, paymentIntentID, orderID); err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+// checkoutShipping ship to the address picked from the address book, which is also where the checkout is quoted to,
+// or to the default shipping address of the customer when the checkout is quoted to it
+func checkoutShipping(ir *payments.IntentCreationRequest) error {
+	if ir.CustomerID == "" {
+		return nil
+	}
+
+	var entry addressbook.Entry
+
+	if ir.ShippingAddressID != "" {
+		picked, err := addressbook.Default.Get(ir.CustomerID, ir.ShippingAddressID)
+
+		if err != nil {
+			return err
+		}
+
+		entry = *picked
+	} else {
+		found, ok := addressbook.Default.DefaultFor(ir.CustomerID, addressbook.Shipping)
+
+		if !ok {
+			return nil
+		}
+
+		d := ir.Destination
+
+		if (d.Country != "" && d.Country != found.Address.Country) || (d.PostalCode != "" && d.PostalCode != found.Address.PostalCode) {
+			return nil
+		}
+
+		entry = found
+	}
+
+	ir.Destination = quotes.Destination{Country: entry.Address.Country, PostalCode: entry.Address.PostalCode}
+	ir.Shipping = &customers.Shipping{Address: entry.Address, Name: entry.Name, Phone: entry.Phone}
+
+	return nil
+}
+
+// clientIP IP address of the client of a request. Headers are only believed when the request comes from a trusted
+// proxy, then the client is the last address of X-Forwarded-For not added by a trusted proxy.
+func clientIP(c echo.Context) string {
+	remote, _, err := net.SplitHostPort(c.Request().RemoteAddr)
+
+	if err != nil {
+		remote = c.Request().RemoteAddr
+	}
+
+	proxies := config.GetTrustedProxies()
+	trusted := func(ip string) bool {
+		for _, proxy := range proxies {
+			if ip == proxy {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if !trusted(remote) {
+		return remote
+	}
+
+	forwarded := strings.Split(c.Request().Header.Get(echo.HeaderXForwardedFor), ",")
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		if ip := strings.TrimSpace(forwarded[i]); ip != "" && !trusted(ip) {
+			return ip
+		}
+	}
+
+	return remote
+}
+
+// assessCheckout score a checkout attempt for fraud and card testing, the customer is nil for guests
+func assessCheckout(c echo.Context, ir *payments.IntentCreationRequest, customer *stripe.Customer) (*risk.Assessment, error) {
+	attempt := risk.Attempt{
+		IP:              clientIP(c),
+		CustomerID:      ir.CustomerID,
+		ShippingCountry: ir.Destination.Country,
+	}
+
+	if customer != nil {
+		attempt.Email = customer.Email
+		attempt.BillingCountry = customer.Address.Country
+	}
+
+	for _, item := range ir.Items {
+		attempt.Bottles += item.Quantity
+	}
+
+	assessment, err := risk.Default.Assess(attempt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if assessment.Decision != risk.Allow {
+		fmt.Printf(
+			"🔵 [INFO] Checkout from %s for customer %s scored %d: %s\n",
+			attempt.IP,
+			attempt.CustomerID,
+			assessment.Score,
+			assessment.Decision,
+		)
+	}
+
+	return assessment, nil
+}
+
+type reorderRequest struct {
+	ShippingOption    config.ShippingOption `json:"shippingOption"`
+	Destination       quotes.Destination    `json:"destination"`
+	PromoCode         string                `json:"promoCode"`
+	PaymentMethodID   string                `json:"paymentMethodId"`
+	ShippingAddressID string                `json:"shippingAddressId"`
+}
+
+// reorder checkout again the wines of a past order
+func reorder(c echo.Context) error {
+	order, ok := orders.Default.Get(c.Param("id"))
+
+	if !ok {
+		return c.JSON(http.StatusNotFound, &RequestCustomError{Message: "Sorry, the order to repeat does not exist"})
+	}
+
+	// guest orders have no customer to pay them again, only the customer of an order repeats it
+	if order.CustomerID == "" || order.CustomerID != accounts.CustomerID(c) {
+		return c.JSON(http.StatusForbidden, &RequestCustomError{Message: "Sorry, you can only repeat your own orders"})
+	}
+
+	r := new(reorderRequest)
+
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+
+	ir := &payments.IntentCreationRequest{
+		Currency:          order.Currency,
+		CustomerID:        order.CustomerID,
+		Items:             order.Items,
+		ShippingOption:    r.ShippingOption,
+		Destination:       r.Destination,
+		PromoCode:         r.PromoCode,
+		PaymentMethodID:   r.PaymentMethodID,
+		ShippingAddressID: r.ShippingAddressID,
+	}
+
+	return checkout(c, ir)
+}
+
+func getQuote(c echo.Context) error {
+	r := new(quotes.Request)
+	err := c.Bind(r)
+
+	if err != nil {
+		return err
+	}
+
+	unitAmount := inventory.UnitAmount
+
+	// logged in trade customers are quoted at the prices of their price list, and EU businesses under reverse charge
+	if customerID := accounts.CustomerID(c); customerID != "" {
+		customer, err := customers.Retrieve(customerID)
+
+		if err != nil {
+			return err
+		}
+
+		account, err := trade.AccountOf(customer)
+
+		if err != nil {
+			return err
+		}
+
+		if account != nil {
+			unitAmount = account.UnitAmount
+		}
+
+		r.Buyer = vat.Default.BuyerOf(customer)
+	}
+
+	q, err := quotes.Calculate(r, unitAmount)
+
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &RequestCustomError{Message: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]*quotes.Quote{
+		"quote": q,
+	})
+}
+
+func getPaymentMethods(c echo.Context) error {
+	currency := c.QueryParam("currency")
+
+	if currency == "" {
+		currency = config.Default().Currency
+	}
+
+	return c.JSON(http.StatusOK, listing{config.GetCompatiblePaymentMethods(currency, c.QueryParam("country"))})
+}
+
+func getPaymentIntentShippingChange(c echo.Context) error {
+	r := new(payments.IntentShippingChangeRequest)
+	err := c.Bind(r)
+
+	if err != nil {
+		return err
+	}
+
+	current, err := payments.RetrieveIntent(c.Param("id"))
+
+	if err != nil {
+		return err
+	}
+
+	// an invoice is final once sent, it is voided and ordered again to ship it elsewhere
+	if order, ok := orders.Default.FindByPaymentIntent(current.ID); ok && order.Invoice != nil {
+		return c.JSON(http.StatusConflict, &RequestCustomError{
+			Code:    "order_invoiced",
+			Message: "Sorry, the shipping of an invoiced order can not be changed",
+		})
+	}
+
+	// the wines were checked against stock, purchase limits and minimums at checkout, only the shipping can change
+	items := payments.Items(current)
+
+	if len(r.Items) > 0 && !inventory.SameItems(r.Items, items) {
+		return c.JSON(http.StatusConflict, &RequestCustomError{
+			Code:    "items_changed",
+			Message: "Sorry, the wines of a checkout can not be changed, please start a new checkout",
+		})
+	}
+
+	r.Items = items
+
+	// the wines ship to the address of the payment intent, which also set the household of the order, a checkout is
+	// started again to ship them elsewhere
+	if destination, ok := payments.ShippingDestination(current); ok {
+		if !sameDestination(r.Destination, destination) {
+			return c.JSON(http.StatusConflict, &RequestCustomError{
+				Code:    "shipping_address_fixed",
+				Message: "Sorry, the shipping address of a checkout can not be changed, please start a new checkout",
+			})
+		}
+
+		r.Destination = destination
+	}
+
+	r.UnitAmount, r.Buyer, err = paymentIntentPricing(current)
+
+	if err != nil {
+		return err
+	}
+
+	if _, ok := payments.ShippingDestination(current); !ok && quotes.IsZeroRated(r.Buyer, r.Destination) {
+		return c.JSON(http.StatusUnprocessableEntity, shippingAddressRequiredError())
+	}
+
+	// the wines do not change, they stay reserved as they were at checkout
+	pi, err := payments.UpdateShipping(current.ID, r)
+
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, map[string]*stripe.PaymentIntent{
+		"paymentIntent": pi,
+	})
+}
+
+// paymentIntentPricing prices of the price list of the trade customer of a payment intent, nil for the catalog ones,
+// and the business buying it under reverse charge
+func paymentIntentPricing(pi *stripe.PaymentIntent) (quotes.UnitAmountFunc, *quotes.Buyer, error) {
+	if pi.Customer == nil {
+		return nil, nil, nil
+	}
+
+	customer, err := customers.Retrieve(pi.Customer.ID)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	account, err := trade.AccountOf(customer)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var unitAmount quotes.UnitAmountFunc
+
+	if account != nil {
+		unitAmount = account.UnitAmount
+	}
+
+	return unitAmount, vat.Default.BuyerOf(customer), nil
+}
+
+// sameDestination whether a destination requested is the one of a shipping address, an empty destination is the
+// shipping address one
+func sameDestination(requested quotes.Destination, shipping quotes.Destination) bool {
+	if requested.Country == "" && requested.PostalCode == "" {
+		return true
+	}
+
+	return strings.EqualFold(strings.TrimSpace(requested.Country), shipping.Country) &&
+		strings.TrimSpace(requested.PostalCode) == shipping.PostalCode
+}
+
+func getPaymentIntentStatus(c echo.Context) error {
+	pi, err := payments.RetrieveIntent(c.Param("id"))
+
+	if err != nil {
+		return err
+	}
+
+	locale := c.QueryParam("locale")
+
+	if locale == "" {
+		locale = c.Request().Header.Get("Accept-Language")
+	}
+
+	return c.JSON(http.StatusOK, payments.Status(pi, locale))
+}
+
+func updatePaymentIntentShipment(c echo.Context) error {
+	r := new(payments.IntentShipmentRequest)
+	err := c.Bind(r)
+
+	if err != nil {
+		return err
+	}
+
+	pi, err := payments.UpdateShipment(c.Param("id"), r)
+
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &RequestCustomError{Message: err.Error()})
+	}
+
+	err = notifications.Default.NotifyPaymentIntent(notifications.Shipment, pi, notifications.Data{
+		Carrier:        r.Carrier,
+		TrackingNumber: r.TrackingNumber,
+	})
+
+	if err != nil {
+		fmt.Printf("🔴 [ERROR] %v\n", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]*stripe.PaymentIntent{
+		"paymentIntent": pi,
+	})
+}
+
+func getDisputeEvidence(c echo.Context) error {
+	d, evidence, err := disputes.Assemble(c.Param("id"))
+
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &RequestCustomError{Message: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"dispute":  d,
+		"evidence": evidence,
+	})
+}
+
+func submitDisputeEvidence(c echo.Context) error {
+	d, evidence, err := disputes.Submit(c.Param("id"))
+
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &RequestCustomError{Message: err.Error()})
+	}
+
+	fmt.Printf("🔵 [INFO] Evidence submitted for dispute %s\n", d.ID)
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"dispute":  d,
+		"evidence": evidence,
+	})
+}
+
+// paymentIntentEvent payment intent status and its order status pushed to the customer
+type paymentIntentEvent struct {
+	payments.PaymentIntentsStatus
+	OrderStatus orders.Status `json:"orderStatus,omitempty"`
+}
+
+func writePaymentIntentEvent(w io.Writer, id string, eventType string, pi *stripe.PaymentIntent, locale string) error {
+	data := paymentIntentEvent{
+		PaymentIntentsStatus: payments.Status(pi, locale),
+	}
+
+	if o, ok := orders.Default.FindByPaymentIntent(pi.ID); ok {
+		data.OrderStatus = o.Status
+	}
+
+	payload, err := json.Marshal(data)
+
+	if err != nil {
+		return err
+	}
+
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, payload)
+
+	return err
+}
+
+// streamPaymentIntentEvents push the payment intent status as Server-Sent Events each time a webhook changes it
+func streamPaymentIntentEvents(c echo.Context) error {
+	// subscribed before the current status is read, an event published meanwhile is streamed after it and not lost
+	lastEventID := c.Request().Header.Get("Last-Event-ID")
+	missed, subscription, unsubscribe := events.Default.Subscribe(c.Param("id"), lastEventID)
+	defer unsubscribe()
+
+	pi, err := payments.RetrieveIntent(c.Param("id"))
+
+	if err != nil {
+		return c.JSON(http.StatusNotFound, &RequestCustomError{Message: err.Error()})
+	}
+
+	locale := c.QueryParam("locale")
+
+	if locale == "" {
+		locale = c.Request().Header.Get("Accept-Language")
+	}
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprint(w, "retry: 3000\n\n")
+
+	// a new stream starts with the current status, a reconnection only with what it missed, or with the current status
+	// too when the events it missed are no longer kept
+	if lastEventID == "" || len(missed) == 0 {
+		if err := writePaymentIntentEvent(w, "", "status", pi, locale); err != nil {
+			return err
+		}
+	}
+
+	for _, e := range missed {
+		if err := writePaymentIntentEvent(w, e.ID, e.Type, e.Data.(*stripe.PaymentIntent), locale); err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			w.Flush()
+		case e, ok := <-subscription:
+			if !ok {
+				return nil
+			}
+
+			if err := writePaymentIntentEvent(w, e.ID, e.Type, e.Data.(*stripe.PaymentIntent), locale); err != nil {
+				return err
+			}
+
+			w.Flush()
+		}
+	}
+}
+
+func confirmPaymentIntent(c echo.Context) error {
+	r := new(payments.IntentConfirmationRequest)
+	err := c.Bind(r)
+
+	if err != nil {
+		return err
+	}
+
+	pi, err := payments.ConfirmIntent(c.Param("id"), r, payments.MandateAcceptance{
+		IPAddress: clientIP(c),
+		UserAgent: c.Request().UserAgent(),
+	})
+
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &RequestCustomError{Message: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]*stripe.PaymentIntent{
+		"paymentIntent": pi,
+	})
+}
+
+func updatePaymentIntentCurrency(c echo.Context) error {
+	r := new(payments.IntentCurrencyPaymentMethodsChangeRequest)
+	err := c.Bind(r)
+
+	if err != nil {
+		return err
+	}
+
+	current, err := payments.RetrieveIntent(c.Param("id"))
+
+	if err != nil {
+		return err
+	}
+
+	// an invoice is final once sent, in the currency it was issued in
+	if order, ok := orders.Default.FindByPaymentIntent(current.ID); ok && order.Invoice != nil {
+		return c.JSON(http.StatusConflict, &RequestCustomError{
+			Code:    "order_invoiced",
+			Message: "Sorry, the currency of an invoiced order can not be changed",
+		})
+	}
+
+	r.UnitAmount, r.Buyer, err = paymentIntentPricing(current)
+
+	if err != nil {
+		return err
+	}
+
+	if _, ok := payments.ShippingDestination(current); !ok && quotes.IsZeroRated(r.Buyer, quotes.Destination{Country: r.Country}) {
+		return c.JSON(http.StatusUnprocessableEntity, shippingAddressRequiredError())
+	}
+
+	// the wines do not change, they are quoted again in the new currency
+	pi, err := payments.UpdateCurrencyPaymentMethod(current.ID, r)
+
+	if paymentMethodError, ok := err.(*config.PaymentMethodError); ok {
+		return c.JSON(http.StatusBadRequest, &RequestCustomError{Message: paymentMethodError.Error()})
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, map[string]*stripe.PaymentIntent{
+		"paymentIntent": pi,
+	})
+}
+
+func createCustomer(c echo.Context) error {
+	fmt.Println()
+	fmt.Println("\n🔵 [INFO] Getting request to create customer...")
+	fmt.Println()
+
+	customer := new(customers.Customer)
+
+	if err := c.Bind(customer); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	newAddress := customers.Address{
+		City:       customer.Address.City,
+		Country:    customer.Address.Country,
+		PostalCode: customer.Address.PostalCode,
+		Province:   customer.Address.Province,
+		Street:     customer.Address.Street,
+	}
+
+	newCustomer := customers.Customer{
+		Address:        newAddress,
+		AgeDeclaration: customer.AgeDeclaration,
+		Company:        customer.Company,
+		DateOfBirth:    customer.DateOfBirth,
+		Email:          customer.Email,
+		FirstName:      customer.FirstName,
+		LastName:       customer.LastName,
+		Lgpd:           customer.Lgpd,
+		NifCif:         customer.NifCif,
+		Phone:          customer.Phone,
+		Shipping:       customer.Shipping,
+	}
+
+	customerCreated, created, err := customers.Create(newCustomer, accounts.CustomerID(c))
+
+	// the customer of an email is never changed nor returned without its session, its owner gets a login link
+	if existsError, ok := err.(*customers.ExistsError); ok {
+		// the conflict is answered as always, only the login link is not sent again once too many were requested
+		err := accounts.Default.RequestLink(existsError.Email, clientIP(c))
+
+		if _, ok := err.(*accounts.RateLimitError); !ok && err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusConflict, &RequestCustomError{
+			Code:    "customer_exists",
+			Message: "Sorry, there is already an account with this email, we have sent you a link to log in",
+		})
+	}
+
+	if ageError, ok := err.(*customers.AgeError); ok {
+		return c.JSON(http.StatusUnprocessableEntity, ageVerificationError(ageError))
+	}
+
+	if validationError, ok := err.(*customers.ValidationError); ok {
+		return c.JSON(http.StatusUnprocessableEntity, customerValidationError(validationError))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if customer.Lgpd {
+		_, err := consents.Default.Record(consents.Record{
+			CustomerID: customerCreated.ID,
+			Purpose:    consents.Privacy,
+			Action:     consents.Granted,
+			IPAddress:  clientIP(c),
+			UserAgent:  c.Request().UserAgent(),
+			Source:     "signup",
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	// a new customer is logged in right away, an existing one logs in with a login link
+	if created {
+		session, err := accounts.Default.Sessions.Issue(customerCreated.ID)
+
+		if err != nil {
+			return err
+		}
+
+		c.Response().Header().Set(headerSessionToken, session.Token)
+	}
+
+	return c.JSON(http.StatusOK, customerCreated)
+}
+
+// headerSessionToken response header with the session of a customer just created
+const headerSessionToken = "X-Session-Token"
+
+type loginLinkRequest struct {
+	Email string `json:"email"`
+}
+
+// requestLoginLink email a login link to the customer with the email, the response is the same when there is none
+func requestLoginLink(c echo.Context) error {
+	r := new(loginLinkRequest)
+
+	if err := c.Bind(r); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	if customers.NormalizeEmail(r.Email) == "" {
+		return c.JSON(http.StatusBadRequest, &RequestCustomError{Message: "Sorry, the email is required"})
+	}
+
+	err := accounts.Default.RequestLink(r.Email, clientIP(c))
+
+	if rateLimitError, ok := err.(*accounts.RateLimitError); ok {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int(rateLimitError.Window.Seconds())))
+
+		return c.JSON(http.StatusTooManyRequests, &RequestCustomError{
+			Code:    "too_many_requests",
+			Message: "Sorry, too many login links were requested, please try again later",
+		})
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+type sessionRequest struct {
+	Token string `json:"token"`
+}
+
+// createSession log in with the token of a login link
+func createSession(c echo.Context) error {
+	r := new(sessionRequest)
+
+	if err := c.Bind(r); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	session, err := accounts.Default.Login(r.Token)
+
+	if _, ok := err.(*accounts.LinkError); ok {
+		return c.JSON(http.StatusUnauthorized, &RequestCustomError{
+			Code:    "invalid_login_link",
+			Message: "Sorry, the login link is not valid anymore, ask for a new one",
+		})
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusCreated, session)
+}
+
+// getAccount customer logged in
+func getAccount(c echo.Context) error {
+	customer, err := customers.Get(accounts.CustomerID(c))
+
+	if err != nil {
+		return customerError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, customer)
+}
+
+// getTradeAccount trade account of the logged in customer with its price list, not found for retail customers
+func getTradeAccount(c echo.Context) error {
+	account, err := trade.Find(accounts.CustomerID(c))
+
+	if err != nil {
+		return err
+	}
+
+	if account == nil {
+		return c.JSON(http.StatusNotFound, &RequestCustomError{
+			Code:    "no_trade_account",
+			Message: "Sorry, your account does not buy at trade prices",
+		})
+	}
+
+	return c.JSON(http.StatusOK, account)
+}
+
+// addressError respond an address that is not in the book with a not found error, and invalid fields with their errors
+func addressError(c echo.Context, err error) error {
+	if _, ok := err.(*addressbook.NotFoundError); ok {
+		return c.JSON(http.StatusNotFound, &RequestCustomError{Message: "Sorry, the address is not in your address book"})
+	}
+
+	return customerError(c, err)
+}
+
+// listAddresses address book of the customer logged in
+func listAddresses(c echo.Context) error {
+	return c.JSON(http.StatusOK, listing{addressbook.Default.List(accounts.CustomerID(c))})
+}
+
+// addAddress save an address in the book of the customer logged in, its defaults are saved in Stripe
+func addAddress(c echo.Context) error {
+	customerID := accounts.CustomerID(c)
+	entry := new(addressbook.Entry)
+
+	if err := c.Bind(entry); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	added, err := addressbook.Default.Add(customerID, *entry)
+
+	if err != nil {
+		return addressError(c, err)
+	}
+
+	if err := addressbook.Default.SyncDefaults(customerID); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusCreated, added)
+}
+
+func updateAddress(c echo.Context) error {
+	customerID := accounts.CustomerID(c)
+	change := new(addressbook.Change)
+
+	if err := c.Bind(change); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	updated, err := addressbook.Default.Update(customerID, c.Param("id"), *change)
+
+	if err != nil {
+		return addressError(c, err)
+	}
+
+	if updated.DefaultBilling || updated.DefaultShipping {
+		if err := addressbook.Default.SyncDefaults(customerID); err != nil {
+			return err
+		}
+	}
+
+	return c.JSON(http.StatusOK, updated)
+}
+
+func deleteAddress(c echo.Context) error {
+	customerID := accounts.CustomerID(c)
+
+	if err := addressbook.Default.Delete(customerID, c.Param("id")); err != nil {
+		return addressError(c, err)
+	}
+
+	if err := addressbook.Default.SyncDefaults(customerID); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+type defaultAddressRequest struct {
+	Kind string `json:"kind"`
+}
+
+// setDefaultAddress make an address the default billing or shipping address of the customer logged in
+func setDefaultAddress(c echo.Context) error {
+	customerID := accounts.CustomerID(c)
+	r := new(defaultAddressRequest)
+
+	if err := c.Bind(r); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	if r.Kind != addressbook.Billing && r.Kind != addressbook.Shipping {
+		return c.JSON(http.StatusBadRequest, &RequestCustomError{
+			Message: fmt.Sprintf("Sorry, the default address kind must be %s or %s", addressbook.Billing, addressbook.Shipping),
+		})
+	}
+
+	updated, err := addressbook.Default.SetDefault(customerID, c.Param("id"), r.Kind)
+
+	if err != nil {
+		return addressError(c, err)
+	}
+
+	if err := addressbook.Default.SyncDefaults(customerID); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, updated)
+}
+
+type consentRequest struct {
+	Purpose       string `json:"purpose"`
+	Action        string `json:"action"`
+	PolicyVersion string `json:"policyVersion"`
+	Source        string `json:"source"`
+}
+
+// getCustomerConsents current consent of a customer for each purpose and every consent given or withdrawn
+func getCustomerConsents(c echo.Context) error {
+	customerID := c.Param("id")
+
+	if _, err := customers.Get(customerID); err != nil {
+		return customerError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"current":  consents.Default.Current(customerID),
+		"history":  consents.Default.History(customerID),
+		"versions": consents.Default.Versions(),
+	})
+}
+
+// recordCustomerConsent record a consent given or withdrawn, the privacy one is kept as the lgpd customer metadata
+func recordCustomerConsent(c echo.Context) error {
+	customerID := c.Param("id")
+	r := new(consentRequest)
 
-	if port == "" {
-		server.Logger.Fatal(server.Start(":4567"))
+	if err := c.Bind(r); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
 	}
 
-	server.Logger.Fatal(server.Start(":" + port))
+	if _, err := customers.Get(customerID); err != nil {
+		return customerError(c, err)
+	}
+
+	if r.Source == "" {
+		r.Source = "api"
+	}
+
+	record, err := consents.Default.Record(consents.Record{
+		CustomerID:    customerID,
+		Purpose:       r.Purpose,
+		Action:        r.Action,
+		PolicyVersion: r.PolicyVersion,
+		IPAddress:     clientIP(c),
+		UserAgent:     c.Request().UserAgent(),
+		Source:        r.Source,
+	})
+
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, &RequestCustomError{Message: err.Error()})
+	}
+
+	if record.Purpose == consents.Privacy {
+		granted := record.Action == consents.Granted
+
+		if _, err := customers.Update(customerID, customers.CustomerChange{Lgpd: &granted}); err != nil {
+			return err
+		}
+	}
+
+	return c.JSON(http.StatusCreated, record)
 }
 
-type listing struct {
-	Data interface{} `json:"data"`
+// normalizeAddress address normalized as it would be saved, with the errors of its fields
+func normalizeAddress(c echo.Context) error {
+	address := new(customers.Address)
+
+	if err := c.Bind(address); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	normalized, fields := customers.NormalizeAddress(*address, "address")
+
+	if len(fields) > 0 {
+		return c.JSON(http.StatusUnprocessableEntity, customerValidationError(&customers.ValidationError{Fields: fields}))
+	}
+
+	return c.JSON(http.StatusOK, map[string]customers.Address{
+		"address": normalized,
+	})
 }
 
-// Types to different environments
-var Types = map[string]string{
-	"test":       "test",
-	"production": "prod",
+// customerError respond a customer that does not exist with a not found error, and invalid fields with their errors
+func customerError(c echo.Context, err error) error {
+	if _, ok := err.(*customers.NotFoundError); ok {
+		return c.JSON(http.StatusNotFound, &RequestCustomError{Message: "Sorry, the customer does not exist"})
+	}
+
+	if validationError, ok := err.(*customers.ValidationError); ok {
+		return c.JSON(http.StatusUnprocessableEntity, customerValidationError(validationError))
+	}
+
+	if _, ok := err.(*customers.ExistsError); ok {
+		return c.JSON(http.StatusConflict, &RequestCustomError{
+			Code:    "customer_exists",
+			Message: "Sorry, there is already an account with this email",
+		})
+	}
+
+	return err
 }
 
-func getWines(c echo.Context) error {
-	wines, err := inventory.ListWines()
+func getCustomer(c echo.Context) error {
+	customer, err := customers.Get(c.Param("id"))
 
 	if err != nil {
-		return err
+		return customerError(c, err)
 	}
 
-	return c.JSON(http.StatusOK, listing{wines})
+	return c.JSON(http.StatusOK, customer)
 }
 
-func getWineSkus(c echo.Context) error {
-	skus, err := inventory.ListSKUs(c.Param("wine_id"))
+func updateCustomer(c echo.Context) error {
+	change := new(customers.CustomerChange)
+
+	if err := c.Bind(change); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	customer, err := customers.Update(c.Param("id"), *change)
 
 	if err != nil {
-		return err
+		return customerError(c, err)
 	}
 
-	return c.JSON(http.StatusOK, listing{skus})
+	return c.JSON(http.StatusOK, customer)
 }
 
-func getWine(c echo.Context) error {
-	wine, err := inventory.RetrieveWine(c.Param("wine_id"))
+// exportCustomer archive of every personal data kept about a customer, downloaded as a JSON file
+func exportCustomer(c echo.Context) error {
+	r := privacy.Request{
+		Type:        privacy.RequestExport,
+		CustomerID:  c.Param("id"),
+		IPAddress:   clientIP(c),
+		RequestedAt: time.Now().UTC(),
+	}
+
+	archive, err := privacy.Export(r.CustomerID)
+
+	if _, logErr := privacy.Default.Record(r, err); logErr != nil {
+		return logErr
+	}
 
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, err)
+		return customerError(c, err)
 	}
 
-	return c.JSON(http.StatusOK, wine)
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", r.CustomerID+".json"))
+
+	return c.JSON(http.StatusOK, archive)
 }
 
-func getWinePrice(c echo.Context) error {
-	price, err := inventory.ListPrices(c.Param("wine_id"))
+// eraseCustomer anonymize the personal data of a customer, refused while it has open orders
+func eraseCustomer(c echo.Context) error {
+	r := privacy.Request{
+		Type:        privacy.RequestErasure,
+		CustomerID:  c.Param("id"),
+		IPAddress:   clientIP(c),
+		RequestedAt: time.Now().UTC(),
+	}
+
+	erasure, err := privacy.Erase(r.CustomerID)
+	r.Erasure = erasure
+
+	if _, logErr := privacy.Default.Record(r, err); logErr != nil {
+		return logErr
+	}
+
+	if openOrdersError, ok := err.(*privacy.OpenOrdersError); ok {
+		return c.JSON(http.StatusConflict, &RequestCustomError{
+			Code:    "open_orders",
+			Message: fmt.Sprintf("Sorry, the customer can not be erased until its orders %v are completed", openOrdersError.Orders),
+		})
+	}
 
 	if err != nil {
-		return err
+		return customerError(c, err)
 	}
 
-	return c.JSON(http.StatusOK, price)
+	return c.JSON(http.StatusOK, erasure)
 }
 
-func getPrices(c echo.Context) error {
-	prices, err := inventory.ListPrices()
+// listPrivacyRequests export and erasure requests with their outcome, only the ones of a customer when given
+func listPrivacyRequests(c echo.Context) error {
+	return c.JSON(http.StatusOK, listing{privacy.Default.List(c.QueryParam("customer"))})
+}
+
+// listCustomers customers with the email given, every customer without it
+func listCustomers(c echo.Context) error {
+	list, err := customers.List(c.QueryParam("email"))
 
 	if err != nil {
 		return err
 	}
 
-	return c.JSON(http.StatusOK, listing{prices})
+	return c.JSON(http.StatusOK, listing{list})
 }
 
-type RequestErrorMetaWine struct {
-	Id    string
-	Stock string
-}
+// sweepPaymentIntents cancel the abandoned payment intents now, only list them with dryRun=true
+func sweepPaymentIntents(c echo.Context) error {
+	summary := sweeper.Sweep(config.GetSweeper().MaxAge, c.QueryParam("dryRun") == "true")
+	summary.Print()
 
-type RequestErrorMeta struct {
-	Wines []RequestErrorMetaWine
+	return c.JSON(http.StatusOK, summary)
 }
 
-type RequestCustomError struct {
-	Message string
-	Meta    RequestErrorMeta
+// mergeDuplicatedCustomers merge the customers sharing an email into the oldest one, only list them with dryRun=true
+func mergeDuplicatedCustomers(c echo.Context) error {
+	summary := customers.Merge(c.QueryParam("dryRun") == "true", orders.Default.ReassignCustomer)
+	summary.Print()
+
+	return c.JSON(http.StatusOK, summary)
 }
 
-func hasWineAtLeastOneBottle(quantity int64) bool {
-	return quantity > 0
+// listPriceLists every trade price list sorted by ID
+func listPriceLists(c echo.Context) error {
+	return c.JSON(http.StatusOK, listing{trade.Default.List()})
 }
-func getPaymentIntent(c echo.Context) error {
-	/* **********************************************************
-	// mock the error to no wine bottle stock
 
-	noStockError := &RequestCustomError{
-		Message: "A wine in shopping cart has quantity great than stock",
-		Meta: RequestErrorMeta{
-			Wines: []RequestErrorMetaWine{
-				{
-					Id:    "product-wine-bottle-75cl-cristal-sel-d-aiz-yenda-albarinio-godello",
-					Stock: "5",
-				},
-			},
-		},
-	}
+// savePriceList create or replace the price list with the ID of the path
+func savePriceList(c echo.Context) error {
+	l := trade.PriceList{}
 
-	return c.JSON(http.StatusNotAcceptable, noStockError)
+	if err := c.Bind(&l); err != nil {
+		return err
+	}
 
-	***********************************************************/
+	l.ID = c.Param("id")
+	saved, err := trade.Default.Save(l)
 
-	ir := new(payments.IntentCreationRequest)
-	err := c.Bind(ir)
+	if validationError, ok := err.(*customers.ValidationError); ok {
+		return c.JSON(http.StatusUnprocessableEntity, &RequestCustomError{
+			Code:    "invalid_fields",
+			Message: "Sorry, some price list fields are not valid",
+			Meta:    RequestErrorMeta{Fields: validationError.Fields},
+		})
+	}
 
 	if err != nil {
 		return err
 	}
 
-	var wines []inventory.Item = ir.Items
+	return c.JSON(http.StatusOK, saved)
+}
 
-	for _, w := range wines {
-		product, err := inventory.RetrieveWine(w.Parent)
+// tradeError respond customers that are not businesses and unknown price lists with their errors
+func tradeError(c echo.Context, err error) error {
+	switch e := err.(type) {
+	case *trade.NotBusinessError:
+		return c.JSON(http.StatusUnprocessableEntity, &RequestCustomError{
+			Code:    "not_business",
+			Message: "Sorry, trade accounts need a company name and a CIF or EU VAT number",
+		})
+	case *trade.PriceListNotFoundError:
+		return c.JSON(http.StatusNotFound, &RequestCustomError{
+			Code:    "price_list_not_found",
+			Message: fmt.Sprint("Sorry, the price list ", e.ID, " does not exist"),
+		})
+	}
 
-		if err != nil {
-			return err
-		}
+	return customerError(c, err)
+}
 
-		wineHasAtLeastOneBottle := hasWineAtLeastOneBottle(w.Quantity)
+// assignTradeAccount open the trade account of the business customer of the path, or change its price list and terms
+func assignTradeAccount(c echo.Context) error {
+	a := trade.Assignment{}
 
-		if !wineHasAtLeastOneBottle {
-			noMoreThanOneBottleSelectedError := &RequestCustomError{
-				Message: fmt.Sprint(
-					"Sorry,the wine ", product.Name, " there are not bottles selected to create a paymen intent",
-				),
-			}
+	if err := c.Bind(&a); err != nil {
+		return err
+	}
 
-			return c.JSON(http.StatusNotAcceptable, noMoreThanOneBottleSelectedError)
-		}
+	account, err := trade.Assign(c.Param("id"), a)
 
-		jsonMetadataProduct, err := json.Marshal(product.Metadata)
+	if err != nil {
+		return tradeError(c, err)
+	}
 
-		if err != nil {
-			return err
-		}
+	return c.JSON(http.StatusOK, account)
+}
 
-		wineMetadata := wine.Metadata{}
+// closeTradeAccount close the trade account of the customer of the path, who buys at the catalog prices again
+func closeTradeAccount(c echo.Context) error {
+	if err := trade.Close(c.Param("id")); err != nil {
+		return customerError(c, err)
+	}
 
-		if err := json.Unmarshal(jsonMetadataProduct, &wineMetadata); err != nil {
-			return err
-		}
+	return c.NoContent(http.StatusNoContent)
+}
 
-		quantity, err := strconv.Atoi(wineMetadata.Quantity)
+// listRiskAssessments checkout risk assessments, only the ones with a decision when given
+func listRiskAssessments(c echo.Context) error {
+	decision := risk.Decision(c.QueryParam("decision"))
 
-		if err != nil {
-			return err
-		}
+	return c.JSON(http.StatusOK, listing{risk.Default.List(func(a *risk.Assessment) bool {
+		return decision == "" || a.Decision == decision
+	})})
+}
 
-		if quantity < int(w.Quantity) {
-			noWineStockError := &RequestCustomError{
-				Message: fmt.Sprint(
-					"Sorry, the wine ",
-					product.Name,
-					", not have stock enough to create your payment order with ",
-					w.Quantity,
-					" bottles",
-				),
-			}
+func listInvoices(c echo.Context) error {
+	return c.JSON(http.StatusOK, listing{invoices.Default.Records()})
+}
 
-			return c.JSON(http.StatusNotAcceptable, noWineStockError)
-		}
-	}
+func verifyInvoices(c echo.Context) error {
+	err := invoices.Default.Verify()
 
-	pi, err := payments.CreateIntent(ir)
+	if chainError, ok := err.(*invoices.ChainError); ok {
+		return c.JSON(http.StatusConflict, map[string]interface{}{
+			"valid": false,
+			"error": chainError,
+		})
+	}
 
 	if err != nil {
 		return err
 	}
 
-	return c.JSON(
-		http.StatusOK,
-		map[string]*stripe.PaymentIntent{
-			"paymentIntent": pi,
-		},
-	)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"valid":   true,
+		"records": len(invoices.Default.Records()),
+	})
 }
 
-func getPaymentIntentShippingChange(c echo.Context) error {
-	r := new(payments.IntentShippingChangeRequest)
-	err := c.Bind(r)
+func exportInvoices(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMEApplicationXMLCharsetUTF8)
+	c.Response().WriteHeader(http.StatusOK)
 
-	if err != nil {
-		return err
+	return invoices.Default.ExportXML(c.Response())
+}
+
+// getReconciliation reconciliation report of a date range, as JSON or as a CSV table
+func getReconciliation(c echo.Context) error {
+	to := c.QueryParam("to")
+
+	if to == "" {
+		to = c.QueryParam("from")
 	}
 
-	pi, err := payments.UpdateShipping(c.Param("id"), r)
+	from, end, err := reconciliation.ParseRange(c.QueryParam("from"), to)
 
 	if err != nil {
-		return err
+		return c.JSON(http.StatusBadRequest, &RequestCustomError{Message: err.Error()})
 	}
 
-	return c.JSON(http.StatusOK, map[string]*stripe.PaymentIntent{
-		"paymentIntent": pi,
-	})
-}
-
-func getPaymentIntentStatus(c echo.Context) error {
-	pi, err := payments.RetrieveIntent(c.Param("id"))
+	report, err := reconciliation.Reconcile(reconciliation.StripeSource{}, from, end, orders.Default.List(nil))
 
 	if err != nil {
 		return err
 	}
 
-	p := payments.PaymentIntentsStatus{
-		PaymentIntent: payments.PaymentIntentsStatusData{
-			Status: string(pi.Status),
-		},
+	if c.QueryParam("format") != "csv" {
+		return c.JSON(http.StatusOK, report)
 	}
 
-	/*
-		if pi.LastPaymentError != nil {
-			p.PaymentIntent.LastPaymentError = pi.LastPaymentError.Message
-		}
-	*/
+	table := c.QueryParam("table")
 
-	return c.JSON(http.StatusOK, p)
-}
+	if table != "" && table != reconciliation.TableDays && table != reconciliation.TableUnmatched {
+		return c.JSON(http.StatusBadRequest, &RequestCustomError{Message: fmt.Sprintf("Sorry, the table %s does not exist", table)})
+	}
 
-func updatePaymentIntentCurrency(c echo.Context) error {
-	r := new(payments.IntentCurrencyPaymentMethodsChangeRequest)
-	err := c.Bind(r)
+	c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=reconciliation-%s-%s.csv", report.From, report.To))
+	c.Response().WriteHeader(http.StatusOK)
 
-	if err != nil {
-		return err
-	}
+	return report.WriteCSV(c.Response(), table)
+}
 
-	pi, err := payments.UpdateCurrencyPaymentMethod(c.Param("id"), r)
+func createCustomerSetupIntent(c echo.Context) error {
+	si, err := customers.CreateSetupIntent(c.Param("id"))
 
 	if err != nil {
 		return err
 	}
 
-	return c.JSON(http.StatusOK, map[string]*stripe.PaymentIntent{
-		"paymentIntent": pi,
+	return c.JSON(http.StatusOK, map[string]*stripe.SetupIntent{
+		"setupIntent": si,
 	})
 }
 
-func updateCustomer(c echo.Context) error {
-	fmt.Println()
-	fmt.Println("\n🔵 [INFO] Getting request to create customer...")
-	fmt.Println()
-
-	customer := new(customers.Customer)
-
-	if err := c.Bind(customer); err != nil {
-		return c.JSON(http.StatusBadRequest, err)
-	}
+func getCustomerPaymentMethods(c echo.Context) error {
+	paymentMethods, err := customers.ListPaymentMethods(c.Param("id"))
 
-	newAddress := customers.Address{
-		City:       customer.Address.City,
-		Country:    customer.Address.Country,
-		PostalCode: customer.Address.PostalCode,
-		Province:   customer.Address.Province,
-		Street:     customer.Address.Street,
+	if err != nil {
+		return err
 	}
 
-	newCustomer := customers.Customer{
-		Address:   newAddress,
-		Company:   customer.Company,
-		Email:     customer.Email,
-		FirstName: customer.FirstName,
-		LastName:  customer.LastName,
-		Lgpd:      customer.Lgpd,
-		NifCif:    customer.NifCif,
-		Phone:     customer.Phone,
-	}
+	return c.JSON(http.StatusOK, listing{paymentMethods})
+}
 
-	customerCreated, err := customers.Create(newCustomer)
+func deleteCustomerPaymentMethod(c echo.Context) error {
+	pm, err := customers.DetachPaymentMethod(c.Param("id"), c.Param("payment_method_id"))
 
 	if err != nil {
-		return err
+		return c.JSON(http.StatusNotFound, &RequestCustomError{Message: err.Error()})
 	}
 
-	return c.JSON(http.StatusOK, customerCreated)
+	return c.JSON(http.StatusOK, pm)
 }
 
 func handleWebhook(c echo.Context) error {
@@ -368,14 +2125,48 @@ func handleWebhook(c echo.Context) error {
 		}
 
 		handled, err = webhooks.HandlePaymentIntent(event, pi)
-	case "source":
-		var source *stripe.Source
-		err := json.Unmarshal(event.Data.Raw, &source)
+
+		if err == nil {
+			events.Default.Publish(pi.ID, string(event.Type), pi)
+		}
+	case "charge":
+		var charge *stripe.Charge
+		err = json.Unmarshal(event.Data.Raw, &charge)
+		if err != nil {
+			return err
+		}
+
+		handled, err = webhooks.HandleCharge(event, charge)
+
+		if handled && err == nil && charge.PaymentIntent != nil {
+			publishOrderStatus(charge.PaymentIntent.ID, event.Type)
+		}
+
+	case "dispute":
+		var d *stripe.Dispute
+		err = json.Unmarshal(event.Data.Raw, &d)
+		if err != nil {
+			return err
+		}
+
+		handled, err = webhooks.HandleDispute(event, d)
+
+		if handled && err == nil && d.PaymentIntent != nil {
+			publishOrderStatus(d.PaymentIntent.ID, event.Type)
+		}
+
+	case "invoice":
+		var inv *stripe.Invoice
+		err = json.Unmarshal(event.Data.Raw, &inv)
 		if err != nil {
 			return err
 		}
 
-		handled, err = webhooks.HandleSource(event, source)
+		handled, err = webhooks.HandleInvoice(event, inv)
+
+		if handled && err == nil && inv.PaymentIntent != nil {
+			publishOrderStatus(inv.PaymentIntent.ID, event.Type)
+		}
 	}
 
 	if err != nil {
@@ -389,6 +2180,30 @@ func handleWebhook(c echo.Context) error {
 	return nil
 }
 
+// publishOrderStatus push to the stream of a payment intent the status of its order changed by a refund, dispute or
+// invoice webhook, a payment intent that can not be retrieved is logged as the webhook was already handled
+func publishOrderStatus(paymentIntentID string, eventType string) {
+	pi, err := payments.RetrieveIntent(paymentIntentID)
+
+	if err != nil {
+		fmt.Printf("🔴 [ERROR] %v\n", err)
+		return
+	}
+
+	events.Default.Publish(pi.ID, eventType, pi)
+}
+
+// authorizeAdmin check the bearer key against ADMIN_API_KEY, admin routes are closed when it is not set
+func authorizeAdmin(key string, c echo.Context) (bool, error) {
+	adminKey := os.Getenv("ADMIN_API_KEY")
+
+	if adminKey == "" {
+		return false, nil
+	}
+
+	return subtle.ConstantTimeCompare([]byte(key), []byte(adminKey)) == 1, nil
+}
+
 func getServer() *echo.Echo {
 	server := echo.New()
 
@@ -421,14 +2236,75 @@ func getServer() *echo.Echo {
 	server.GET("/prices", getPrices)
 	server.GET("/prices/:wine_id", getWinePrice)
 
-	server.POST("/payment-intents", getPaymentIntent)
-	server.POST("/payment-intents/:id/shipping-change", getPaymentIntentShippingChange)
-	server.POST("/payment-intents/:id/currency", updatePaymentIntentCurrency)
+	authenticate := accounts.Default.Sessions.Authenticate()
+
+	server.POST("/quotes", getQuote, authenticate)
+
+	server.GET("/payment-methods", getPaymentMethods)
+
+	server.POST("/auth/login-links", requestLoginLink)
+	server.POST("/auth/sessions", createSession)
+	account := server.Group("/account", accounts.Default.Sessions.Require())
+
+	account.GET("", getAccount)
+	account.GET("/addresses", listAddresses)
+	account.POST("/addresses", addAddress)
+	account.PATCH("/addresses/:id", updateAddress)
+	account.DELETE("/addresses/:id", deleteAddress)
+	account.POST("/addresses/:id/default", setDefaultAddress)
+	account.GET("/trade", getTradeAccount)
+
+	server.POST("/payment-intents", getPaymentIntent, authenticate)
+	server.POST("/payment-intents/:id/shipping-change", getPaymentIntentShippingChange, authenticate, requirePaymentIntentOwner)
+	server.POST("/payment-intents/:id/currency", updatePaymentIntentCurrency, authenticate, requirePaymentIntentOwner)
+	server.POST("/payment-intents/:id/confirm", confirmPaymentIntent, authenticate, requirePaymentIntentOwner)
 	server.GET("/payment-intents/:id/status", getPaymentIntentStatus)
+	server.GET("/payment-intents/:id/events", streamPaymentIntentEvents)
+
+	server.POST("/addresses/normalize", normalizeAddress)
+
+	server.POST("/customers", createCustomer, authenticate)
+	customer := server.Group("/customers/:id", accounts.Default.Sessions.RequireCustomer("id"))
+
+	customer.GET("", getCustomer)
+	customer.PATCH("", updateCustomer)
+	customer.DELETE("", eraseCustomer)
+	customer.GET("/consents", getCustomerConsents)
+	customer.POST("/consents", recordCustomerConsent)
+	customer.GET("/export", exportCustomer)
+	customer.POST("/setup-intents", createCustomerSetupIntent)
+	customer.GET("/payment-methods", getCustomerPaymentMethods)
+	customer.DELETE("/payment-methods/:payment_method_id", deleteCustomerPaymentMethod)
 
-	server.POST("/customers", updateCustomer)
+	server.POST("/orders/:id/reorder", reorder, accounts.Default.Sessions.Require())
 
 	server.POST("/webhook/shopping-cart", handleWebhook)
 
+	admin := server.Group("/admin", middleware.KeyAuth(authorizeAdmin))
+
+	admin.GET("/invoices", listInvoices)
+	admin.GET("/invoices/verify", verifyInvoices)
+	admin.GET("/invoices/export", exportInvoices)
+
+	admin.POST("/payment-intents/:id/shipment", updatePaymentIntentShipment)
+
+	admin.POST("/sweeps", sweepPaymentIntents)
+
+	admin.GET("/customers", listCustomers)
+	admin.POST("/customer-merges", mergeDuplicatedCustomers)
+	admin.POST("/customers/:id/erasure", eraseCustomer)
+	admin.PUT("/customers/:id/trade", assignTradeAccount)
+	admin.DELETE("/customers/:id/trade", closeTradeAccount)
+	admin.GET("/price-lists", listPriceLists)
+	admin.PUT("/price-lists/:id", savePriceList)
+	admin.GET("/privacy-requests", listPrivacyRequests)
+
+	admin.GET("/reconciliation", getReconciliation)
+
+	admin.GET("/risk-assessments", listRiskAssessments)
+
+	admin.GET("/disputes/:id/evidence", getDisputeEvidence)
+	admin.POST("/disputes/:id/evidence", submitDisputeEvidence)
+
 	return server
 }
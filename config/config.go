@@ -1,8 +1,11 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Environments types map
@@ -17,6 +20,9 @@ var Environment string
 // PublicDirectory in server
 var PublicDirectory string
 
+// DataDirectory in server where local records are persisted
+var DataDirectory string
+
 // Configuration type to our stripe integration
 type Configuration struct {
 	StripePublishableKey string           `json:"stripePublishableKey"`
@@ -27,15 +33,27 @@ type Configuration struct {
 	ShippingOptions      []ShippingOption `json:"shippingOptions"`
 }
 
-// GetPaymentMethods get payments methods selected to the stripe integration
+// GetPaymentMethods get payments methods selected to the stripe integration,
+// SEPA Direct Debit is added when SEPA_DEBIT_ENABLED is true
 func GetPaymentMethods() []string {
 	paymentMethodsString := os.Getenv("PAYMENT_METHODS")
+	paymentMethods := []string{}
 
 	if paymentMethodsString == "" {
-		return []string{"card"}
+		paymentMethods = append(paymentMethods, "card")
+	}
+
+	for _, paymentMethod := range strings.Split(paymentMethodsString, ",") {
+		if paymentMethod = strings.TrimSpace(paymentMethod); paymentMethod != "" {
+			paymentMethods = append(paymentMethods, paymentMethod)
+		}
 	}
 
-	return strings.Split(paymentMethodsString, ", ")
+	if os.Getenv("SEPA_DEBIT_ENABLED") == "true" && !contains(paymentMethods, "sepa_debit") {
+		paymentMethods = append(paymentMethods, "sepa_debit")
+	}
+
+	return paymentMethods
 }
 
 // ShippingOption Shipping option
@@ -104,3 +122,509 @@ func Default() Configuration {
 
 	return c
 }
+
+// Issuer invoice issuer data
+type Issuer struct {
+	NIF    string `json:"nif"`
+	Name   string `json:"name"`
+	Series string `json:"series"`
+}
+
+// GetIssuer get the issuer used to number and sign invoices
+func GetIssuer() Issuer {
+	series := os.Getenv("INVOICE_SERIES")
+
+	if series == "" {
+		series = "Q"
+	}
+
+	return Issuer{
+		NIF:    os.Getenv("INVOICE_ISSUER_NIF"),
+		Name:   os.Getenv("INVOICE_ISSUER_NAME"),
+		Series: series,
+	}
+}
+
+// GetVATRate get the VAT rate in percent applied to our prices, they already include it
+func GetVATRate() float64 {
+	rate, err := strconv.ParseFloat(os.Getenv("VAT_RATE"), 64)
+
+	if err != nil {
+		return 21
+	}
+
+	return rate
+}
+
+// GetInvoiceRetentionYears get the years invoices are kept after they are issued, from INVOICE_RETENTION_YEARS, 6 by
+// default as the Código de Comercio requires for accounting records
+func GetInvoiceRetentionYears() int {
+	years, err := strconv.Atoi(os.Getenv("INVOICE_RETENTION_YEARS"))
+
+	if err != nil || years <= 0 {
+		return 6
+	}
+
+	return years
+}
+
+// GetTradePaymentTerms get the days trade customers have to pay their invoices, from TRADE_PAYMENT_TERMS_DAYS, 30 by
+// default
+func GetTradePaymentTerms() int64 {
+	days, err := strconv.ParseInt(os.Getenv("TRADE_PAYMENT_TERMS_DAYS"), 10, 64)
+
+	if err != nil || days <= 0 {
+		return 30
+	}
+
+	return days
+}
+
+// Mailer transactional email delivery settings
+type Mailer struct {
+	Sender       string
+	From         string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	Directory    string
+	TeamEmail    string
+}
+
+// GetMailer get how transactional emails are delivered, written to files unless MAIL_SENDER is smtp
+func GetMailer() Mailer {
+	m := Mailer{
+		Sender:       os.Getenv("MAIL_SENDER"),
+		From:         os.Getenv("MAIL_FROM"),
+		SMTPHost:     os.Getenv("SMTP_HOST"),
+		SMTPPort:     os.Getenv("SMTP_PORT"),
+		SMTPUsername: os.Getenv("SMTP_USERNAME"),
+		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+		Directory:    os.Getenv("MAIL_DIRECTORY"),
+		TeamEmail:    os.Getenv("TEAM_EMAIL"),
+	}
+
+	if m.Sender == "" {
+		m.Sender = "file"
+	}
+
+	if m.From == "" {
+		m.From = "Quantvm <pedidos@quantvm.es>"
+	}
+
+	if m.SMTPHost == "" {
+		m.SMTPHost = "localhost"
+	}
+
+	if m.SMTPPort == "" {
+		m.SMTPPort = "1025"
+	}
+
+	if m.Directory == "" {
+		m.Directory = "mail"
+	}
+
+	return m
+}
+
+// PromotionCode discount given by a promotion code, in percent or as a fixed amount
+type PromotionCode struct {
+	Code       string  `json:"code"`
+	PercentOff float64 `json:"percentOff,omitempty"`
+	AmountOff  int64   `json:"amountOff,omitempty"`
+}
+
+// GetPromotionCodes get promotion codes from PROMOTION_CODES, as in "VERANO10=10%, BIENVENIDA=500"
+func GetPromotionCodes() []PromotionCode {
+	codes := []PromotionCode{}
+
+	for _, entry := range strings.Split(os.Getenv("PROMOTION_CODES"), ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+
+		if len(parts) != 2 {
+			continue
+		}
+
+		code := PromotionCode{Code: strings.ToUpper(strings.TrimSpace(parts[0]))}
+		value := strings.TrimSpace(parts[1])
+
+		if strings.HasSuffix(value, "%") {
+			percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+
+			if err != nil || percent <= 0 || percent > 100 {
+				continue
+			}
+
+			code.PercentOff = percent
+		} else {
+			amount, err := strconv.ParseInt(value, 10, 64)
+
+			if err != nil || amount <= 0 {
+				continue
+			}
+
+			code.AmountOff = amount
+		}
+
+		codes = append(codes, code)
+	}
+
+	return codes
+}
+
+// GetPromotionCode Get promotion code
+func GetPromotionCode(code string) (PromotionCode, bool) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+
+	for _, promotionCode := range GetPromotionCodes() {
+		if promotionCode.Code == code {
+			return promotionCode, true
+		}
+	}
+
+	return PromotionCode{}, false
+}
+
+// euCountries member states of the European Union VAT area
+var euCountries = map[string]bool{
+	"AT": true, "BE": true, "BG": true, "CY": true, "CZ": true, "DE": true, "DK": true,
+	"EE": true, "ES": true, "FI": true, "FR": true, "GR": true, "HR": true, "HU": true,
+	"IE": true, "IT": true, "LT": true, "LU": true, "LV": true, "MT": true, "NL": true,
+	"PL": true, "PT": true, "RO": true, "SE": true, "SI": true, "SK": true,
+}
+
+// IsEUCountry Is an ISO country code part of the European Union VAT area
+func IsEUCountry(country string) bool {
+	return euCountries[strings.ToUpper(country)]
+}
+
+// GetTaxRate get the VAT rate in percent for a destination, zero where Spanish VAT is not charged:
+// outside the EU, and in Canarias, Ceuta and Melilla. An unknown destination is charged as Spain.
+func GetTaxRate(country string, postalCode string) float64 {
+	country = strings.ToUpper(strings.TrimSpace(country))
+
+	if country == "" {
+		return GetVATRate()
+	}
+
+	if country == "ES" {
+		switch {
+		case strings.HasPrefix(postalCode, "35"), strings.HasPrefix(postalCode, "38"):
+			return 0
+		case strings.HasPrefix(postalCode, "51"), strings.HasPrefix(postalCode, "52"):
+			return 0
+		}
+	}
+
+	if !IsEUCountry(country) {
+		return 0
+	}
+
+	return GetVATRate()
+}
+
+// PaymentMethodCompatibility currencies and customer countries a payment method accepts, any when empty
+type PaymentMethodCompatibility struct {
+	Currencies []string `json:"currencies"`
+	Countries  []string `json:"countries"`
+}
+
+// sepaCountries countries in the Single Euro Payments Area
+var sepaCountries = []string{
+	"AT", "BE", "BG", "CH", "CY", "CZ", "DE", "DK", "EE", "ES", "FI", "FR", "GB", "GR", "HR", "HU",
+	"IE", "IS", "IT", "LI", "LT", "LU", "LV", "MC", "MT", "NL", "NO", "PL", "PT", "RO", "SE", "SI",
+	"SK", "SM",
+}
+
+// PaymentMethodsCompatibility payment methods compatibility matrix, a method missing here is never offered
+var PaymentMethodsCompatibility = map[string]PaymentMethodCompatibility{
+	"card":          {},
+	"sepa_debit":    {Currencies: []string{"eur"}, Countries: sepaCountries},
+	"bancontact":    {Currencies: []string{"eur"}, Countries: []string{"BE"}},
+	"ideal":         {Currencies: []string{"eur"}, Countries: []string{"NL"}},
+	"giropay":       {Currencies: []string{"eur"}, Countries: []string{"DE"}},
+	"eps":           {Currencies: []string{"eur"}, Countries: []string{"AT"}},
+	"p24":           {Currencies: []string{"eur", "pln"}, Countries: []string{"PL"}},
+	"sofort":        {Currencies: []string{"eur"}, Countries: []string{"AT", "BE", "DE", "ES", "IT", "NL"}},
+	"au_becs_debit": {Currencies: []string{"aud"}, Countries: []string{"AU"}},
+	"alipay":        {Currencies: []string{"aud", "cad", "cny", "eur", "gbp", "hkd", "jpy", "nzd", "sgd", "usd"}},
+}
+
+// PaymentMethodError payment methods not allowed for a currency and country
+type PaymentMethodError struct {
+	Currency       string   `json:"currency"`
+	Country        string   `json:"country,omitempty"`
+	PaymentMethods []string `json:"paymentMethods"`
+}
+
+func (e *PaymentMethodError) Error() string {
+	if len(e.PaymentMethods) == 0 {
+		return fmt.Sprintf("config: no payment method available for currency %q and country %q", e.Currency, e.Country)
+	}
+
+	return fmt.Sprintf(
+		"config: payment methods %s are not available for currency %q and country %q",
+		strings.Join(e.PaymentMethods, ", "),
+		e.Currency,
+		e.Country,
+	)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsPaymentMethodCompatible Is a payment method enabled and compatible with a currency and a country, an empty country is not checked
+func IsPaymentMethodCompatible(paymentMethod string, currency string, country string) bool {
+	if !contains(GetPaymentMethods(), paymentMethod) {
+		return false
+	}
+
+	compatibility, ok := PaymentMethodsCompatibility[paymentMethod]
+
+	if !ok {
+		return false
+	}
+
+	if len(compatibility.Currencies) > 0 && !contains(compatibility.Currencies, currency) {
+		return false
+	}
+
+	if country != "" && len(compatibility.Countries) > 0 && !contains(compatibility.Countries, country) {
+		return false
+	}
+
+	return true
+}
+
+// GetCompatiblePaymentMethods get enabled payment methods compatible with a currency and a country
+func GetCompatiblePaymentMethods(currency string, country string) []string {
+	paymentMethods := []string{}
+
+	for _, paymentMethod := range GetPaymentMethods() {
+		if IsPaymentMethodCompatible(paymentMethod, currency, country) {
+			paymentMethods = append(paymentMethods, paymentMethod)
+		}
+	}
+
+	return paymentMethods
+}
+
+// ValidatePaymentMethods Validate that every payment method is enabled and compatible with a currency and a country
+func ValidatePaymentMethods(paymentMethods []string, currency string, country string) error {
+	invalid := []string{}
+
+	for _, paymentMethod := range paymentMethods {
+		if !IsPaymentMethodCompatible(paymentMethod, currency, country) {
+			invalid = append(invalid, paymentMethod)
+		}
+	}
+
+	if len(paymentMethods) == 0 || len(invalid) > 0 {
+		return &PaymentMethodError{
+			Currency:       currency,
+			Country:        country,
+			PaymentMethods: invalid,
+		}
+	}
+
+	return nil
+}
+
+// Sweeper abandoned payment intents sweeper settings
+type Sweeper struct {
+	Interval time.Duration
+	MaxAge   time.Duration
+}
+
+// GetSweeper get how often abandoned payment intents are swept and how old they must be,
+// a SWEEPER_INTERVAL of 0 disables the sweeper
+func GetSweeper() Sweeper {
+	s := Sweeper{
+		Interval: 15 * time.Minute,
+		MaxAge:   24 * time.Hour,
+	}
+
+	if interval, err := time.ParseDuration(os.Getenv("SWEEPER_INTERVAL")); err == nil {
+		s.Interval = interval
+	}
+
+	if maxAge, err := time.ParseDuration(os.Getenv("ABANDONED_INTENT_MAX_AGE")); err == nil {
+		s.MaxAge = maxAge
+	}
+
+	return s
+}
+
+// DefaultMinimumAge legal age to buy alcohol where a country has no specific one
+const DefaultMinimumAge = 18
+
+// MinimumAges legal age to buy alcohol by shipping country
+var MinimumAges = map[string]int{
+	"US": 21,
+	"IS": 20,
+	"JP": 20,
+	"KR": 19,
+	"CA": 19,
+}
+
+// GetMinimumAge get the legal age to buy alcohol in a country
+func GetMinimumAge(country string) int {
+	if age, ok := MinimumAges[strings.ToUpper(strings.TrimSpace(country))]; ok {
+		return age
+	}
+
+	return DefaultMinimumAge
+}
+
+// Risk limits checkout attempts are scored against
+type Risk struct {
+	Window      time.Duration
+	MaxAttempts int
+	MaxBottles  int64
+}
+
+// GetRisk get the window checkout attempts are counted in, the attempts allowed in it for a same IP, customer or
+// email, and the bottles above which an order is unusually large
+func GetRisk() Risk {
+	r := Risk{
+		Window:      time.Hour,
+		MaxAttempts: 5,
+		MaxBottles:  36,
+	}
+
+	if window, err := time.ParseDuration(os.Getenv("RISK_WINDOW")); err == nil && window > 0 {
+		r.Window = window
+	}
+
+	if attempts, err := strconv.Atoi(os.Getenv("RISK_MAX_ATTEMPTS")); err == nil && attempts > 0 {
+		r.MaxAttempts = attempts
+	}
+
+	if bottles, err := strconv.ParseInt(os.Getenv("RISK_MAX_BOTTLES"), 10, 64); err == nil && bottles > 0 {
+		r.MaxBottles = bottles
+	}
+
+	return r
+}
+
+// GetTrustedProxies get the IP addresses of the reverse proxies in front of the server, whose X-Forwarded-For header
+// tells the client IP address, none by default
+func GetTrustedProxies() []string {
+	proxies := []string{}
+
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+
+	return proxies
+}
+
+// Auth customer login settings
+type Auth struct {
+	Secret         string
+	SessionTTL     time.Duration
+	LinkTTL        time.Duration
+	LoginURL       string
+	LinkRateLimit  int
+	LinkRateWindow time.Duration
+}
+
+// GetAuth get the secret sessions are signed with, from AUTH_SECRET, how long sessions and login links last, from
+// SESSION_TTL, 720h by default, and MAGIC_LINK_TTL, 15m by default, the page login links open, from LOGIN_URL, and
+// how many login links an email or IP address can request, from LOGIN_LINK_RATE_LIMIT, 5 by default, every
+// LOGIN_LINK_RATE_WINDOW, 1h by default
+func GetAuth() Auth {
+	a := Auth{
+		Secret:         os.Getenv("AUTH_SECRET"),
+		SessionTTL:     30 * 24 * time.Hour,
+		LinkTTL:        15 * time.Minute,
+		LoginURL:       os.Getenv("LOGIN_URL"),
+		LinkRateLimit:  5,
+		LinkRateWindow: time.Hour,
+	}
+
+	if limit, err := strconv.Atoi(os.Getenv("LOGIN_LINK_RATE_LIMIT")); err == nil && limit > 0 {
+		a.LinkRateLimit = limit
+	}
+
+	if window, err := time.ParseDuration(os.Getenv("LOGIN_LINK_RATE_WINDOW")); err == nil && window > 0 {
+		a.LinkRateWindow = window
+	}
+
+	if ttl, err := time.ParseDuration(os.Getenv("SESSION_TTL")); err == nil && ttl > 0 {
+		a.SessionTTL = ttl
+	}
+
+	if ttl, err := time.ParseDuration(os.Getenv("MAGIC_LINK_TTL")); err == nil && ttl > 0 {
+		a.LinkTTL = ttl
+	}
+
+	if a.LoginURL == "" {
+		a.LoginURL = "http://localhost:4567/login"
+	}
+
+	return a
+}
+
+// Consent purposes customers give their consent for
+const (
+	ConsentTerms     = "terms"
+	ConsentPrivacy   = "privacy"
+	ConsentMarketing = "marketing"
+)
+
+// GetPolicyVersions get the current version of the policy of each consent purpose, from POLICY_VERSION_TERMS,
+// POLICY_VERSION_PRIVACY and POLICY_VERSION_MARKETING, 1 when not set
+func GetPolicyVersions() map[string]string {
+	versions := map[string]string{}
+
+	for _, purpose := range []string{ConsentTerms, ConsentPrivacy, ConsentMarketing} {
+		version := strings.TrimSpace(os.Getenv("POLICY_VERSION_" + strings.ToUpper(purpose)))
+
+		if version == "" {
+			version = "1"
+		}
+
+		versions[purpose] = version
+	}
+
+	return versions
+}
+
+// VATValidation EU VAT number validation settings
+type VATValidation struct {
+	Online   bool
+	Timeout  time.Duration
+	CacheTTL time.Duration
+}
+
+// GetVATValidation get whether VAT numbers are also validated online against VIES, from VAT_VIES_ENABLED, how long
+// VIES is waited for, from VAT_VIES_TIMEOUT, 5s by default, and how long its answers are kept, from
+// VAT_VIES_CACHE_TTL, 24h by default
+func GetVATValidation() VATValidation {
+	v := VATValidation{
+		Online:   os.Getenv("VAT_VIES_ENABLED") == "true",
+		Timeout:  5 * time.Second,
+		CacheTTL: 24 * time.Hour,
+	}
+
+	if timeout, err := time.ParseDuration(os.Getenv("VAT_VIES_TIMEOUT")); err == nil && timeout > 0 {
+		v.Timeout = timeout
+	}
+
+	if ttl, err := time.ParseDuration(os.Getenv("VAT_VIES_CACHE_TTL")); err == nil && ttl > 0 {
+		v.CacheTTL = ttl
+	}
+
+	return v
+}
@@ -0,0 +1,226 @@
+package consents
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/javierlopezdeancos/stipendivm/config"
+	"github.com/javierlopezdeancos/stipendivm/storage"
+)
+
+// Consent purposes
+const (
+	Terms     = config.ConsentTerms
+	Privacy   = config.ConsentPrivacy
+	Marketing = config.ConsentMarketing
+)
+
+// Consent actions
+const (
+	Granted   = "granted"
+	Withdrawn = "withdrawn"
+)
+
+// Record a consent given or withdrawn by a customer, records are never removed and only changed to erase the
+// IP address and user agent of an erased customer
+type Record struct {
+	ID            string    `json:"id"`
+	CustomerID    string    `json:"customerId"`
+	Purpose       string    `json:"purpose"`
+	Action        string    `json:"action"`
+	PolicyVersion string    `json:"policyVersion"`
+	IPAddress     string    `json:"ipAddress"`
+	UserAgent     string    `json:"userAgent,omitempty"`
+	Source        string    `json:"source"`
+	RecordedAt    time.Time `json:"recordedAt"`
+}
+
+// Consent current consent of a customer for a purpose
+type Consent struct {
+	Purpose        string    `json:"purpose"`
+	Granted        bool      `json:"granted"`
+	PolicyVersion  string    `json:"policyVersion"`
+	CurrentVersion string    `json:"currentVersion"`
+	UpToDate       bool      `json:"upToDate"`
+	RecordedAt     time.Time `json:"recordedAt"`
+}
+
+// Ledger consent records persisted on disk
+type Ledger struct {
+	mu       sync.Mutex
+	path     string
+	versions map[string]string
+	records  []Record
+	now      func() time.Time
+}
+
+// Default ledger used by the server
+var Default *Ledger
+
+// NewLedger Load the consent records stored in path, an empty ledger if it does not exist yet,
+// versions are the current policy version of each purpose
+func NewLedger(path string, versions map[string]string) (*Ledger, error) {
+	l := &Ledger{
+		path:     path,
+		versions: versions,
+		now:      time.Now,
+	}
+
+	if err := storage.ReadJSON(path, &l.records); err != nil {
+		return nil, fmt.Errorf("consents: error loading consents: %v", err)
+	}
+
+	return l, nil
+}
+
+// Record Append a consent given or withdrawn, always for the current policy, which a record without policy version is
+// for. A customer can not consent to a policy version it is not shown.
+func (l *Ledger) Record(r Record) (*Record, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	current, ok := l.versions[r.Purpose]
+
+	if !ok {
+		return nil, fmt.Errorf("consents: unknown purpose %q", r.Purpose)
+	}
+
+	if r.Action != Granted && r.Action != Withdrawn {
+		return nil, fmt.Errorf("consents: unknown action %q", r.Action)
+	}
+
+	if r.CustomerID == "" {
+		return nil, fmt.Errorf("consents: a consent needs a customer")
+	}
+
+	if r.PolicyVersion == "" {
+		r.PolicyVersion = current
+	}
+
+	if r.PolicyVersion != current {
+		return nil, fmt.Errorf("consents: policy version %q of %s is not the current one, %q", r.PolicyVersion, r.Purpose, current)
+	}
+
+	r.ID = newID()
+	r.RecordedAt = l.now().UTC()
+
+	l.records = append(l.records, r)
+
+	if err := l.save(); err != nil {
+		l.records = l.records[:len(l.records)-1]
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// History Every consent record of a customer, oldest first
+func (l *Ledger) History(customerID string) []Record {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	history := []Record{}
+
+	for _, r := range l.records {
+		if r.CustomerID == customerID {
+			history = append(history, r)
+		}
+	}
+
+	return history
+}
+
+// Current Latest consent of a customer for every purpose it ever recorded, sorted by purpose
+func (l *Ledger) Current(customerID string) []Consent {
+	latest := map[string]Record{}
+
+	for _, r := range l.History(customerID) {
+		latest[r.Purpose] = r
+	}
+
+	current := []Consent{}
+
+	for purpose, r := range latest {
+		c := Consent{
+			Purpose:        purpose,
+			Granted:        r.Action == Granted,
+			PolicyVersion:  r.PolicyVersion,
+			CurrentVersion: l.versions[purpose],
+			RecordedAt:     r.RecordedAt,
+		}
+
+		c.UpToDate = c.Granted && c.PolicyVersion == c.CurrentVersion
+		current = append(current, c)
+	}
+
+	sort.Slice(current, func(i, j int) bool {
+		return current[i].Purpose < current[j].Purpose
+	})
+
+	return current
+}
+
+// AnonymizeCustomer Remove the IP address and user agent of the records of a customer, the number of records changed
+func (l *Ledger) AnonymizeCustomer(customerID string) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	previous := map[int]Record{}
+
+	for i := range l.records {
+		if l.records[i].CustomerID != customerID {
+			continue
+		}
+
+		previous[i] = l.records[i]
+		l.records[i].IPAddress = ""
+		l.records[i].UserAgent = ""
+	}
+
+	if len(previous) == 0 {
+		return 0, nil
+	}
+
+	if err := l.save(); err != nil {
+		for i, r := range previous {
+			l.records[i] = r
+		}
+
+		return 0, err
+	}
+
+	return len(previous), nil
+}
+
+// Versions Current policy version of each purpose
+func (l *Ledger) Versions() map[string]string {
+	versions := map[string]string{}
+
+	for purpose, version := range l.versions {
+		versions[purpose] = version
+	}
+
+	return versions
+}
+
+func newID() string {
+	b := make([]byte, 8)
+
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("consents: error generating consent ID: %v", err))
+	}
+
+	return "cons_" + hex.EncodeToString(b)
+}
+
+func (l *Ledger) save() error {
+	if err := storage.WriteJSON(l.path, l.records); err != nil {
+		return fmt.Errorf("consents: error saving consents: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,225 @@
+package customers
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Address validation error codes
+const (
+	AddressInvalidCountry    = "invalid_country"
+	AddressInvalidPostalCode = "invalid_postal_code"
+	AddressInvalidCity       = "invalid_city"
+)
+
+// isoCountryCodes every ISO-3166-1 alpha-2 country code
+var isoCountryCodes = map[string]bool{
+	"AD": true, "AE": true, "AF": true, "AG": true, "AI": true, "AL": true, "AM": true, "AO": true, "AQ": true,
+	"AR": true, "AS": true, "AT": true, "AU": true, "AW": true, "AX": true, "AZ": true, "BA": true, "BB": true,
+	"BD": true, "BE": true, "BF": true, "BG": true, "BH": true, "BI": true, "BJ": true, "BL": true, "BM": true,
+	"BN": true, "BO": true, "BQ": true, "BR": true, "BS": true, "BT": true, "BV": true, "BW": true, "BY": true,
+	"BZ": true, "CA": true, "CC": true, "CD": true, "CF": true, "CG": true, "CH": true, "CI": true, "CK": true,
+	"CL": true, "CM": true, "CN": true, "CO": true, "CR": true, "CU": true, "CV": true, "CW": true, "CX": true,
+	"CY": true, "CZ": true, "DE": true, "DJ": true, "DK": true, "DM": true, "DO": true, "DZ": true, "EC": true,
+	"EE": true, "EG": true, "EH": true, "ER": true, "ES": true, "ET": true, "FI": true, "FJ": true, "FK": true,
+	"FM": true, "FO": true, "FR": true, "GA": true, "GB": true, "GD": true, "GE": true, "GF": true, "GG": true,
+	"GH": true, "GI": true, "GL": true, "GM": true, "GN": true, "GP": true, "GQ": true, "GR": true, "GS": true,
+	"GT": true, "GU": true, "GW": true, "GY": true, "HK": true, "HM": true, "HN": true, "HR": true, "HT": true,
+	"HU": true, "ID": true, "IE": true, "IL": true, "IM": true, "IN": true, "IO": true, "IQ": true, "IR": true,
+	"IS": true, "IT": true, "JE": true, "JM": true, "JO": true, "JP": true, "KE": true, "KG": true, "KH": true,
+	"KI": true, "KM": true, "KN": true, "KP": true, "KR": true, "KW": true, "KY": true, "KZ": true, "LA": true,
+	"LB": true, "LC": true, "LI": true, "LK": true, "LR": true, "LS": true, "LT": true, "LU": true, "LV": true,
+	"LY": true, "MA": true, "MC": true, "MD": true, "ME": true, "MF": true, "MG": true, "MH": true, "MK": true,
+	"ML": true, "MM": true, "MN": true, "MO": true, "MP": true, "MQ": true, "MR": true, "MS": true, "MT": true,
+	"MU": true, "MV": true, "MW": true, "MX": true, "MY": true, "MZ": true, "NA": true, "NC": true, "NE": true,
+	"NF": true, "NG": true, "NI": true, "NL": true, "NO": true, "NP": true, "NR": true, "NU": true, "NZ": true,
+	"OM": true, "PA": true, "PE": true, "PF": true, "PG": true, "PH": true, "PK": true, "PL": true, "PM": true,
+	"PN": true, "PR": true, "PS": true, "PT": true, "PW": true, "PY": true, "QA": true, "RE": true, "RO": true,
+	"RS": true, "RU": true, "RW": true, "SA": true, "SB": true, "SC": true, "SD": true, "SE": true, "SG": true,
+	"SH": true, "SI": true, "SJ": true, "SK": true, "SL": true, "SM": true, "SN": true, "SO": true, "SR": true,
+	"SS": true, "ST": true, "SV": true, "SX": true, "SY": true, "SZ": true, "TC": true, "TD": true, "TF": true,
+	"TG": true, "TH": true, "TJ": true, "TK": true, "TL": true, "TM": true, "TN": true, "TO": true, "TR": true,
+	"TT": true, "TV": true, "TW": true, "TZ": true, "UA": true, "UG": true, "UM": true, "US": true, "UY": true,
+	"UZ": true, "VA": true, "VC": true, "VE": true, "VG": true, "VI": true, "VN": true, "VU": true, "WF": true,
+	"WS": true, "YE": true, "YT": true, "ZA": true, "ZM": true, "ZW": true,
+}
+
+// countryCodes ISO-3166 codes by country name in Spanish and English, lowercased and without accents
+var countryCodes = map[string]string{
+	"espana": "ES", "spain": "ES",
+	"portugal": "PT",
+	"francia":  "FR", "france": "FR",
+	"alemania": "DE", "germany": "DE", "deutschland": "DE",
+	"italia": "IT", "italy": "IT",
+	"paises bajos": "NL", "holanda": "NL", "netherlands": "NL", "the netherlands": "NL",
+	"belgica": "BE", "belgium": "BE",
+	"austria": "AT",
+	"irlanda": "IE", "ireland": "IE",
+	"luxemburgo": "LU", "luxembourg": "LU",
+	"dinamarca": "DK", "denmark": "DK",
+	"suecia": "SE", "sweden": "SE",
+	"finlandia": "FI", "finland": "FI",
+	"polonia": "PL", "poland": "PL",
+	"republica checa": "CZ", "chequia": "CZ", "czech republic": "CZ", "czechia": "CZ",
+	"eslovaquia": "SK", "slovakia": "SK",
+	"eslovenia": "SI", "slovenia": "SI",
+	"croacia": "HR", "croatia": "HR",
+	"hungria": "HU", "hungary": "HU",
+	"rumania": "RO", "romania": "RO",
+	"bulgaria": "BG",
+	"grecia":   "GR", "greece": "GR",
+	"chipre": "CY", "cyprus": "CY",
+	"malta":   "MT",
+	"estonia": "EE",
+	"letonia": "LV", "latvia": "LV",
+	"lituania": "LT", "lithuania": "LT",
+	"reino unido": "GB", "united kingdom": "GB", "uk": "GB", "gran bretana": "GB", "great britain": "GB",
+	"suiza": "CH", "switzerland": "CH",
+	"noruega": "NO", "norway": "NO",
+	"islandia": "IS", "iceland": "IS",
+	"andorra":        "AD",
+	"monaco":         "MC",
+	"estados unidos": "US", "united states": "US", "usa": "US", "eeuu": "US",
+	"canada": "CA",
+	"mexico": "MX",
+	"japon":  "JP", "japan": "JP",
+	"china":         "CN",
+	"corea del sur": "KR", "south korea": "KR",
+	"australia": "AU",
+	"brasil":    "BR", "brazil": "BR",
+	"argentina": "AR",
+	"chile":     "CL",
+}
+
+// postalCodeFormats postal code formats by ISO country code, countries not listed accept any postal code
+var postalCodeFormats = map[string]*regexp.Regexp{
+	"AT": regexp.MustCompile(`^\d{4}$`),
+	"BE": regexp.MustCompile(`^\d{4}$`),
+	"BG": regexp.MustCompile(`^\d{4}$`),
+	"CA": regexp.MustCompile(`^[A-Z]\d[A-Z] ?\d[A-Z]\d$`),
+	"CH": regexp.MustCompile(`^\d{4}$`),
+	"CY": regexp.MustCompile(`^\d{4}$`),
+	"CZ": regexp.MustCompile(`^\d{3} ?\d{2}$`),
+	"DE": regexp.MustCompile(`^\d{5}$`),
+	"DK": regexp.MustCompile(`^\d{4}$`),
+	"EE": regexp.MustCompile(`^\d{5}$`),
+	"ES": regexp.MustCompile(`^(0[1-9]|[1-4]\d|5[0-2])\d{3}$`),
+	"FI": regexp.MustCompile(`^\d{5}$`),
+	"FR": regexp.MustCompile(`^\d{5}$`),
+	"GB": regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]? ?\d[A-Z]{2}$`),
+	"GR": regexp.MustCompile(`^\d{3} ?\d{2}$`),
+	"HR": regexp.MustCompile(`^\d{5}$`),
+	"HU": regexp.MustCompile(`^\d{4}$`),
+	"IT": regexp.MustCompile(`^\d{5}$`),
+	"JP": regexp.MustCompile(`^\d{3}-?\d{4}$`),
+	"LT": regexp.MustCompile(`^(LT-)?\d{5}$`),
+	"LU": regexp.MustCompile(`^(L-)?\d{4}$`),
+	"LV": regexp.MustCompile(`^(LV-)?\d{4}$`),
+	"MT": regexp.MustCompile(`^[A-Z]{3} ?\d{4}$`),
+	"MX": regexp.MustCompile(`^\d{5}$`),
+	"NL": regexp.MustCompile(`^\d{4} ?[A-Z]{2}$`),
+	"NO": regexp.MustCompile(`^\d{4}$`),
+	"PL": regexp.MustCompile(`^\d{2}-\d{3}$`),
+	"PT": regexp.MustCompile(`^\d{4}-\d{3}$`),
+	"RO": regexp.MustCompile(`^\d{6}$`),
+	"SE": regexp.MustCompile(`^\d{3} ?\d{2}$`),
+	"SI": regexp.MustCompile(`^\d{4}$`),
+	"SK": regexp.MustCompile(`^\d{3} ?\d{2}$`),
+	"US": regexp.MustCompile(`^\d{5}(-\d{4})?$`),
+}
+
+// spanishProvinces Spanish provinces by the first two digits of their postal codes
+var spanishProvinces = map[string]string{
+	"01": "Álava", "02": "Albacete", "03": "Alicante", "04": "Almería", "05": "Ávila",
+	"06": "Badajoz", "07": "Illes Balears", "08": "Barcelona", "09": "Burgos", "10": "Cáceres",
+	"11": "Cádiz", "12": "Castellón", "13": "Ciudad Real", "14": "Córdoba", "15": "A Coruña",
+	"16": "Cuenca", "17": "Girona", "18": "Granada", "19": "Guadalajara", "20": "Gipuzkoa",
+	"21": "Huelva", "22": "Huesca", "23": "Jaén", "24": "León", "25": "Lleida",
+	"26": "La Rioja", "27": "Lugo", "28": "Madrid", "29": "Málaga", "30": "Murcia",
+	"31": "Navarra", "32": "Ourense", "33": "Asturias", "34": "Palencia", "35": "Las Palmas",
+	"36": "Pontevedra", "37": "Salamanca", "38": "Santa Cruz de Tenerife", "39": "Cantabria", "40": "Segovia",
+	"41": "Sevilla", "42": "Soria", "43": "Tarragona", "44": "Teruel", "45": "Toledo",
+	"46": "Valencia", "47": "Valladolid", "48": "Bizkaia", "49": "Zamora", "50": "Zaragoza",
+	"51": "Ceuta", "52": "Melilla",
+}
+
+var (
+	accents    = strings.NewReplacer("á", "a", "é", "e", "í", "i", "ó", "o", "ú", "u", "ü", "u", "ñ", "n", "ç", "c")
+	spaces     = regexp.MustCompile(`\s+`)
+	onlyDigits = regexp.MustCompile(`^[\d\s-]+$`)
+)
+
+// CountryCode ISO-3166 code of a country given by its code or its Spanish or English name, ok is false when unknown
+func CountryCode(country string) (string, bool) {
+	country = spaces.ReplaceAllString(strings.TrimSpace(country), " ")
+
+	if len(country) == 2 {
+		if code := strings.ToUpper(country); isoCountryCodes[code] {
+			return code, true
+		}
+	}
+
+	code, ok := countryCodes[accents.Replace(strings.ToLower(country))]
+
+	return code, ok
+}
+
+// SpanishProvince Province of a Spanish postal code, empty when it is not one
+func SpanishProvince(postalCode string) string {
+	if len(postalCode) != 5 {
+		return ""
+	}
+
+	return spanishProvinces[postalCode[:2]]
+}
+
+// NormalizeAddress Map the country to its ISO code, check the postal code format of the country, derive the
+// province of Spanish postal codes and trim every field. The field errors are prefixed with field.
+func NormalizeAddress(a Address, field string) (Address, []FieldError) {
+	fields := []FieldError{}
+
+	normalized := Address{
+		City:       spaces.ReplaceAllString(strings.TrimSpace(a.City), " "),
+		PostalCode: strings.ToUpper(spaces.ReplaceAllString(strings.TrimSpace(a.PostalCode), " ")),
+		Province:   spaces.ReplaceAllString(strings.TrimSpace(a.Province), " "),
+		Street:     spaces.ReplaceAllString(strings.TrimSpace(a.Street), " "),
+		Line2:      spaces.ReplaceAllString(strings.TrimSpace(a.Line2), " "),
+	}
+
+	country, ok := CountryCode(a.Country)
+
+	if !ok {
+		fields = append(fields, FieldError{
+			Field:   field + ".country",
+			Code:    AddressInvalidCountry,
+			Message: "The country is not a known country name or ISO code",
+		})
+	}
+
+	normalized.Country = country
+
+	if format, ok := postalCodeFormats[country]; ok && !format.MatchString(normalized.PostalCode) {
+		fields = append(fields, FieldError{
+			Field:   field + ".postalCode",
+			Code:    AddressInvalidPostalCode,
+			Message: "The postal code does not have the format of the country",
+		})
+	}
+
+	// a postal code typed in the city field
+	if normalized.City != "" && onlyDigits.MatchString(normalized.City) {
+		fields = append(fields, FieldError{
+			Field:   field + ".city",
+			Code:    AddressInvalidCity,
+			Message: "The city is a number, maybe the postal code",
+		})
+	}
+
+	if country == "ES" {
+		if province := SpanishProvince(normalized.PostalCode); province != "" {
+			normalized.Province = province
+		}
+	}
+
+	return normalized, fields
+}
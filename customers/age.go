@@ -0,0 +1,115 @@
+package customers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"github.com/javierlopezdeancos/stipendivm/config"
+)
+
+// Customer metadata keys of the age declaration
+const (
+	MetadataDateOfBirth    = "dateOfBirth"
+	MetadataAgeDeclaration = "ageDeclaration"
+	MetadataAgeDeclaredAt  = "ageDeclaredAt"
+)
+
+// Age verification error codes
+const (
+	AgeNotDeclared = "age_not_declared"
+	AgeInvalid     = "age_invalid"
+	AgeUnderage    = "age_underage"
+)
+
+// dateOfBirthLayout date of birth format, as in 1980-01-31
+const dateOfBirthLayout = "2006-01-02"
+
+// AgeError the customer has not declared a valid legal age to buy alcohol in a country
+type AgeError struct {
+	Code       string `json:"code"`
+	Country    string `json:"country"`
+	MinimumAge int    `json:"minimumAge"`
+}
+
+func (e *AgeError) Error() string {
+	switch e.Code {
+	case AgeNotDeclared:
+		return "customers: the customer has not declared their date of birth and legal age"
+	case AgeInvalid:
+		return "customers: the customer date of birth is not valid"
+	default:
+		return fmt.Sprintf("customers: the customer is under the legal age of %d to buy alcohol in %s", e.MinimumAge, e.Country)
+	}
+}
+
+// Age Age in years on a date of someone born on dateOfBirth
+func Age(dateOfBirth time.Time, on time.Time) int {
+	age := on.Year() - dateOfBirth.Year()
+
+	if on.Month() < dateOfBirth.Month() || (on.Month() == dateOfBirth.Month() && on.Day() < dateOfBirth.Day()) {
+		age--
+	}
+
+	return age
+}
+
+// VerifyAge Verify that someone born on dateOfBirth, in YYYY-MM-DD format, has the legal age to buy alcohol in a country
+func VerifyAge(dateOfBirth string, country string) error {
+	// a country written as its name is checked as its ISO code
+	if code, ok := CountryCode(country); ok {
+		country = code
+	}
+
+	minimumAge := config.GetMinimumAge(country)
+
+	if dateOfBirth == "" {
+		return &AgeError{Code: AgeNotDeclared, Country: country, MinimumAge: minimumAge}
+	}
+
+	born, err := time.Parse(dateOfBirthLayout, dateOfBirth)
+
+	if err != nil || born.After(time.Now()) {
+		return &AgeError{Code: AgeInvalid, Country: country, MinimumAge: minimumAge}
+	}
+
+	if Age(born, time.Now()) < minimumAge {
+		return &AgeError{Code: AgeUnderage, Country: country, MinimumAge: minimumAge}
+	}
+
+	return nil
+}
+
+// VerifyCustomerAge Verify the age a Stripe customer declared against the legal age of the country the order ships to
+func VerifyCustomerAge(c *stripe.Customer, country string) error {
+	if c.Metadata[MetadataAgeDeclaration] != "true" {
+		return &AgeError{Code: AgeNotDeclared, Country: country, MinimumAge: config.GetMinimumAge(country)}
+	}
+
+	return VerifyAge(c.Metadata[MetadataDateOfBirth], country)
+}
+
+// VerifyCheckoutAge Verify the age of the customer checking out an order shipped to a country,
+// to the customer shipping country when it is empty
+func VerifyCheckoutAge(customerID string, country string) error {
+	if customerID == "" {
+		return &AgeError{Code: AgeNotDeclared, Country: country, MinimumAge: config.GetMinimumAge(country)}
+	}
+
+	c, err := Retrieve(customerID)
+
+	if err != nil {
+		return err
+	}
+
+	if country == "" && c.Shipping != nil {
+		country = c.Shipping.Address.Country
+	}
+
+	if country == "" {
+		country = c.Address.Country
+	}
+
+	return VerifyCustomerAge(c, country)
+}
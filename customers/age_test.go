@@ -0,0 +1,43 @@
+package customers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerifyAge(t *testing.T) {
+	twenty := time.Now().AddDate(-20, 0, -1).Format(dateOfBirthLayout)
+
+	tests := []struct {
+		name     string
+		country  string
+		wantCode string
+	}{
+		{"Spain as ISO code", "ES", ""},
+		{"Spain as Spanish name", "España", ""},
+		{"United States as ISO code", "US", AgeUnderage},
+		{"United States in lowercase", "us", AgeUnderage},
+		{"United States as English name", "United States", AgeUnderage},
+		{"United States as Spanish name", "Estados Unidos", AgeUnderage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyAge(twenty, tt.country)
+
+			if tt.wantCode == "" {
+				if err != nil {
+					t.Fatalf("VerifyAge(%s) error = %v, want nil", tt.country, err)
+				}
+
+				return
+			}
+
+			ageError, ok := err.(*AgeError)
+
+			if !ok || ageError.Code != tt.wantCode || ageError.Country != "US" || ageError.MinimumAge != 21 {
+				t.Errorf("VerifyAge(%s) error = %+v, want %s in US from 21", tt.country, err, tt.wantCode)
+			}
+		})
+	}
+}
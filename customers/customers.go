@@ -2,9 +2,14 @@ package customers
 
 import (
 	"fmt"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/stripe/stripe-go/v72"
 	"github.com/stripe/stripe-go/v72/customer"
+
+	"github.com/javierlopezdeancos/stipendivm/config"
 )
 
 // Address to a customer structure
@@ -14,41 +19,161 @@ type Address struct {
 	PostalCode string `json:"postalCode"`
 	Province   string `json:"province"`
 	Street     string `json:"street"`
+	Line2      string `json:"line2"`
+}
+
+// Shipping where and to whom a customer orders are shipped
+type Shipping struct {
+	Address Address `json:"address"`
+	Name    string  `json:"name"`
+	Phone   string  `json:"phone"`
 }
 
 // Customer type to a customer
 type Customer struct {
-	Address   Address `json:"address"`
-	Company   string  `json:"company"`
-	Email     string  `json:"email"`
-	FirstName string  `json:"firstName"`
-	LastName  string  `json:"lastName"`
-	Lgpd      bool    `json:"lgpd"`
-	NifCif    string  `json:"nifCif"`
-	Phone     string  `json:"phone"`
-}
-
-// Create a new customer in Stripe BBDD
-func Create(newCustomer Customer) (*stripe.Customer, error) {
+	ID             string    `json:"id,omitempty"`
+	Address        Address   `json:"address"`
+	AgeDeclaration bool      `json:"ageDeclaration"`
+	Company        string    `json:"company"`
+	DateOfBirth    string    `json:"dateOfBirth"`
+	Email          string    `json:"email"`
+	FirstName      string    `json:"firstName"`
+	LastName       string    `json:"lastName"`
+	Lgpd           bool      `json:"lgpd"`
+	NifCif         string    `json:"nifCif"`
+	Phone          string    `json:"phone"`
+	Shipping       *Shipping `json:"shipping,omitempty"`
+}
+
+// CustomerChange partial update of a customer, only the fields given are changed
+type CustomerChange struct {
+	Address   *Address  `json:"address"`
+	Company   *string   `json:"company"`
+	Email     *string   `json:"email"`
+	FirstName *string   `json:"firstName"`
+	LastName  *string   `json:"lastName"`
+	Lgpd      *bool     `json:"lgpd"`
+	NifCif    *string   `json:"nifCif"`
+	Phone     *string   `json:"phone"`
+	Shipping  *Shipping `json:"shipping"`
+}
+
+// Customer metadata keys
+const (
+	MetadataNifCif    = "nifCif"
+	MetadataCompany   = "company"
+	MetadataLgpd      = "lgpd"
+	MetadataFirstName = "firstName"
+	MetadataLastName  = "lastName"
+	MetadataTaxIDType = "taxIdType"
+	// MetadataMergedInto customer a duplicate was merged into, kept when it has saved payment methods
+	MetadataMergedInto = "mergedInto"
+)
+
+// NotFoundError a customer that does not exist or was deleted
+type NotFoundError struct {
+	ID string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("customers: customer %s not found", e.ID)
+}
+
+// ExistsError a customer already has the email of a new customer, its ID is never sent to the client
+type ExistsError struct {
+	CustomerID string
+	Email      string
+}
+
+func (e *ExistsError) Error() string {
+	return fmt.Sprintf("customers: customer %s already has the email", e.CustomerID)
+}
+
+// Create a new customer in Stripe BBDD. The customer that already has its email is updated, and created is false,
+// only when it is the customer logged in as sessionCustomerID, for anyone else it is an ExistsError.
+func Create(newCustomer Customer, sessionCustomerID string) (*stripe.Customer, bool, error) {
 	fmt.Println("\n🔵 [INFO] Creating new customer...")
 	fmt.Println()
 
-	name := newCustomer.FirstName + " " + newCustomer.LastName
+	taxID, fields := validateTaxID(newCustomer.NifCif, newCustomer.Company)
+
+	address, addressFields := NormalizeAddress(newCustomer.Address, "address")
+	fields = append(fields, addressFields...)
+	newCustomer.Address = address
+
+	if newCustomer.Shipping != nil {
+		shipping := *newCustomer.Shipping
+		address, addressFields := NormalizeAddress(shipping.Address, "shipping.address")
+		fields = append(fields, addressFields...)
+		shipping.Address = address
+		newCustomer.Shipping = &shipping
+	}
+
+	if len(fields) > 0 {
+		return nil, false, &ValidationError{Fields: fields}
+	}
+
+	// the legal age is the one of the ISO country of the normalized address, however the country was written
+	if !newCustomer.AgeDeclaration {
+		return nil, false, &AgeError{
+			Code:       AgeNotDeclared,
+			Country:    newCustomer.Address.Country,
+			MinimumAge: config.GetMinimumAge(newCustomer.Address.Country),
+		}
+	}
 
-	addressParams := &stripe.AddressParams{
-		Line1:      stripe.String(newCustomer.Address.Street),
-		PostalCode: stripe.String(newCustomer.Address.PostalCode),
-		State:      stripe.String(newCustomer.Address.Province),
-		City:       stripe.String(newCustomer.Address.City),
-		Country:    stripe.String(newCustomer.Address.Country),
+	if err := VerifyAge(newCustomer.DateOfBirth, newCustomer.Address.Country); err != nil {
+		return nil, false, err
 	}
 
+	newCustomer.Email = NormalizeEmail(newCustomer.Email)
+
+	existing, err := FindByEmail(newCustomer.Email)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	params := newCustomerParams(newCustomer, taxID)
+
+	// a repeat buyer logged in keeps their customer, with the details of the latest checkout
+	if existing != nil {
+		if existing.ID != sessionCustomerID {
+			return nil, false, &ExistsError{CustomerID: existing.ID, Email: newCustomer.Email}
+		}
+
+		fmt.Printf("🔵 [INFO] Customer %s already exists, updating it\n", existing.ID)
+
+		c, err := customer.Update(existing.ID, params)
+
+		if err != nil {
+			return nil, false, fmt.Errorf("customers: error updating customer %s: %v", existing.ID, err)
+		}
+
+		return c, false, nil
+	}
+
+	c, err := customer.New(params)
+
+	return c, err == nil, err
+}
+
+// newCustomerParams Stripe parameters of a new customer, empty company, NIF or names never overwrite existing ones
+func newCustomerParams(newCustomer Customer, taxID *TaxID) *stripe.CustomerParams {
+	name := fullName(newCustomer.FirstName, newCustomer.LastName)
+	addressParams := toAddressParams(newCustomer.Address)
+
+	// orders ship to the customer address unless another shipping is given
 	shipping := &stripe.CustomerShippingDetailsParams{
 		Address: addressParams,
 		Name:    stripe.String(name),
 		Phone:   stripe.String(newCustomer.Phone),
 	}
 
+	if newCustomer.Shipping != nil {
+		shipping = toShippingParams(*newCustomer.Shipping)
+	}
+
 	params := &stripe.CustomerParams{
 		Name:     stripe.String(name),
 		Email:    stripe.String(newCustomer.Email),
@@ -58,17 +183,351 @@ func Create(newCustomer Customer) (*stripe.Customer, error) {
 	}
 
 	metadata := map[string]string{
-		"nifCif":  newCustomer.NifCif,
-		"company": newCustomer.Company,
+		MetadataDateOfBirth:    newCustomer.DateOfBirth,
+		MetadataAgeDeclaration: "true",
+		MetadataAgeDeclaredAt:  time.Now().UTC().Format(time.RFC3339),
+	}
+
+	if taxID != nil {
+		metadata[MetadataNifCif] = taxID.Value
+		metadata[MetadataTaxIDType] = taxID.Type
+	}
+
+	optional := map[string]string{
+		MetadataCompany:   newCustomer.Company,
+		MetadataFirstName: newCustomer.FirstName,
+		MetadataLastName:  newCustomer.LastName,
+	}
+
+	for key, value := range optional {
+		if value != "" {
+			metadata[key] = value
+		}
 	}
 
 	if newCustomer.Lgpd {
-		metadata["lgpd"] = "true"
+		metadata[MetadataLgpd] = "true"
 	}
 
 	for key, value := range metadata {
 		params.AddMetadata(key, value)
 	}
 
-	return customer.New(params)
+	return params
+}
+
+// NormalizeEmail Email as customers are looked up by, trimmed and lowercased
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// FindByEmail Oldest customer with an email, merged duplicates excluded, nil when there is none
+func FindByEmail(email string) (*stripe.Customer, error) {
+	if email == "" {
+		return nil, nil
+	}
+
+	var oldest *stripe.Customer
+	i := customer.List(&stripe.CustomerListParams{Email: stripe.String(email)})
+
+	for i.Next() {
+		c := i.Customer()
+
+		if c.Deleted || c.Metadata[MetadataMergedInto] != "" {
+			continue
+		}
+
+		if oldest == nil || c.Created < oldest.Created {
+			oldest = c
+		}
+	}
+
+	if err := i.Err(); err != nil {
+		return nil, fmt.Errorf("customers: error looking up customers by email: %v", err)
+	}
+
+	return oldest, nil
+}
+
+// Retrieve Retrieve a customer from Stripe BBDD
+func Retrieve(customerID string) (*stripe.Customer, error) {
+	c, err := customer.Get(customerID, nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("customers: error fetching customer %s: %v", customerID, err)
+	}
+
+	return c, nil
+}
+
+// Get Get a customer
+func Get(customerID string) (*Customer, error) {
+	c, err := customer.Get(customerID, nil)
+
+	if stripeError, ok := err.(*stripe.Error); ok && stripeError.Code == stripe.ErrorCodeResourceMissing {
+		return nil, &NotFoundError{ID: customerID}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("customers: error fetching customer %s: %v", customerID, err)
+	}
+
+	if c.Deleted {
+		return nil, &NotFoundError{ID: customerID}
+	}
+
+	found := FromStripe(c)
+
+	return &found, nil
+}
+
+// Update Change the fields of a customer given in the change, the rest are kept
+func Update(customerID string, change CustomerChange) (*Customer, error) {
+	current, err := Get(customerID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	params := &stripe.CustomerParams{}
+
+	if change.FirstName != nil || change.LastName != nil {
+		firstName, lastName := current.FirstName, current.LastName
+
+		if change.FirstName != nil {
+			firstName = *change.FirstName
+			params.AddMetadata(MetadataFirstName, firstName)
+		}
+
+		if change.LastName != nil {
+			lastName = *change.LastName
+			params.AddMetadata(MetadataLastName, lastName)
+		}
+
+		params.Name = stripe.String(fullName(firstName, lastName))
+	}
+
+	// an email belongs to a single customer, taking the one of another would duplicate it again
+	if change.Email != nil {
+		email := NormalizeEmail(*change.Email)
+		existing, err := FindByEmail(email)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if existing != nil && existing.ID != customerID {
+			return nil, &ExistsError{CustomerID: existing.ID, Email: email}
+		}
+
+		params.Email = stripe.String(email)
+	}
+
+	if change.Phone != nil {
+		params.Phone = stripe.String(*change.Phone)
+	}
+
+	fields := []FieldError{}
+
+	if change.Address != nil {
+		address, addressFields := NormalizeAddress(*change.Address, "address")
+		fields = append(fields, addressFields...)
+		params.Address = toAddressParams(address)
+	}
+
+	if change.Shipping != nil {
+		shipping := *change.Shipping
+		address, addressFields := NormalizeAddress(shipping.Address, "shipping.address")
+		fields = append(fields, addressFields...)
+		shipping.Address = address
+		params.Shipping = toShippingParams(shipping)
+	}
+
+	if change.NifCif != nil {
+		company := current.Company
+
+		if change.Company != nil {
+			company = *change.Company
+		}
+
+		taxID, taxIDFields := validateTaxID(*change.NifCif, company)
+		fields = append(fields, taxIDFields...)
+
+		// an empty NIF removes it
+		value, taxIDType := "", ""
+
+		if taxID != nil {
+			value, taxIDType = taxID.Value, taxID.Type
+		}
+
+		params.AddMetadata(MetadataNifCif, value)
+		params.AddMetadata(MetadataTaxIDType, taxIDType)
+	}
+
+	if len(fields) > 0 {
+		return nil, &ValidationError{Fields: fields}
+	}
+
+	if change.Company != nil {
+		params.AddMetadata(MetadataCompany, *change.Company)
+	}
+
+	if change.Lgpd != nil {
+		// an empty value removes the key, as a customer not accepting it never had it
+		lgpd := ""
+
+		if *change.Lgpd {
+			lgpd = "true"
+		}
+
+		params.AddMetadata(MetadataLgpd, lgpd)
+	}
+
+	c, err := customer.Update(customerID, params)
+
+	if err != nil {
+		return nil, fmt.Errorf("customers: error updating customer %s: %v", customerID, err)
+	}
+
+	updated := FromStripe(c)
+
+	return &updated, nil
+}
+
+// List Customers with an email, every customer when it is empty
+func List(email string) ([]*Customer, error) {
+	params := &stripe.CustomerListParams{}
+
+	if email != "" {
+		params.Email = stripe.String(NormalizeEmail(email))
+	}
+
+	list := []*Customer{}
+	i := customer.List(params)
+
+	for i.Next() {
+		c := FromStripe(i.Customer())
+		list = append(list, &c)
+	}
+
+	if err := i.Err(); err != nil {
+		return nil, fmt.Errorf("customers: error listing customers: %v", err)
+	}
+
+	return list, nil
+}
+
+// FromStripe Map a Stripe customer to a customer
+func FromStripe(c *stripe.Customer) Customer {
+	firstName, lastName := c.Metadata[MetadataFirstName], c.Metadata[MetadataLastName]
+
+	// customers created before the names were kept apart only have their full name
+	if firstName == "" && lastName == "" {
+		firstName, lastName = splitName(c.Name)
+	}
+
+	mapped := Customer{
+		ID:             c.ID,
+		Address:        fromStripeAddress(c.Address),
+		AgeDeclaration: c.Metadata[MetadataAgeDeclaration] == "true",
+		Company:        c.Metadata[MetadataCompany],
+		DateOfBirth:    c.Metadata[MetadataDateOfBirth],
+		Email:          c.Email,
+		FirstName:      firstName,
+		LastName:       lastName,
+		Lgpd:           c.Metadata[MetadataLgpd] == "true",
+		NifCif:         c.Metadata[MetadataNifCif],
+		Phone:          c.Phone,
+	}
+
+	if c.Shipping != nil {
+		mapped.Shipping = &Shipping{
+			Address: fromStripeAddress(c.Shipping.Address),
+			Name:    c.Shipping.Name,
+			Phone:   c.Shipping.Phone,
+		}
+	}
+
+	return mapped
+}
+
+func fromStripeAddress(a stripe.Address) Address {
+	return Address{
+		City:       a.City,
+		Country:    a.Country,
+		PostalCode: a.PostalCode,
+		Province:   a.State,
+		Street:     a.Line1,
+		Line2:      a.Line2,
+	}
+}
+
+func toAddressParams(a Address) *stripe.AddressParams {
+	return &stripe.AddressParams{
+		Line1:      stripe.String(a.Street),
+		Line2:      stripe.String(a.Line2),
+		PostalCode: stripe.String(a.PostalCode),
+		State:      stripe.String(a.Province),
+		City:       stripe.String(a.City),
+		Country:    stripe.String(a.Country),
+	}
+}
+
+func toShippingParams(s Shipping) *stripe.CustomerShippingDetailsParams {
+	return &stripe.CustomerShippingDetailsParams{
+		Address: toAddressParams(s.Address),
+		Name:    stripe.String(s.Name),
+		Phone:   stripe.String(s.Phone),
+	}
+}
+
+func fullName(firstName string, lastName string) string {
+	return strings.TrimSpace(firstName + " " + lastName)
+}
+
+func splitName(name string) (string, string) {
+	parts := strings.SplitN(strings.TrimSpace(name), " ", 2)
+
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+
+	return parts[0], parts[1]
+}
+
+var nonAlphanumeric = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Household Key of the address a customer ships to, shared by every customer living there
+func Household(c *stripe.Customer) string {
+	address := c.Address
+
+	if c.Shipping != nil && c.Shipping.Address.Line1 != "" {
+		address = c.Shipping.Address
+	}
+
+	return AddressHousehold(fromStripeAddress(address))
+}
+
+// AddressHousehold Key of an address, the same for every way of writing it, empty without street
+func AddressHousehold(a Address) string {
+	if a.Street == "" {
+		return ""
+	}
+
+	parts := []string{a.Country, a.PostalCode, a.Street, a.Line2}
+
+	for i, part := range parts {
+		parts[i] = nonAlphanumeric.ReplaceAllString(strings.ToLower(part), "")
+	}
+
+	return strings.Join(parts, "|")
+}
+
+// ShippingDetailsParams Stripe parameters of the shipping details of a payment intent
+func ShippingDetailsParams(s Shipping) *stripe.ShippingDetailsParams {
+	return &stripe.ShippingDetailsParams{
+		Address: toAddressParams(s.Address),
+		Name:    stripe.String(s.Name),
+		Phone:   stripe.String(s.Phone),
+	}
 }
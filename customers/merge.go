@@ -0,0 +1,222 @@
+package customers
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/customer"
+)
+
+// Duplicates customers sharing a normalized email, the oldest is the one kept
+type Duplicates struct {
+	Email      string   `json:"email"`
+	Kept       string   `json:"kept"`
+	Duplicates []string `json:"duplicates"`
+}
+
+// MergeSummary result of merging duplicated customers
+type MergeSummary struct {
+	DryRun  bool         `json:"dryRun"`
+	Groups  []Duplicates `json:"groups"`
+	Merged  int          `json:"merged"`
+	Deleted int          `json:"deleted"`
+	Errors  []string     `json:"errors"`
+}
+
+// ReassignFunc move what a duplicated customer owns to the customer kept
+type ReassignFunc func(from string, to string) error
+
+// mergedMetadata metadata copied from the duplicates when the customer kept does not have it
+var mergedMetadata = []string{
+	MetadataNifCif,
+	MetadataCompany,
+	MetadataFirstName,
+	MetadataLastName,
+	MetadataDateOfBirth,
+	MetadataAgeDeclaration,
+	MetadataAgeDeclaredAt,
+	MetadataLgpd,
+}
+
+// Merge Consolidate the customers sharing a normalized email into the oldest one. The kept customer takes the
+// address and shipping of the newest duplicate, duplicates are deleted once reassigned, or marked as merged when
+// they have saved payment methods, which Stripe can not move between customers
+func Merge(dryRun bool, reassign ReassignFunc) *MergeSummary {
+	summary := &MergeSummary{
+		DryRun: dryRun,
+		Groups: []Duplicates{},
+		Errors: []string{},
+	}
+
+	groups, err := findDuplicates()
+
+	if err != nil {
+		summary.Errors = append(summary.Errors, err.Error())
+		return summary
+	}
+
+	for _, group := range groups {
+		kept := group[0]
+
+		duplicates := Duplicates{
+			Email:      NormalizeEmail(kept.Email),
+			Kept:       kept.ID,
+			Duplicates: []string{},
+		}
+
+		for _, c := range group[1:] {
+			duplicates.Duplicates = append(duplicates.Duplicates, c.ID)
+		}
+
+		summary.Groups = append(summary.Groups, duplicates)
+
+		if dryRun {
+			continue
+		}
+
+		if err := mergeGroup(group, reassign, summary); err != nil {
+			summary.Errors = append(summary.Errors, err.Error())
+		}
+	}
+
+	return summary
+}
+
+func mergeGroup(group []*stripe.Customer, reassign ReassignFunc, summary *MergeSummary) error {
+	kept := group[0]
+	newest := group[len(group)-1]
+
+	params := &stripe.CustomerParams{
+		Email:   stripe.String(NormalizeEmail(kept.Email)),
+		Address: toAddressParams(fromStripeAddress(newest.Address)),
+	}
+
+	if newest.Name != "" {
+		params.Name = stripe.String(newest.Name)
+	}
+
+	if newest.Phone != "" {
+		params.Phone = stripe.String(newest.Phone)
+	}
+
+	if newest.Shipping != nil {
+		params.Shipping = &stripe.CustomerShippingDetailsParams{
+			Address: toAddressParams(fromStripeAddress(newest.Shipping.Address)),
+			Name:    stripe.String(newest.Shipping.Name),
+			Phone:   stripe.String(newest.Shipping.Phone),
+		}
+	}
+
+	for _, key := range mergedMetadata {
+		if kept.Metadata[key] != "" {
+			continue
+		}
+
+		for i := len(group) - 1; i > 0; i-- {
+			if value := group[i].Metadata[key]; value != "" {
+				params.AddMetadata(key, value)
+				break
+			}
+		}
+	}
+
+	if _, err := customer.Update(kept.ID, params); err != nil {
+		return fmt.Errorf("customers: error updating customer %s: %v", kept.ID, err)
+	}
+
+	for _, c := range group[1:] {
+		if err := reassign(c.ID, kept.ID); err != nil {
+			return fmt.Errorf("customers: error reassigning customer %s to %s: %v", c.ID, kept.ID, err)
+		}
+
+		paymentMethods, err := ListPaymentMethods(c.ID)
+
+		if err != nil {
+			return err
+		}
+
+		if len(paymentMethods) > 0 {
+			params := &stripe.CustomerParams{}
+			params.AddMetadata(MetadataMergedInto, kept.ID)
+
+			if _, err := customer.Update(c.ID, params); err != nil {
+				return fmt.Errorf("customers: error marking customer %s as merged: %v", c.ID, err)
+			}
+		} else {
+			if _, err := customer.Del(c.ID, nil); err != nil {
+				return fmt.Errorf("customers: error deleting customer %s: %v", c.ID, err)
+			}
+
+			summary.Deleted++
+		}
+
+		summary.Merged++
+	}
+
+	return nil
+}
+
+// findDuplicates customers grouped by normalized email, oldest first, only the emails with more than one
+func findDuplicates() ([][]*stripe.Customer, error) {
+	byEmail := map[string][]*stripe.Customer{}
+	i := customer.List(&stripe.CustomerListParams{})
+
+	for i.Next() {
+		c := i.Customer()
+		email := NormalizeEmail(c.Email)
+
+		if email == "" || c.Deleted || c.Metadata[MetadataMergedInto] != "" {
+			continue
+		}
+
+		byEmail[email] = append(byEmail[email], c)
+	}
+
+	if err := i.Err(); err != nil {
+		return nil, fmt.Errorf("customers: error listing customers: %v", err)
+	}
+
+	groups := [][]*stripe.Customer{}
+
+	for _, group := range byEmail {
+		if len(group) < 2 {
+			continue
+		}
+
+		sort.Slice(group, func(i, j int) bool {
+			return group[i].Created < group[j].Created
+		})
+
+		groups = append(groups, group)
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i][0].Created < groups[j][0].Created
+	})
+
+	return groups, nil
+}
+
+// Print Print the summary of a merge
+func (s *MergeSummary) Print() {
+	duplicates := 0
+
+	for _, g := range s.Groups {
+		duplicates += len(g.Duplicates)
+	}
+
+	if s.DryRun {
+		fmt.Printf("🔵 [INFO] Merge dry run, %d duplicated customers would be merged into %d\n", duplicates, len(s.Groups))
+	} else {
+		fmt.Printf("🔵 [INFO] Merged %d of %d duplicated customers, %d deleted\n", s.Merged, duplicates, s.Deleted)
+	}
+
+	for _, g := range s.Groups {
+		fmt.Printf("   %s  kept %s  duplicates %v\n", g.Email, g.Kept, g.Duplicates)
+	}
+
+	for _, err := range s.Errors {
+		fmt.Printf("🔴 [ERROR] %s\n", err)
+	}
+}
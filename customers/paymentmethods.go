@@ -0,0 +1,78 @@
+package customers
+
+import (
+	"fmt"
+
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/paymentmethod"
+	"github.com/stripe/stripe-go/v72/setupintent"
+)
+
+// CreateSetupIntent Create a setup intent to save a card of the customer for later payments
+func CreateSetupIntent(customerID string) (*stripe.SetupIntent, error) {
+	params := &stripe.SetupIntentParams{
+		Customer:           stripe.String(customerID),
+		PaymentMethodTypes: stripe.StringSlice([]string{"card"}),
+		Usage:              stripe.String(string(stripe.SetupIntentUsageOffSession)),
+	}
+
+	si, err := setupintent.New(params)
+
+	if err != nil {
+		return nil, fmt.Errorf("customers: error creating setup intent: %v", err)
+	}
+
+	return si, nil
+}
+
+// ListPaymentMethods List the cards saved by the customer
+func ListPaymentMethods(customerID string) ([]*stripe.PaymentMethod, error) {
+	paymentMethods := []*stripe.PaymentMethod{}
+
+	params := &stripe.PaymentMethodListParams{
+		Customer: stripe.String(customerID),
+		Type:     stripe.String(string(stripe.PaymentMethodTypeCard)),
+	}
+
+	i := paymentmethod.List(params)
+
+	for i.Next() {
+		paymentMethods = append(paymentMethods, i.PaymentMethod())
+	}
+
+	if err := i.Err(); err != nil {
+		return nil, fmt.Errorf("customers: error listing payment methods: %v", err)
+	}
+
+	return paymentMethods, nil
+}
+
+// RetrievePaymentMethod Retrieve a payment method saved by the customer
+func RetrievePaymentMethod(customerID string, paymentMethodID string) (*stripe.PaymentMethod, error) {
+	pm, err := paymentmethod.Get(paymentMethodID, nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("customers: error fetching payment method: %v", err)
+	}
+
+	if pm.Customer == nil || pm.Customer.ID != customerID {
+		return nil, fmt.Errorf("customers: payment method %s is not saved by customer %s", paymentMethodID, customerID)
+	}
+
+	return pm, nil
+}
+
+// DetachPaymentMethod Remove a payment method saved by the customer
+func DetachPaymentMethod(customerID string, paymentMethodID string) (*stripe.PaymentMethod, error) {
+	if _, err := RetrievePaymentMethod(customerID, paymentMethodID); err != nil {
+		return nil, err
+	}
+
+	pm, err := paymentmethod.Detach(paymentMethodID, nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("customers: error detaching payment method: %v", err)
+	}
+
+	return pm, nil
+}
@@ -0,0 +1,219 @@
+package customers
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Tax ID types
+const (
+	TaxIDNIF = "nif"
+	TaxIDNIE = "nie"
+	TaxIDCIF = "cif"
+	TaxIDVAT = "vat"
+)
+
+// Tax ID validation error codes
+const (
+	TaxIDInvalidFormat  = "invalid_format"
+	TaxIDInvalidControl = "invalid_control"
+)
+
+// TaxID normalized tax identification number of a customer
+type TaxID struct {
+	Value   string `json:"value"`
+	Type    string `json:"type"`
+	Country string `json:"country"`
+	Company bool   `json:"company"`
+}
+
+// TaxIDError a tax ID with a wrong format or control character
+type TaxIDError struct {
+	Code  string
+	Value string
+}
+
+func (e *TaxIDError) Error() string {
+	return "customers: tax ID " + e.Value + " is not valid: " + e.Code
+}
+
+// VATFormats EU VAT number formats by country prefix, without the prefix
+var VATFormats = map[string]*regexp.Regexp{
+	"AT": regexp.MustCompile(`^U\d{8}$`),
+	"BE": regexp.MustCompile(`^[01]\d{9}$`),
+	"BG": regexp.MustCompile(`^\d{9,10}$`),
+	"CY": regexp.MustCompile(`^\d{8}[A-Z]$`),
+	"CZ": regexp.MustCompile(`^\d{8,10}$`),
+	"DE": regexp.MustCompile(`^\d{9}$`),
+	"DK": regexp.MustCompile(`^\d{8}$`),
+	"EE": regexp.MustCompile(`^\d{9}$`),
+	"EL": regexp.MustCompile(`^\d{9}$`),
+	"ES": regexp.MustCompile(`^[0-9A-Z]\d{7}[0-9A-Z]$`),
+	"FI": regexp.MustCompile(`^\d{8}$`),
+	"FR": regexp.MustCompile(`^[0-9A-Z]{2}\d{9}$`),
+	"HR": regexp.MustCompile(`^\d{11}$`),
+	"HU": regexp.MustCompile(`^\d{8}$`),
+	"IE": regexp.MustCompile(`^(\d{7}[A-W][A-I]?|\d[A-Z+*]\d{5}[A-W])$`),
+	"IT": regexp.MustCompile(`^\d{11}$`),
+	"LT": regexp.MustCompile(`^(\d{9}|\d{12})$`),
+	"LU": regexp.MustCompile(`^\d{8}$`),
+	"LV": regexp.MustCompile(`^\d{11}$`),
+	"MT": regexp.MustCompile(`^\d{8}$`),
+	"NL": regexp.MustCompile(`^\d{9}B\d{2}$`),
+	"PL": regexp.MustCompile(`^\d{10}$`),
+	"PT": regexp.MustCompile(`^\d{9}$`),
+	"RO": regexp.MustCompile(`^\d{2,10}$`),
+	"SE": regexp.MustCompile(`^\d{10}01$`),
+	"SI": regexp.MustCompile(`^\d{8}$`),
+	"SK": regexp.MustCompile(`^\d{10}$`),
+	"XI": regexp.MustCompile(`^(\d{9}|\d{12}|GD\d{3}|HA\d{3})$`),
+}
+
+var (
+	taxIDSeparators = regexp.MustCompile(`[\s.\-_/]+`)
+	nifFormat       = regexp.MustCompile(`^(\d{8}|[KLM]\d{7})([A-Z])$`)
+	nieFormat       = regexp.MustCompile(`^([XYZ])(\d{7})([A-Z])$`)
+	cifFormat       = regexp.MustCompile(`^([ABCDEFGHJNPQRSUVW])(\d{7})([0-9A-J])$`)
+)
+
+// nifLetters control letters of NIF and NIE by the remainder of their number by 23
+const nifLetters = "TRWAGMYFPDXBNJZSQVHLCKE"
+
+// cifLetters control letters of CIF by their control digit
+const cifLetters = "JABCDEFGHI"
+
+// NormalizeTaxID Uppercase a tax ID and remove its separators
+func NormalizeTaxID(value string) string {
+	return taxIDSeparators.ReplaceAllString(strings.ToUpper(strings.TrimSpace(value)), "")
+}
+
+// ParseTaxID Validate a Spanish NIF, NIE or CIF, or an EU VAT number with its country prefix
+func ParseTaxID(value string) (TaxID, error) {
+	normalized := NormalizeTaxID(value)
+
+	if normalized == "" {
+		return TaxID{}, &TaxIDError{Code: TaxIDInvalidFormat, Value: value}
+	}
+
+	if id, ok, err := parseSpanishTaxID(normalized); ok {
+		return id, err
+	}
+
+	if len(normalized) < 3 {
+		return TaxID{}, &TaxIDError{Code: TaxIDInvalidFormat, Value: value}
+	}
+
+	country := normalized[:2]
+
+	// Greece uses EL as VAT prefix and not its ISO code
+	if country == "GR" {
+		country = "EL"
+	}
+
+	format, ok := VATFormats[country]
+
+	if !ok || !format.MatchString(normalized[2:]) {
+		return TaxID{}, &TaxIDError{Code: TaxIDInvalidFormat, Value: value}
+	}
+
+	if country == "ES" {
+		id, ok, err := parseSpanishTaxID(normalized[2:])
+
+		if !ok {
+			return TaxID{}, &TaxIDError{Code: TaxIDInvalidFormat, Value: value}
+		}
+
+		if err != nil {
+			return TaxID{}, err
+		}
+
+		id.Value = "ES" + id.Value
+		id.Type = TaxIDVAT
+
+		return id, nil
+	}
+
+	return TaxID{
+		Value:   country + normalized[2:],
+		Type:    TaxIDVAT,
+		Country: country,
+		Company: true,
+	}, nil
+}
+
+// parseSpanishTaxID validate a normalized NIF, NIE or CIF, ok is false when it has none of their formats
+func parseSpanishTaxID(value string) (TaxID, bool, error) {
+	if m := nifFormat.FindStringSubmatch(value); m != nil {
+		digits := m[1]
+
+		// K, L and M NIF of people without DNI are checked by their digits
+		if digits[0] >= 'K' {
+			digits = digits[1:]
+		}
+
+		if !validNIFLetter(digits, m[2]) {
+			return TaxID{}, true, &TaxIDError{Code: TaxIDInvalidControl, Value: value}
+		}
+
+		return TaxID{Value: value, Type: TaxIDNIF, Country: "ES"}, true, nil
+	}
+
+	if m := nieFormat.FindStringSubmatch(value); m != nil {
+		prefix := strconv.Itoa(strings.Index("XYZ", m[1]))
+
+		if !validNIFLetter(prefix+m[2], m[3]) {
+			return TaxID{}, true, &TaxIDError{Code: TaxIDInvalidControl, Value: value}
+		}
+
+		return TaxID{Value: value, Type: TaxIDNIE, Country: "ES"}, true, nil
+	}
+
+	if m := cifFormat.FindStringSubmatch(value); m != nil {
+		if !validCIFControl(m[1], m[2], m[3]) {
+			return TaxID{}, true, &TaxIDError{Code: TaxIDInvalidControl, Value: value}
+		}
+
+		return TaxID{Value: value, Type: TaxIDCIF, Country: "ES", Company: true}, true, nil
+	}
+
+	return TaxID{}, false, nil
+}
+
+func validNIFLetter(digits string, letter string) bool {
+	number, err := strconv.Atoi(digits)
+
+	if err != nil {
+		return false
+	}
+
+	return string(nifLetters[number%23]) == letter
+}
+
+func validCIFControl(kind string, digits string, control string) bool {
+	sum := 0
+
+	for i, d := range digits {
+		n := int(d - '0')
+
+		// odd positions, counting from one, are doubled and their digits added
+		if i%2 == 0 {
+			n *= 2
+			n = n/10 + n%10
+		}
+
+		sum += n
+	}
+
+	digit := (10 - sum%10) % 10
+	letter := string(cifLetters[digit])
+
+	switch {
+	case strings.Contains("NPQRSW", kind):
+		return control == letter
+	case strings.Contains("ABEH", kind):
+		return control == strconv.Itoa(digit)
+	default:
+		return control == letter || control == strconv.Itoa(digit)
+	}
+}
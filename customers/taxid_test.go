@@ -0,0 +1,61 @@
+package customers
+
+import "testing"
+
+func TestParseTaxID(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		want        TaxID
+		wantErrCode string
+	}{
+		{"NIF", "12345678Z", TaxID{Value: "12345678Z", Type: TaxIDNIF, Country: "ES"}, ""},
+		{"NIF with separators", " 12.345.678-z ", TaxID{Value: "12345678Z", Type: TaxIDNIF, Country: "ES"}, ""},
+		{"NIF wrong letter", "12345678A", TaxID{}, TaxIDInvalidControl},
+		{"NIF K of a person without DNI", "K1234567L", TaxID{Value: "K1234567L", Type: TaxIDNIF, Country: "ES"}, ""},
+		{"NIF short", "1234567Z", TaxID{}, TaxIDInvalidFormat},
+		{"NIE X", "X1234567L", TaxID{Value: "X1234567L", Type: TaxIDNIE, Country: "ES"}, ""},
+		{"NIE Y", "Y1234567X", TaxID{Value: "Y1234567X", Type: TaxIDNIE, Country: "ES"}, ""},
+		{"NIE Z", "Z1234567R", TaxID{Value: "Z1234567R", Type: TaxIDNIE, Country: "ES"}, ""},
+		{"NIE wrong letter", "X1234567T", TaxID{}, TaxIDInvalidControl},
+		{"CIF digit control", "B12345674", TaxID{Value: "B12345674", Type: TaxIDCIF, Country: "ES", Company: true}, ""},
+		{"CIF digit control as letter", "A1234567D", TaxID{}, TaxIDInvalidControl},
+		{"CIF wrong digit", "B12345675", TaxID{}, TaxIDInvalidControl},
+		{"CIF letter control", "P1234567D", TaxID{Value: "P1234567D", Type: TaxIDCIF, Country: "ES", Company: true}, ""},
+		{"CIF letter control as digit", "Q12345674", TaxID{}, TaxIDInvalidControl},
+		{"CIF either control", "G12345674", TaxID{Value: "G12345674", Type: TaxIDCIF, Country: "ES", Company: true}, ""},
+		{"CIF wrong letter", "S1234567A", TaxID{}, TaxIDInvalidControl},
+		{"Spanish VAT number", "ESB12345674", TaxID{Value: "ESB12345674", Type: TaxIDVAT, Country: "ES", Company: true}, ""},
+		{"Spanish VAT number wrong control", "ESB12345675", TaxID{}, TaxIDInvalidControl},
+		{"EU VAT number", "fr 40 303 265 045", TaxID{Value: "FR40303265045", Type: TaxIDVAT, Country: "FR", Company: true}, ""},
+		{"Greek ISO prefix", "GR123456789", TaxID{Value: "EL123456789", Type: TaxIDVAT, Country: "EL", Company: true}, ""},
+		{"Greek VAT prefix", "EL123456789", TaxID{Value: "EL123456789", Type: TaxIDVAT, Country: "EL", Company: true}, ""},
+		{"EU VAT number wrong format", "DE12345678", TaxID{}, TaxIDInvalidFormat},
+		{"unknown country", "XX123456789", TaxID{}, TaxIDInvalidFormat},
+		{"empty", " - ", TaxID{}, TaxIDInvalidFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTaxID(tt.value)
+
+			if tt.wantErrCode != "" {
+				taxIDErr, ok := err.(*TaxIDError)
+
+				if !ok || taxIDErr.Code != tt.wantErrCode {
+					t.Fatalf("ParseTaxID(%q) = %+v, %v, want error %s", tt.value, got, err, tt.wantErrCode)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ParseTaxID(%q) error = %v", tt.value, err)
+			}
+
+			if got != tt.want {
+				t.Errorf("ParseTaxID(%q) = %+v, want %+v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package customers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Field validation error codes
+const (
+	FieldRequired = "required"
+)
+
+// FieldError a customer field with a value that is not valid
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError customer fields that are not valid
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	fields := []string{}
+
+	for _, f := range e.Fields {
+		fields = append(fields, f.Field+" "+f.Code)
+	}
+
+	return fmt.Sprintf("customers: invalid fields: %s", strings.Join(fields, ", "))
+}
+
+var taxIDMessages = map[string]string{
+	TaxIDInvalidFormat:  "The NIF, NIE, CIF or EU VAT number does not have a valid format",
+	TaxIDInvalidControl: "The NIF, NIE or CIF control character does not match its number",
+}
+
+// validateTaxID validate the tax ID of a customer and return it normalized, companies must give their name
+func validateTaxID(nifCif string, company string) (*TaxID, []FieldError) {
+	if strings.TrimSpace(nifCif) == "" {
+		return nil, nil
+	}
+
+	id, err := ParseTaxID(nifCif)
+
+	if taxIDError, ok := err.(*TaxIDError); ok {
+		return nil, []FieldError{{
+			Field:   "nifCif",
+			Code:    taxIDError.Code,
+			Message: taxIDMessages[taxIDError.Code],
+		}}
+	}
+
+	if id.Company && strings.TrimSpace(company) == "" {
+		return nil, []FieldError{{
+			Field:   "company",
+			Code:    FieldRequired,
+			Message: "The company name is required with a CIF or VAT number",
+		}}
+	}
+
+	return &id, nil
+}
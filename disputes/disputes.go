@@ -0,0 +1,254 @@
+package disputes
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/dispute"
+
+	"github.com/javierlopezdeancos/stipendivm/customers"
+	"github.com/javierlopezdeancos/stipendivm/inventory"
+	"github.com/javierlopezdeancos/stipendivm/invoices"
+	"github.com/javierlopezdeancos/stipendivm/orders"
+	"github.com/javierlopezdeancos/stipendivm/payments"
+)
+
+// Evidence bundle of our records that proves a disputed order was paid for and delivered
+type Evidence struct {
+	DisputeID              string `json:"disputeId"`
+	OrderID                string `json:"orderId"`
+	CustomerName           string `json:"customerName"`
+	CustomerEmail          string `json:"customerEmail"`
+	CustomerPurchaseIP     string `json:"customerPurchaseIp,omitempty"`
+	BillingAddress         string `json:"billingAddress"`
+	ShippingAddress        string `json:"shippingAddress"`
+	ShippingCarrier        string `json:"shippingCarrier,omitempty"`
+	ShippingTrackingNumber string `json:"shippingTrackingNumber,omitempty"`
+	ShippingDate           string `json:"shippingDate,omitempty"`
+	ProductDescription     string `json:"productDescription"`
+	Receipt                string `json:"receipt,omitempty"`
+	UncategorizedText      string `json:"uncategorizedText"`
+}
+
+// Build Assemble the evidence of a disputed order from the order, its payment intent, the customer and the invoice,
+// the customer and invoice may be nil when we have none
+func Build(d *stripe.Dispute, order *orders.Order, pi *stripe.PaymentIntent, c *stripe.Customer, invoice *invoices.Record) Evidence {
+	e := Evidence{
+		DisputeID:              d.ID,
+		OrderID:                order.ID,
+		ShippingCarrier:        pi.Metadata[payments.MetadataCarrier],
+		ShippingTrackingNumber: pi.Metadata[payments.MetadataTrackingNumber],
+		ShippingDate:           pi.Metadata[payments.MetadataShippedAt],
+		ProductDescription:     describeItems(order.Items),
+	}
+
+	if c != nil {
+		e.CustomerName = c.Name
+		e.CustomerEmail = c.Email
+		e.BillingAddress = formatAddress(&c.Address)
+	}
+
+	e.ShippingAddress = shippingAddress(pi, c)
+
+	if e.CustomerName == "" {
+		e.CustomerName = pi.Shipping.Name
+	}
+
+	if order.Mandate != nil {
+		e.CustomerPurchaseIP = order.Mandate.IPAddress
+	}
+
+	lines := []string{
+		fmt.Sprintf("Order %s placed on %s and paid with PaymentIntent %s.", order.ID, order.CreatedAt.Format("2006-01-02"), pi.ID),
+	}
+
+	if invoice != nil {
+		e.Receipt = fmt.Sprintf(
+			"Invoice %s issued on %s for %s %s, taxes included.",
+			invoice.Number,
+			invoice.IssueDate,
+			invoices.FormatAmount(invoice.TotalAmount),
+			strings.ToUpper(invoice.Currency),
+		)
+
+		lines = append(lines, e.Receipt, fmt.Sprintf("Invoice fingerprint %s.", invoice.Hash))
+	}
+
+	if e.ShippingTrackingNumber != "" {
+		lines = append(lines, fmt.Sprintf(
+			"Shipped on %s with %s, tracking number %s.",
+			e.ShippingDate,
+			e.ShippingCarrier,
+			e.ShippingTrackingNumber,
+		))
+	}
+
+	if order.Mandate != nil {
+		lines = append(lines, fmt.Sprintf(
+			"SEPA Direct Debit mandate %s accepted online on %s from %s.",
+			order.Mandate.ID,
+			order.Mandate.AcceptedAt.Format(time.RFC3339),
+			order.Mandate.IPAddress,
+		))
+	}
+
+	e.UncategorizedText = strings.Join(lines, "\n")
+
+	return e
+}
+
+// Params Evidence as the Stripe dispute evidence parameters
+func (e Evidence) Params() *stripe.DisputeEvidenceParams {
+	params := &stripe.DisputeEvidenceParams{
+		BillingAddress:       stripe.String(e.BillingAddress),
+		CustomerEmailAddress: stripe.String(e.CustomerEmail),
+		CustomerName:         stripe.String(e.CustomerName),
+		ProductDescription:   stripe.String(e.ProductDescription),
+		ShippingAddress:      stripe.String(e.ShippingAddress),
+		UncategorizedText:    stripe.String(e.UncategorizedText),
+	}
+
+	optional := map[**string]string{
+		&params.CustomerPurchaseIP:     e.CustomerPurchaseIP,
+		&params.ShippingCarrier:        e.ShippingCarrier,
+		&params.ShippingTrackingNumber: e.ShippingTrackingNumber,
+		&params.ShippingDate:           e.ShippingDate,
+	}
+
+	for field, value := range optional {
+		if value != "" {
+			*field = stripe.String(value)
+		}
+	}
+
+	return params
+}
+
+// Assemble Retrieve a dispute and build its evidence from the order it disputes
+func Assemble(disputeID string) (*stripe.Dispute, Evidence, error) {
+	d, err := dispute.Get(disputeID, nil)
+
+	if err != nil {
+		return nil, Evidence{}, fmt.Errorf("disputes: error fetching dispute: %v", err)
+	}
+
+	if d.PaymentIntent == nil {
+		return nil, Evidence{}, fmt.Errorf("disputes: dispute %s has no payment intent", disputeID)
+	}
+
+	order, ok := orders.Default.FindByPaymentIntent(d.PaymentIntent.ID)
+
+	if !ok {
+		return nil, Evidence{}, fmt.Errorf("disputes: no order paid by payment intent %s", d.PaymentIntent.ID)
+	}
+
+	pi, err := payments.RetrieveIntent(d.PaymentIntent.ID)
+
+	if err != nil {
+		return nil, Evidence{}, err
+	}
+
+	var c *stripe.Customer
+
+	if order.CustomerID != "" {
+		c, err = customers.Retrieve(order.CustomerID)
+
+		if err != nil {
+			return nil, Evidence{}, err
+		}
+	}
+
+	var invoice *invoices.Record
+
+	if record, ok := invoices.Default.Find(pi.ID); ok {
+		invoice = &record
+	}
+
+	return d, Build(d, order, pi, c, invoice), nil
+}
+
+// Submit Assemble the evidence of a dispute and submit it to Stripe, it can not be changed afterwards
+func Submit(disputeID string) (*stripe.Dispute, Evidence, error) {
+	d, e, err := Assemble(disputeID)
+
+	if err != nil {
+		return nil, Evidence{}, err
+	}
+
+	if d.Status != stripe.DisputeStatusNeedsResponse && d.Status != stripe.DisputeStatusWarningNeedsResponse {
+		return nil, Evidence{}, fmt.Errorf("disputes: dispute %s does not accept evidence in status %s", d.ID, d.Status)
+	}
+
+	params := &stripe.DisputeParams{
+		Evidence: e.Params(),
+		Submit:   stripe.Bool(true),
+	}
+
+	d, err = dispute.Update(d.ID, params)
+
+	if err != nil {
+		return nil, Evidence{}, fmt.Errorf("disputes: error submitting evidence: %v", err)
+	}
+
+	if order, ok := orders.Default.FindByPaymentIntent(d.PaymentIntent.ID); ok {
+		_, err = orders.Default.Update(order.ID, func(o *orders.Order) {
+			if o.Dispute != nil {
+				o.Dispute.Status = string(d.Status)
+				o.Dispute.SubmittedAt = time.Now().UTC()
+			}
+		})
+
+		if err != nil {
+			return nil, Evidence{}, err
+		}
+	}
+
+	return d, e, nil
+}
+
+// describeItems one line per wine and the bottles bought of it
+func describeItems(items []inventory.Item) string {
+	lines := []string{}
+
+	for _, item := range items {
+		lines = append(lines, fmt.Sprintf("%d x wine %s", item.Quantity, item.Parent))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// shippingAddress where the order was shipped, payment intents without shipping were shipped as the checkout
+// household to the customer shipping address, or to its address when it has none
+func shippingAddress(pi *stripe.PaymentIntent, c *stripe.Customer) string {
+	if address := formatAddress(pi.Shipping.Address); address != "" {
+		return address
+	}
+
+	if c == nil {
+		return ""
+	}
+
+	if c.Shipping != nil && c.Shipping.Address.Line1 != "" {
+		return formatAddress(&c.Shipping.Address)
+	}
+
+	return formatAddress(&c.Address)
+}
+
+func formatAddress(a *stripe.Address) string {
+	if a == nil {
+		return ""
+	}
+
+	parts := []string{}
+
+	for _, part := range []string{a.Line1, a.Line2, a.PostalCode + " " + a.City, a.State, a.Country} {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,53 @@
+package disputes
+
+import (
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"github.com/javierlopezdeancos/stipendivm/orders"
+)
+
+func TestBuildShippingAddress(t *testing.T) {
+	shipped := &stripe.PaymentIntent{
+		ID: "pi_1",
+		Shipping: stripe.ShippingDetails{
+			Name:    "Ana García",
+			Address: &stripe.Address{Line1: "Calle Mayor 1", PostalCode: "28013", City: "Madrid", Country: "ES"},
+		},
+	}
+	customer := &stripe.Customer{
+		Name:    "Ana García",
+		Address: stripe.Address{Line1: "Calle de Alcalá 20", PostalCode: "28014", City: "Madrid", Country: "ES"},
+		Shipping: &stripe.CustomerShippingDetails{
+			Name:    "Ana García",
+			Address: stripe.Address{Line1: "Rúa do Franco 3", PostalCode: "15705", City: "Santiago", Country: "ES"},
+		},
+	}
+	withoutShipping := &stripe.Customer{
+		Name:    "Ana García",
+		Address: stripe.Address{Line1: "Calle de Alcalá 20", PostalCode: "28014", City: "Madrid", Country: "ES"},
+	}
+
+	tests := []struct {
+		name     string
+		pi       *stripe.PaymentIntent
+		customer *stripe.Customer
+		want     string
+	}{
+		{"payment intent shipping", shipped, customer, "Calle Mayor 1, 28013 Madrid, ES"},
+		{"customer shipping", &stripe.PaymentIntent{ID: "pi_2"}, customer, "Rúa do Franco 3, 15705 Santiago, ES"},
+		{"customer address", &stripe.PaymentIntent{ID: "pi_3"}, withoutShipping, "Calle de Alcalá 20, 28014 Madrid, ES"},
+		{"guest without shipping", &stripe.PaymentIntent{ID: "pi_4"}, nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := Build(&stripe.Dispute{ID: "dp_1"}, &orders.Order{ID: "order_1"}, tt.pi, tt.customer, nil)
+
+			if e.ShippingAddress != tt.want {
+				t.Errorf("Build() shipping address = %q, want %q", e.ShippingAddress, tt.want)
+			}
+		})
+	}
+}
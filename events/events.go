@@ -0,0 +1,144 @@
+package events
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Event event published to the subscribers of a topic
+type Event struct {
+	ID   string
+	Type string
+	Data interface{}
+}
+
+type topic struct {
+	events      []Event
+	subscribers map[chan Event]bool
+	updatedAt   time.Time
+}
+
+// Hub in-process pub/sub keeping the last events of every topic so subscribers can catch up
+type Hub struct {
+	mu      sync.Mutex
+	topics  map[string]*topic
+	history int
+	idle    time.Duration
+	lastID  int64
+}
+
+// Default hub used by the server
+var Default *Hub
+
+// subscriberBuffer events a subscriber can fall behind before it is disconnected
+const subscriberBuffer = 16
+
+// NewHub New hub keeping history events per topic, topics without subscribers are forgotten after idle
+func NewHub(history int, idle time.Duration) *Hub {
+	return &Hub{
+		topics:  map[string]*topic{},
+		history: history,
+		idle:    idle,
+		// IDs keep growing across restarts so a stale Last-Event-ID never hides new events
+		lastID: time.Now().UnixNano(),
+	}
+}
+
+// Publish Publish an event to every subscriber of a topic
+func (h *Hub) Publish(name string, eventType string, data interface{}) Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	h.forgetIdle(now)
+
+	h.lastID++
+	e := Event{
+		ID:   strconv.FormatInt(h.lastID, 10),
+		Type: eventType,
+		Data: data,
+	}
+
+	t := h.topic(name)
+	t.updatedAt = now
+	t.events = append(t.events, e)
+
+	if len(t.events) > h.history {
+		t.events = t.events[len(t.events)-h.history:]
+	}
+
+	for ch := range t.subscribers {
+		select {
+		case ch <- e:
+		default:
+			// a subscriber that can not keep up is dropped, it reconnects with its Last-Event-ID
+			delete(t.subscribers, ch)
+			close(ch)
+		}
+	}
+
+	return e
+}
+
+// Subscribe Subscribe to a topic, returning the kept events published after lastEventID,
+// the channel of new events, closed when the subscriber is dropped, and a function to unsubscribe
+func (h *Hub) Subscribe(name string, lastEventID string) ([]Event, <-chan Event, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	t := h.topic(name)
+	ch := make(chan Event, subscriberBuffer)
+	t.subscribers[ch] = true
+
+	missed := []Event{}
+
+	if lastEventID != "" {
+		last, err := strconv.ParseInt(lastEventID, 10, 64)
+
+		for _, e := range t.events {
+			id, _ := strconv.ParseInt(e.ID, 10, 64)
+
+			if err != nil || id > last {
+				missed = append(missed, e)
+			}
+		}
+	}
+
+	cancel := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if t.subscribers[ch] {
+			delete(t.subscribers, ch)
+			close(ch)
+		}
+
+		t.updatedAt = time.Now()
+	}
+
+	return missed, ch, cancel
+}
+
+func (h *Hub) topic(name string) *topic {
+	t, ok := h.topics[name]
+
+	if !ok {
+		t = &topic{
+			subscribers: map[chan Event]bool{},
+			updatedAt:   time.Now(),
+		}
+
+		h.topics[name] = t
+	}
+
+	return t
+}
+
+func (h *Hub) forgetIdle(now time.Time) {
+	for name, t := range h.topics {
+		if len(t.subscribers) == 0 && now.Sub(t.updatedAt) > h.idle {
+			delete(h.topics, name)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package inventory
 
 import (
 	"fmt"
+	"strconv"
 
 	"github.com/stripe/stripe-go/v72"
 	"github.com/stripe/stripe-go/v72/price"
@@ -17,6 +18,47 @@ type Item struct {
 	Quantity int64  `json:"quantity"`
 }
 
+// Merge Items with a single line per wine adding up the quantities of its lines, in the order wines first appear
+func Merge(items []Item) []Item {
+	merged := []Item{}
+	lines := map[string]int{}
+
+	for _, item := range items {
+		if i, ok := lines[item.Parent]; ok {
+			merged[i].Quantity += item.Quantity
+			continue
+		}
+
+		lines[item.Parent] = len(merged)
+		merged = append(merged, item)
+	}
+
+	return merged
+}
+
+// SameItems whether two carts have the same quantity of every wine, however their lines are split
+func SameItems(a []Item, b []Item) bool {
+	quantities := map[string]int64{}
+
+	for _, item := range Merge(a) {
+		quantities[item.Parent] = item.Quantity
+	}
+
+	merged := Merge(b)
+
+	if len(merged) != len(quantities) {
+		return false
+	}
+
+	for _, item := range merged {
+		if quantities[item.Parent] != item.Quantity {
+			return false
+		}
+	}
+
+	return true
+}
+
 // ListWines Wines list
 func ListWines() ([]*stripe.Product, error) {
 	wines := []*stripe.Product{}
@@ -69,15 +111,28 @@ func RetrieveWine(wineID string) (*stripe.Product, error) {
 	return product.Get(wineID, nil)
 }
 
-// UpdateWineStock Update wine stock
-func UpdateWineStock(wineID string, stock string) (*stripe.Product, error) {
+// DecreaseWineStock Take the bottles sold out of the stock kept in the quantity metadata of a wine
+func DecreaseWineStock(wineID string, sold int64) (*stripe.Product, error) {
 	wine, err := RetrieveWine(wineID)
 
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("inventory: error retrieving wine %s: %v", wineID, err)
 	}
 
-	wine.Metadata["quantity"] = stock
+	stock, err := strconv.ParseInt(wine.Metadata["quantity"], 10, 64)
+
+	if err != nil {
+		return nil, fmt.Errorf("inventory: wine %s has no valid quantity: %v", wineID, err)
+	}
+
+	params := &stripe.ProductParams{}
+	params.AddMetadata("quantity", strconv.FormatInt(stock-sold, 10))
+
+	wine, err = product.Update(wineID, params)
+
+	if err != nil {
+		return nil, fmt.Errorf("inventory: error updating stock of wine %s: %v", wineID, err)
+	}
 
 	return wine, nil
 }
@@ -105,23 +160,42 @@ func ListSKUs(productID string) ([]*stripe.SKU, error) {
 
 }
 
-// CalculatePaymentAmount Calc payment amount
-func CalculatePaymentAmount(items []Item) (int64, error) {
+// CalculatePaymentAmount Calc payment amount at the prices of unitAmount, the catalog prices when it is nil
+func CalculatePaymentAmount(items []Item, unitAmount func(wineID string) (int64, error)) (int64, error) {
+	if unitAmount == nil {
+		unitAmount = UnitAmount
+	}
+
 	total := int64(0)
 
 	for _, item := range items {
-		prices, err := ListPrices(item.Parent)
+		price, err := unitAmount(item.Parent)
 
 		if err != nil {
 			return 0, fmt.Errorf("inventory: error getting SKU for price: %v", err)
 		}
 
-		total += prices[0].UnitAmount * item.Quantity
+		total += price * item.Quantity
 	}
 
 	return total, nil
 }
 
+// UnitAmount Price of a wine bottle
+func UnitAmount(wineID string) (int64, error) {
+	prices, err := ListPrices(wineID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if len(prices) == 0 {
+		return 0, fmt.Errorf("inventory: no price found for wine %s", wineID)
+	}
+
+	return prices[0].UnitAmount, nil
+}
+
 // ListPrices Prices list
 func ListPrices(args ...string) ([]*stripe.Price, error) {
 	prices := []*stripe.Price{}
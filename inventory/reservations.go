@@ -0,0 +1,189 @@
+package inventory
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/javierlopezdeancos/stipendivm/storage"
+)
+
+// Reservation wines held for a payment intent until it is paid or canceled
+type Reservation struct {
+	PaymentIntentID string    `json:"paymentIntentId"`
+	Items           []Item    `json:"items"`
+	CreatedAt       time.Time `json:"createdAt"`
+}
+
+// ReservationStore stock reservations persisted on disk
+type ReservationStore struct {
+	mu           sync.Mutex
+	path         string
+	reservations map[string]*Reservation
+}
+
+// StockError a wine without stock enough left for a reservation
+type StockError struct {
+	Wine      string
+	Available int64
+	Requested int64
+}
+
+func (e *StockError) Error() string {
+	return fmt.Sprintf("inventory: wine %s has %d bottles available, %d requested", e.Wine, e.Available, e.Requested)
+}
+
+// Reservations store used by the server
+var Reservations *ReservationStore
+
+// NewReservationStore Load the reservations stored in path, an empty store if it does not exist yet
+func NewReservationStore(path string) (*ReservationStore, error) {
+	s := &ReservationStore{
+		path:         path,
+		reservations: map[string]*Reservation{},
+	}
+
+	if err := storage.ReadJSON(path, &s.reservations); err != nil {
+		return nil, fmt.Errorf("inventory: error loading reservations: %v", err)
+	}
+
+	return s, nil
+}
+
+// Reserve Hold the wines of a payment intent, replacing what it held before. The stock of every wine, less what the
+// other payment intents hold, is checked in the same lock, so two checkouts can not hold the last bottles.
+func (s *ReservationStore) Reserve(paymentIntentID string, items []Item, stock map[string]int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, item := range Merge(items) {
+		if available := stock[item.Parent] - s.reserved(item.Parent, paymentIntentID); available < item.Quantity {
+			return &StockError{Wine: item.Parent, Available: available, Requested: item.Quantity}
+		}
+	}
+
+	previous, had := s.reservations[paymentIntentID]
+
+	s.reservations[paymentIntentID] = &Reservation{
+		PaymentIntentID: paymentIntentID,
+		Items:           items,
+		CreatedAt:       time.Now().UTC(),
+	}
+
+	if err := s.save(); err != nil {
+		if had {
+			s.reservations[paymentIntentID] = previous
+		} else {
+			delete(s.reservations, paymentIntentID)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// Release Free the wines held for a payment intent, returning them
+func (s *ReservationStore) Release(paymentIntentID string) ([]Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.reservations[paymentIntentID]
+
+	if !ok {
+		return nil, nil
+	}
+
+	delete(s.reservations, paymentIntentID)
+
+	if err := s.save(); err != nil {
+		s.reservations[paymentIntentID] = r
+		return nil, err
+	}
+
+	return r.Items, nil
+}
+
+// ReleaseWine Free the bottles of a wine held for a payment intent, the reservation is removed with its last wine
+func (s *ReservationStore) ReleaseWine(paymentIntentID string, wineID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.reservations[paymentIntentID]
+
+	if !ok {
+		return nil
+	}
+
+	items := []Item{}
+
+	for _, item := range r.Items {
+		if item.Parent != wineID {
+			items = append(items, item)
+		}
+	}
+
+	if len(items) == 0 {
+		delete(s.reservations, paymentIntentID)
+	} else {
+		s.reservations[paymentIntentID] = &Reservation{PaymentIntentID: r.PaymentIntentID, Items: items, CreatedAt: r.CreatedAt}
+	}
+
+	if err := s.save(); err != nil {
+		s.reservations[paymentIntentID] = r
+		return err
+	}
+
+	return nil
+}
+
+// Held Wines held for a payment intent, false when it holds none
+func (s *ReservationStore) Held(paymentIntentID string) ([]Item, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.reservations[paymentIntentID]
+
+	if !ok {
+		return nil, false
+	}
+
+	items := make([]Item, len(r.Items))
+	copy(items, r.Items)
+
+	return items, true
+}
+
+// Reserved Bottles of a wine held by every payment intent but the excluded one
+func (s *ReservationStore) Reserved(wineID string, excludedPaymentIntentID string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.reserved(wineID, excludedPaymentIntentID)
+}
+
+func (s *ReservationStore) reserved(wineID string, excludedPaymentIntentID string) int64 {
+	total := int64(0)
+
+	for paymentIntentID, r := range s.reservations {
+		if paymentIntentID == excludedPaymentIntentID {
+			continue
+		}
+
+		for _, item := range r.Items {
+			if item.Parent == wineID {
+				total += item.Quantity
+			}
+		}
+	}
+
+	return total
+}
+
+func (s *ReservationStore) save() error {
+	if err := storage.WriteJSON(s.path, s.reservations); err != nil {
+		return fmt.Errorf("inventory: error saving reservations: %v", err)
+	}
+
+	return nil
+}
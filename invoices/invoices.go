@@ -0,0 +1,360 @@
+package invoices
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"github.com/javierlopezdeancos/stipendivm/config"
+	"github.com/javierlopezdeancos/stipendivm/payments"
+	"github.com/javierlopezdeancos/stipendivm/quotes"
+	"github.com/javierlopezdeancos/stipendivm/storage"
+)
+
+// Invoice record types as named by the Verifactu regulation
+const (
+	TypeInvoice    = "F1"
+	TypeCreditNote = "R1"
+)
+
+// ExemptionIntraCommunity Verifactu exemption of intra-EU sales to VAT registered businesses, art. 25 Ley 37/1992
+const ExemptionIntraCommunity = "E5"
+
+// Record an invoice or credit note chained to the previous one by its hash
+type Record struct {
+	IssuerID        string  `json:"issuerId"`
+	IssuerName      string  `json:"issuerName"`
+	Number          string  `json:"number"`
+	IssueDate       string  `json:"issueDate"`
+	Type            string  `json:"type"`
+	Rectified       string  `json:"rectified,omitempty"`
+	RectifiedDate   string  `json:"rectifiedDate,omitempty"`
+	Currency        string  `json:"currency"`
+	TaxRate         float64 `json:"taxRate"`
+	TaxBase         int64   `json:"taxBase"`
+	TaxAmount       int64   `json:"taxAmount"`
+	TotalAmount     int64   `json:"totalAmount"`
+	PreviousHash    string  `json:"previousHash"`
+	Hash            string  `json:"hash"`
+	GeneratedAt     string  `json:"generatedAt"`
+	PaymentIntentID string  `json:"paymentIntentId"`
+	CustomerID      string  `json:"customerId,omitempty"`
+	// Exemption why the sale is not charged VAT, empty when it is charged
+	Exemption  string `json:"exemption,omitempty"`
+	BuyerName  string `json:"buyerName,omitempty"`
+	BuyerTaxID string `json:"buyerTaxId,omitempty"`
+	TaxMention string `json:"taxMention,omitempty"`
+}
+
+// ChainError a record that breaks the hash chain
+type ChainError struct {
+	Index  int    `json:"index"`
+	Number string `json:"number"`
+	Reason string `json:"reason"`
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("invoices: chain broken at record %d (%s): %s", e.Index, e.Number, e.Reason)
+}
+
+// Registry ordered list of invoice records persisted on disk
+type Registry struct {
+	mu      sync.Mutex
+	path    string
+	issuer  config.Issuer
+	now     func() time.Time
+	records []Record
+}
+
+// Default registry used by the server
+var Default *Registry
+
+// NewRegistry Load the registry stored in path, an empty one if it does not exist yet
+func NewRegistry(path string, issuer config.Issuer) (*Registry, error) {
+	r := &Registry{
+		path:   path,
+		issuer: issuer,
+		now:    time.Now,
+	}
+
+	if err := storage.ReadJSON(path, &r.records); err != nil {
+		return nil, fmt.Errorf("invoices: error loading registry: %v", err)
+	}
+
+	return r, nil
+}
+
+// Records Copy of every record in chain order
+func (r *Registry) Records() []Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	records := make([]Record, len(r.records))
+	copy(records, r.records)
+
+	return records
+}
+
+// Find Find the invoice issued for a payment intent
+func (r *Registry) Find(paymentIntentID string) (Record, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.find(paymentIntentID)
+}
+
+func (r *Registry) find(paymentIntentID string) (Record, bool) {
+	for _, record := range r.records {
+		if record.PaymentIntentID == paymentIntentID && record.Type == TypeInvoice {
+			return record, true
+		}
+	}
+
+	return Record{}, false
+}
+
+// Issue Issue the invoice of a paid payment intent, issuing it again returns the existing record and created false
+func (r *Registry) Issue(pi *stripe.PaymentIntent) (*Record, bool, error) {
+	if pi.Status != stripe.PaymentIntentStatusSucceeded {
+		return nil, false, fmt.Errorf("invoices: payment intent %s is %s, not paid", pi.ID, pi.Status)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if record, ok := r.find(pi.ID); ok {
+		return &record, false, nil
+	}
+
+	total := pi.AmountReceived
+
+	if total == 0 {
+		total = pi.Amount
+	}
+
+	record := r.newRecord(pi, TypeInvoice, total)
+	issued, err := r.append(record)
+
+	return issued, err == nil, err
+}
+
+// IssueOnCredit Issue the invoice of a payment intent paid later on the payment terms of a trade customer, issuing
+// it again, or once paid, returns the existing record
+func (r *Registry) IssueOnCredit(pi *stripe.PaymentIntent) (*Record, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if record, ok := r.find(pi.ID); ok {
+		return &record, nil
+	}
+
+	record := r.newRecord(pi, TypeInvoice, pi.Amount)
+
+	return r.append(record)
+}
+
+// IssueCreditNote Issue a credit note rectifying the invoice of a payment intent by amount
+func (r *Registry) IssueCreditNote(pi *stripe.PaymentIntent, amount int64) (*Record, error) {
+	if amount <= 0 {
+		return nil, fmt.Errorf("invoices: credit note amount must be positive, got %d", amount)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	invoice, ok := r.find(pi.ID)
+
+	if !ok {
+		return nil, fmt.Errorf("invoices: no invoice issued for payment intent %s", pi.ID)
+	}
+
+	if amount > invoice.TotalAmount+r.credited(pi.ID) {
+		return nil, fmt.Errorf("invoices: credit note of %d exceeds the amount left on invoice %s", amount, invoice.Number)
+	}
+
+	record := r.newRecord(pi, TypeCreditNote, -amount)
+	record.Rectified = invoice.Number
+	record.RectifiedDate = invoice.IssueDate
+	record.TaxRate = invoice.TaxRate
+	record.TaxBase, record.TaxAmount = splitTax(-amount, invoice.TaxRate)
+	record.Exemption = invoice.Exemption
+	record.BuyerName = invoice.BuyerName
+	record.BuyerTaxID = invoice.BuyerTaxID
+	record.TaxMention = invoice.TaxMention
+
+	return r.append(record)
+}
+
+// Credited Total amount already rectified by credit notes for a payment intent, as a positive value
+func (r *Registry) Credited(paymentIntentID string) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return -r.credited(paymentIntentID)
+}
+
+func (r *Registry) credited(paymentIntentID string) int64 {
+	total := int64(0)
+
+	for _, record := range r.records {
+		if record.PaymentIntentID == paymentIntentID && record.Type == TypeCreditNote {
+			total += record.TotalAmount
+		}
+	}
+
+	return total
+}
+
+// Verify Check every record hash and its link to the previous record
+func (r *Registry) Verify() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	numbers := map[string]bool{}
+	previous := ""
+
+	for i, record := range r.records {
+		if numbers[record.Number] {
+			return &ChainError{Index: i, Number: record.Number, Reason: "duplicated invoice number"}
+		}
+
+		numbers[record.Number] = true
+
+		if record.PreviousHash != previous {
+			return &ChainError{Index: i, Number: record.Number, Reason: "previous hash does not match the previous record"}
+		}
+
+		if record.Hash != record.fingerprint() {
+			return &ChainError{Index: i, Number: record.Number, Reason: "hash does not match the record content"}
+		}
+
+		previous = record.Hash
+	}
+
+	return nil
+}
+
+func (r *Registry) newRecord(pi *stripe.PaymentIntent, recordType string, total int64) Record {
+	now := r.now()
+	rate := config.GetVATRate()
+
+	if taxRate, err := strconv.ParseFloat(pi.Metadata[payments.MetadataTaxRate], 64); err == nil {
+		rate = taxRate
+	}
+
+	base, tax := splitTax(total, rate)
+
+	record := Record{
+		IssuerID:        r.issuer.NIF,
+		IssuerName:      r.issuer.Name,
+		Number:          r.nextNumber(recordType, now),
+		IssueDate:       now.Format("02-01-2006"),
+		Type:            recordType,
+		Currency:        strings.ToUpper(pi.Currency),
+		TaxRate:         rate,
+		TaxBase:         base,
+		TaxAmount:       tax,
+		TotalAmount:     total,
+		GeneratedAt:     now.Format(time.RFC3339),
+		PaymentIntentID: pi.ID,
+	}
+
+	if pi.Customer != nil {
+		record.CustomerID = pi.Customer.ID
+	}
+
+	// intra-EU sales to businesses are zero-rated, the buyer accounts for the VAT in its country
+	if pi.Metadata[payments.MetadataReverseCharge] == "true" {
+		record.TaxRate = 0
+		record.TaxBase, record.TaxAmount = total, 0
+		record.Exemption = ExemptionIntraCommunity
+		record.BuyerName = pi.Metadata[payments.MetadataBuyerName]
+		record.BuyerTaxID = pi.Metadata[payments.MetadataBuyerVATID]
+		record.TaxMention = quotes.ReverseChargeMention
+	}
+
+	return record
+}
+
+func (r *Registry) append(record Record) (*Record, error) {
+	if len(r.records) > 0 {
+		record.PreviousHash = r.records[len(r.records)-1].Hash
+	}
+
+	record.Hash = record.fingerprint()
+
+	records := append(r.records, record)
+
+	if err := storage.WriteJSON(r.path, records); err != nil {
+		return nil, fmt.Errorf("invoices: error saving record %s: %v", record.Number, err)
+	}
+
+	r.records = records
+
+	return &record, nil
+}
+
+// nextNumber credit notes are numbered in their own series as the law requires
+func (r *Registry) nextNumber(recordType string, now time.Time) string {
+	series := r.issuer.Series
+
+	if recordType == TypeCreditNote {
+		series += "R"
+	}
+
+	prefix := fmt.Sprintf("%s%d-", series, now.Year())
+	count := 0
+
+	for _, record := range r.records {
+		if strings.HasPrefix(record.Number, prefix) {
+			count++
+		}
+	}
+
+	return fmt.Sprintf("%s%06d", prefix, count+1)
+}
+
+// fingerprint SHA-256 over the fields the regulation chains, in its order and format
+func (record Record) fingerprint() string {
+	chain := fmt.Sprintf(
+		"IDEmisorFactura=%s&NumSerieFactura=%s&FechaExpedicionFactura=%s&TipoFactura=%s&CuotaTotal=%s&ImporteTotal=%s&Huella=%s&FechaHoraHusoGenRegistro=%s",
+		strings.TrimSpace(record.IssuerID),
+		strings.TrimSpace(record.Number),
+		record.IssueDate,
+		record.Type,
+		FormatAmount(record.TaxAmount),
+		FormatAmount(record.TotalAmount),
+		record.PreviousHash,
+		record.GeneratedAt,
+	)
+
+	sum := sha256.Sum256([]byte(chain))
+
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// splitTax split a total that already includes tax into its tax base and tax amount
+func splitTax(total int64, rate float64) (int64, int64) {
+	base := int64(math.Round(float64(total) * 100 / (100 + rate)))
+
+	return base, total - base
+}
+
+// FormatAmount Format cents as a decimal amount with two digits
+func FormatAmount(cents int64) string {
+	sign := ""
+
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
@@ -0,0 +1,318 @@
+package invoices
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"github.com/javierlopezdeancos/stipendivm/config"
+	"github.com/javierlopezdeancos/stipendivm/payments"
+)
+
+// newTestRegistry empty registry stored in a temporary directory with a fixed clock
+func newTestRegistry(t *testing.T) *Registry {
+	dir, err := ioutil.TempDir("", "invoices")
+
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %v", err)
+	}
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	r, err := NewRegistry(filepath.Join(dir, "invoices.json"), config.Issuer{NIF: "B12345674", Name: "Bodegas SL", Series: "Q"})
+
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+
+	r.now = func() time.Time { return time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC) }
+
+	return r
+}
+
+// paid succeeded payment intent of amount charged at 21% VAT
+func paid(id string, amount int64) *stripe.PaymentIntent {
+	return &stripe.PaymentIntent{
+		ID:             id,
+		Status:         stripe.PaymentIntentStatusSucceeded,
+		Amount:         amount,
+		AmountReceived: amount,
+		Currency:       "eur",
+		Metadata:       map[string]string{payments.MetadataTaxRate: "21"},
+	}
+}
+
+func TestRegistryIssue(t *testing.T) {
+	r := newTestRegistry(t)
+	reverseCharge := paid("pi_reverse", 10000)
+	reverseCharge.Metadata[payments.MetadataReverseCharge] = "true"
+	reverseCharge.Metadata[payments.MetadataBuyerName] = "Vins de Bordeaux SARL"
+	reverseCharge.Metadata[payments.MetadataBuyerVATID] = "FR40303265045"
+
+	tests := []struct {
+		name          string
+		pi            *stripe.PaymentIntent
+		wantErr       bool
+		wantCreated   bool
+		wantNumber    string
+		wantTaxBase   int64
+		wantTaxAmount int64
+		wantExemption string
+	}{
+		{"paid", paid("pi_1", 12100), false, true, "Q2026-000001", 10000, 2100, ""},
+		{"issued again", paid("pi_1", 12100), false, false, "Q2026-000001", 10000, 2100, ""},
+		{"next paid", paid("pi_2", 6050), false, true, "Q2026-000002", 5000, 1050, ""},
+		{"reverse charge", reverseCharge, false, true, "Q2026-000003", 10000, 0, ExemptionIntraCommunity},
+		{
+			"not paid",
+			&stripe.PaymentIntent{ID: "pi_3", Status: stripe.PaymentIntentStatusProcessing, Amount: 12100},
+			true, false, "", 0, 0, "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record, created, err := r.Issue(tt.pi)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Issue() = %+v, want error", record)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Issue() error = %v", err)
+			}
+
+			if created != tt.wantCreated {
+				t.Errorf("Issue() created = %v, want %v", created, tt.wantCreated)
+			}
+
+			if record.Number != tt.wantNumber || record.TaxBase != tt.wantTaxBase || record.TaxAmount != tt.wantTaxAmount || record.Exemption != tt.wantExemption {
+				t.Errorf(
+					"Issue() = %s %d %d %q, want %s %d %d %q",
+					record.Number, record.TaxBase, record.TaxAmount, record.Exemption,
+					tt.wantNumber, tt.wantTaxBase, tt.wantTaxAmount, tt.wantExemption,
+				)
+			}
+		})
+	}
+
+	if records := r.Records(); len(records) != 3 {
+		t.Fatalf("registry has %d records, want 3", len(records))
+	}
+
+	reloaded, err := NewRegistry(r.path, r.issuer)
+
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+
+	if records := reloaded.Records(); len(records) != 3 || records[2].PreviousHash != records[1].Hash {
+		t.Errorf("reloaded registry = %+v, want the 3 chained records", records)
+	}
+}
+
+func TestRegistryIssueCreditNote(t *testing.T) {
+	r := newTestRegistry(t)
+	pi := paid("pi_1", 12100)
+
+	if _, _, err := r.Issue(pi); err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		pi          *stripe.PaymentIntent
+		amount      int64
+		wantErr     bool
+		wantNumber  string
+		wantTaxBase int64
+	}{
+		{"partial refund", pi, 6050, false, "QR2026-000001", -5000},
+		{"more than left", pi, 6051, true, "", 0},
+		{"zero amount", pi, 0, true, "", 0},
+		{"no invoice", paid("pi_2", 12100), 100, true, "", 0},
+		{"rest of the invoice", pi, 6050, false, "QR2026-000002", -5000},
+		{"fully credited", pi, 1, true, "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record, err := r.IssueCreditNote(tt.pi, tt.amount)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("IssueCreditNote() = %+v, want error", record)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("IssueCreditNote() error = %v", err)
+			}
+
+			if record.Type != TypeCreditNote || record.Number != tt.wantNumber || record.Rectified != "Q2026-000001" ||
+				record.TotalAmount != -tt.amount || record.TaxBase != tt.wantTaxBase {
+				t.Errorf("IssueCreditNote() = %+v, want %s rectifying Q2026-000001 by %d", record, tt.wantNumber, tt.amount)
+			}
+		})
+	}
+
+	if credited := r.Credited(pi.ID); credited != 12100 {
+		t.Errorf("Credited() = %d, want 12100", credited)
+	}
+}
+
+func TestRegistryVerify(t *testing.T) {
+	tests := []struct {
+		name       string
+		tamper     func(records []Record)
+		wantIndex  int
+		wantReason string
+	}{
+		{"untouched chain", func(records []Record) {}, -1, ""},
+		{
+			"tampered amount",
+			func(records []Record) { records[1].TotalAmount = 100 },
+			1, "hash does not match the record content",
+		},
+		{
+			"tampered hash",
+			func(records []Record) { records[2].Hash = strings.Repeat("0", 64) },
+			2, "hash does not match the record content",
+		},
+		{
+			"broken link",
+			func(records []Record) { records[1].PreviousHash = records[2].Hash },
+			1, "previous hash does not match the previous record",
+		},
+		{
+			"duplicated number",
+			func(records []Record) { records[2].Number = records[0].Number },
+			2, "duplicated invoice number",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRegistry(t)
+
+			for _, id := range []string{"pi_1", "pi_2", "pi_3"} {
+				if _, _, err := r.Issue(paid(id, 12100)); err != nil {
+					t.Fatalf("Issue() error = %v", err)
+				}
+			}
+
+			tt.tamper(r.records)
+			err := r.Verify()
+
+			if tt.wantIndex < 0 {
+				if err != nil {
+					t.Fatalf("Verify() error = %v, want nil", err)
+				}
+
+				return
+			}
+
+			chainErr, ok := err.(*ChainError)
+
+			if !ok {
+				t.Fatalf("Verify() error = %v, want a *ChainError", err)
+			}
+
+			if chainErr.Index != tt.wantIndex || chainErr.Reason != tt.wantReason {
+				t.Errorf("Verify() = %d %q, want %d %q", chainErr.Index, chainErr.Reason, tt.wantIndex, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestRegistryExportXML(t *testing.T) {
+	r := newTestRegistry(t)
+	reverseCharge := paid("pi_2", 10000)
+	reverseCharge.Metadata[payments.MetadataReverseCharge] = "true"
+	reverseCharge.Metadata[payments.MetadataBuyerName] = "Ktima Oinou AE"
+	reverseCharge.Metadata[payments.MetadataBuyerVATID] = "EL123456789"
+
+	if _, _, err := r.Issue(paid("pi_1", 12100)); err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+
+	if _, _, err := r.Issue(reverseCharge); err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+
+	if _, err := r.IssueCreditNote(paid("pi_1", 12100), 12100); err != nil {
+		t.Fatalf("IssueCreditNote() error = %v", err)
+	}
+
+	var out bytes.Buffer
+
+	if err := r.ExportXML(&out); err != nil {
+		t.Fatalf("ExportXML() error = %v", err)
+	}
+
+	if !strings.HasPrefix(out.String(), xml.Header) {
+		t.Errorf("ExportXML() does not start with the XML header")
+	}
+
+	var submission xmlSubmission
+
+	if err := xml.Unmarshal(out.Bytes(), &submission); err != nil {
+		t.Fatalf("ExportXML() wrote invalid XML: %v", err)
+	}
+
+	if submission.Header.NIF != "B12345674" || len(submission.Records) != 3 {
+		t.Fatalf("ExportXML() = issuer %s with %d records, want B12345674 with 3", submission.Header.NIF, len(submission.Records))
+	}
+
+	records := r.Records()
+	invoice := submission.Records[0].Registration
+	exempt := submission.Records[1].Registration
+	creditNote := submission.Records[2].Registration
+
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"first record", invoice.Chaining.First, "S"},
+		{"invoice number", invoice.ID.Number, "Q2026-000001"},
+		{"invoice issue date", invoice.ID.IssueDate, "01-10-2026"},
+		{"invoice tax rate", invoice.Breakdown.TaxRate, "21.00"},
+		{"invoice tax base", invoice.Breakdown.TaxBase, "100.00"},
+		{"invoice tax amount", invoice.TaxAmount, "21.00"},
+		{"invoice total", invoice.TotalAmount, "121.00"},
+		{"invoice hash", invoice.Hash, records[0].Hash},
+		{"exempt qualification", exempt.Breakdown.Qualification, ""},
+		{"exempt exemption", exempt.Breakdown.Exemption, ExemptionIntraCommunity},
+		{"exempt tax rate", exempt.Breakdown.TaxRate, ""},
+		{"exempt previous number", exempt.Chaining.Previous.Number, "Q2026-000001"},
+		{"exempt previous hash", exempt.Chaining.Previous.Hash, records[0].Hash},
+		{"recipient country", exempt.Recipients.Recipients[0].Country, "GR"},
+		{"recipient ID", exempt.Recipients.Recipients[0].ID, "EL123456789"},
+		{"credit note type", creditNote.Type, TypeCreditNote},
+		{"credit note rectification", creditNote.RectificationType, "I"},
+		{"credit note rectified", creditNote.Rectified.Invoices[0].Number, "Q2026-000001"},
+		{"credit note total", creditNote.TotalAmount, "-121.00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("ExportXML() %s = %q, want %q", tt.name, tt.got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package invoices
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+const xmlNamespace = "https://www2.agenciatributaria.gob.es/static_files/common/internet/dep/aplicaciones/es/aeat/tike/cont/ws/SuministroLR.xsd"
+
+type xmlSubmission struct {
+	XMLName xml.Name    `xml:"RegFactuSistemaFacturacion"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	Header  xmlHeader   `xml:"Cabecera"`
+	Records []xmlRecord `xml:"RegistroFactura"`
+}
+
+type xmlHeader struct {
+	Name string `xml:"ObligadoEmision>NombreRazon"`
+	NIF  string `xml:"ObligadoEmision>NIF"`
+}
+
+type xmlRecord struct {
+	Registration xmlRegistration `xml:"RegistroAlta"`
+}
+
+type xmlInvoiceID struct {
+	IssuerID  string `xml:"IDEmisorFactura"`
+	Number    string `xml:"NumSerieFactura"`
+	IssueDate string `xml:"FechaExpedicionFactura"`
+}
+
+type xmlRegistration struct {
+	Version           string         `xml:"IDVersion"`
+	ID                xmlInvoiceID   `xml:"IDFactura"`
+	IssuerName        string         `xml:"NombreRazonEmisor"`
+	Type              string         `xml:"TipoFactura"`
+	RectificationType string         `xml:"TipoRectificativa,omitempty"`
+	Rectified         *xmlRectified  `xml:"FacturasRectificadas,omitempty"`
+	Description       string         `xml:"DescripcionOperacion"`
+	Recipients        *xmlRecipients `xml:"Destinatarios,omitempty"`
+	Breakdown         xmlBreakdown   `xml:"Desglose>DetalleDesglose"`
+	TaxAmount         string         `xml:"CuotaTotal"`
+	TotalAmount       string         `xml:"ImporteTotal"`
+	Chaining          xmlChaining    `xml:"Encadenamiento"`
+	GeneratedAt       string         `xml:"FechaHoraHusoGenRegistro"`
+	HashType          string         `xml:"TipoHuella"`
+	Hash              string         `xml:"Huella"`
+}
+
+type xmlRectified struct {
+	Invoices []xmlInvoiceID `xml:"IDFacturaRectificada"`
+}
+
+type xmlRecipients struct {
+	Recipients []xmlRecipient `xml:"IDDestinatario"`
+}
+
+// xmlRecipient buyer of an invoice identified by a tax ID of another country
+type xmlRecipient struct {
+	Name    string `xml:"NombreRazon"`
+	Country string `xml:"IDOtro>CodigoPais"`
+	IDType  string `xml:"IDOtro>IDType"`
+	ID      string `xml:"IDOtro>ID"`
+}
+
+type xmlBreakdown struct {
+	Regime        string `xml:"ClaveRegimen"`
+	Qualification string `xml:"CalificacionOperacion,omitempty"`
+	Exemption     string `xml:"OperacionExenta,omitempty"`
+	TaxRate       string `xml:"TipoImpositivo,omitempty"`
+	TaxBase       string `xml:"BaseImponibleOimporteNoSujeto"`
+	TaxAmount     string `xml:"CuotaRepercutida,omitempty"`
+}
+
+type xmlChaining struct {
+	First    string           `xml:"PrimerRegistro,omitempty"`
+	Previous *xmlPreviousLink `xml:"RegistroAnterior,omitempty"`
+}
+
+type xmlPreviousLink struct {
+	xmlInvoiceID
+	Hash string `xml:"Huella"`
+}
+
+// vatCountry ISO country code of the prefix of an EU VAT number, Greece uses EL as VAT prefix
+func vatCountry(vatID string) string {
+	if len(vatID) < 2 {
+		return ""
+	}
+
+	if country := vatID[:2]; country != "EL" {
+		return country
+	}
+
+	return "GR"
+}
+
+// ExportXML Write every record as a Verifactu submission document
+func (r *Registry) ExportXML(w io.Writer) error {
+	records := r.Records()
+
+	submission := xmlSubmission{
+		Xmlns: xmlNamespace,
+		Header: xmlHeader{
+			Name: r.issuer.Name,
+			NIF:  r.issuer.NIF,
+		},
+	}
+
+	for i, record := range records {
+		registration := xmlRegistration{
+			Version: "1.0",
+			ID: xmlInvoiceID{
+				IssuerID:  record.IssuerID,
+				Number:    record.Number,
+				IssueDate: record.IssueDate,
+			},
+			IssuerName:  record.IssuerName,
+			Type:        record.Type,
+			Description: "Venta de vino",
+			Breakdown: xmlBreakdown{
+				Regime:        "01",
+				Qualification: "S1",
+				TaxRate:       fmt.Sprintf("%.2f", record.TaxRate),
+				TaxBase:       FormatAmount(record.TaxBase),
+				TaxAmount:     FormatAmount(record.TaxAmount),
+			},
+			TaxAmount:   FormatAmount(record.TaxAmount),
+			TotalAmount: FormatAmount(record.TotalAmount),
+			GeneratedAt: record.GeneratedAt,
+			HashType:    "01",
+			Hash:        record.Hash,
+		}
+
+		// exempt sales have no tax rate nor tax amount, and their EU buyer is identified by its VAT number
+		if record.Exemption != "" {
+			registration.Breakdown.Qualification = ""
+			registration.Breakdown.Exemption = record.Exemption
+			registration.Breakdown.TaxRate = ""
+			registration.Breakdown.TaxAmount = ""
+		}
+
+		if record.BuyerTaxID != "" {
+			registration.Recipients = &xmlRecipients{
+				Recipients: []xmlRecipient{
+					{
+						Name:    record.BuyerName,
+						Country: vatCountry(record.BuyerTaxID),
+						IDType:  "02",
+						ID:      record.BuyerTaxID,
+					},
+				},
+			}
+		}
+
+		if record.Type == TypeCreditNote {
+			registration.Description = "Devolución de venta de vino"
+			registration.RectificationType = "I"
+			registration.Rectified = &xmlRectified{
+				Invoices: []xmlInvoiceID{
+					{
+						IssuerID:  record.IssuerID,
+						Number:    record.Rectified,
+						IssueDate: record.RectifiedDate,
+					},
+				},
+			}
+		}
+
+		if i == 0 {
+			registration.Chaining.First = "S"
+		} else {
+			previous := records[i-1]
+			registration.Chaining.Previous = &xmlPreviousLink{
+				xmlInvoiceID: xmlInvoiceID{
+					IssuerID:  previous.IssuerID,
+					Number:    previous.Number,
+					IssueDate: previous.IssueDate,
+				},
+				Hash: previous.Hash,
+			}
+		}
+
+		submission.Records = append(submission.Records, xmlRecord{Registration: registration})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return fmt.Errorf("invoices: error writing XML export: %v", err)
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+
+	if err := encoder.Encode(submission); err != nil {
+		return fmt.Errorf("invoices: error encoding XML export: %v", err)
+	}
+
+	return nil
+}
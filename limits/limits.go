@@ -0,0 +1,142 @@
+package limits
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/javierlopezdeancos/stipendivm/inventory"
+	"github.com/javierlopezdeancos/stipendivm/orders"
+	"github.com/javierlopezdeancos/stipendivm/wine"
+)
+
+// Limit kinds
+const (
+	PerOrder     = "order"
+	PerCustomer  = "customer"
+	PerHousehold = "household"
+)
+
+// defaultPeriod period customer and household limits count purchases in when the wine sets none
+const defaultPeriod = 365 * 24 * time.Hour
+
+// Rule purchase limits of a wine, zero means no limit
+type Rule struct {
+	MaxPerOrder     int64
+	MaxPerCustomer  int64
+	MaxPerHousehold int64
+	Period          time.Duration
+}
+
+// Violation a wine of the cart over one of its limits
+type Violation struct {
+	Wine      string
+	Limit     string
+	Max       int64
+	Purchased int64
+	Requested int64
+}
+
+// RuleFromMetadata Read the purchase limits of a wine from its metadata
+func RuleFromMetadata(m wine.Metadata) Rule {
+	r := Rule{
+		MaxPerOrder:     parseLimit(m.MaxPerOrder),
+		MaxPerCustomer:  parseLimit(m.MaxPerCustomer),
+		MaxPerHousehold: parseLimit(m.MaxPerHousehold),
+		Period:          defaultPeriod,
+	}
+
+	if days := parseLimit(m.LimitPeriodDays); days > 0 {
+		r.Period = time.Duration(days) * 24 * time.Hour
+	}
+
+	return r
+}
+
+func parseLimit(value string) int64 {
+	limit, err := strconv.ParseInt(value, 10, 64)
+
+	if err != nil || limit < 0 {
+		return 0
+	}
+
+	return limit
+}
+
+// Check Check the cart of a customer against the limits of its wines, given the orders already placed. The lines of
+// the same wine are added up, so splitting a wine in several lines does not get around its limits.
+func Check(items []inventory.Item, rules map[string]Rule, customerID string, household string, history []*orders.Order, now time.Time) []Violation {
+	violations := []Violation{}
+
+	for _, item := range inventory.Merge(items) {
+		rule := rules[item.Parent]
+
+		if rule.MaxPerOrder > 0 && item.Quantity > rule.MaxPerOrder {
+			violations = append(violations, Violation{
+				Wine:      item.Parent,
+				Limit:     PerOrder,
+				Max:       rule.MaxPerOrder,
+				Requested: item.Quantity,
+			})
+
+			continue
+		}
+
+		since := now.Add(-rule.Period)
+
+		if rule.MaxPerCustomer > 0 && customerID != "" {
+			purchased := purchased(history, item.Parent, since, func(o *orders.Order) bool {
+				return o.CustomerID == customerID
+			})
+
+			if purchased+item.Quantity > rule.MaxPerCustomer {
+				violations = append(violations, Violation{
+					Wine:      item.Parent,
+					Limit:     PerCustomer,
+					Max:       rule.MaxPerCustomer,
+					Purchased: purchased,
+					Requested: item.Quantity,
+				})
+
+				continue
+			}
+		}
+
+		if rule.MaxPerHousehold > 0 && household != "" {
+			purchased := purchased(history, item.Parent, since, func(o *orders.Order) bool {
+				return o.Household == household
+			})
+
+			if purchased+item.Quantity > rule.MaxPerHousehold {
+				violations = append(violations, Violation{
+					Wine:      item.Parent,
+					Limit:     PerHousehold,
+					Max:       rule.MaxPerHousehold,
+					Purchased: purchased,
+					Requested: item.Quantity,
+				})
+			}
+		}
+	}
+
+	return violations
+}
+
+// purchased bottles of a wine in the orders placed since a date that match, every order but the canceled and failed
+// ones counts, as the ones still being paid or invoiced may be paid later
+func purchased(history []*orders.Order, wineID string, since time.Time, match func(o *orders.Order) bool) int64 {
+	total := int64(0)
+
+	for _, o := range history {
+		if o.Status == orders.StatusCanceled || o.Status == orders.StatusFailed || o.CreatedAt.Before(since) || !match(o) {
+			continue
+		}
+
+		for _, item := range o.Items {
+			if item.Parent == wineID {
+				total += item.Quantity
+			}
+		}
+	}
+
+	return total
+}
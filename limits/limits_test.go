@@ -0,0 +1,113 @@
+package limits
+
+import (
+	"testing"
+	"time"
+
+	"github.com/javierlopezdeancos/stipendivm/inventory"
+	"github.com/javierlopezdeancos/stipendivm/orders"
+	"github.com/javierlopezdeancos/stipendivm/wine"
+)
+
+func TestRuleFromMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata wine.Metadata
+		want     Rule
+	}{
+		{"no limits", wine.Metadata{}, Rule{Period: defaultPeriod}},
+		{
+			"every limit",
+			wine.Metadata{MaxPerOrder: "6", MaxPerCustomer: "12", MaxPerHousehold: "18", LimitPeriodDays: "30"},
+			Rule{MaxPerOrder: 6, MaxPerCustomer: 12, MaxPerHousehold: 18, Period: 30 * 24 * time.Hour},
+		},
+		{
+			"invalid and negative limits",
+			wine.Metadata{MaxPerOrder: "six", MaxPerCustomer: "-1", LimitPeriodDays: "0"},
+			Rule{Period: defaultPeriod},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RuleFromMetadata(tt.metadata); got != tt.want {
+				t.Errorf("RuleFromMetadata() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	now := time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC)
+	rules := map[string]Rule{
+		"prod_order":     {MaxPerOrder: 6, Period: defaultPeriod},
+		"prod_customer":  {MaxPerCustomer: 6, Period: 30 * 24 * time.Hour},
+		"prod_household": {MaxPerHousehold: 6, Period: defaultPeriod},
+	}
+
+	order := func(customerID string, household string, status orders.Status, age time.Duration, items ...inventory.Item) *orders.Order {
+		return &orders.Order{CustomerID: customerID, Household: household, Status: status, Items: items, CreatedAt: now.Add(-age)}
+	}
+
+	history := []*orders.Order{
+		order("cus_1", "h_1", orders.StatusPaid, 24*time.Hour, inventory.Item{Parent: "prod_customer", Quantity: 3}),
+		order("cus_1", "h_1", orders.StatusPending, time.Hour, inventory.Item{Parent: "prod_customer", Quantity: 1}),
+		order("cus_1", "h_1", orders.StatusCanceled, time.Hour, inventory.Item{Parent: "prod_customer", Quantity: 6}),
+		order("cus_1", "h_1", orders.StatusFailed, time.Hour, inventory.Item{Parent: "prod_customer", Quantity: 6}),
+		order("cus_1", "h_1", orders.StatusPaid, 60*24*time.Hour, inventory.Item{Parent: "prod_customer", Quantity: 6}),
+		order("cus_2", "h_2", orders.StatusPaid, 24*time.Hour, inventory.Item{Parent: "prod_household", Quantity: 4}),
+	}
+
+	tests := []struct {
+		name       string
+		items      []inventory.Item
+		customerID string
+		household  string
+		want       []Violation
+	}{
+		{"under every limit", []inventory.Item{{Parent: "prod_order", Quantity: 6}, {Parent: "prod_other", Quantity: 24}}, "cus_1", "h_1", nil},
+		{
+			"over the order limit",
+			[]inventory.Item{{Parent: "prod_order", Quantity: 7}},
+			"cus_1", "h_1",
+			[]Violation{{Wine: "prod_order", Limit: PerOrder, Max: 6, Requested: 7}},
+		},
+		{
+			"order limit split in lines",
+			[]inventory.Item{{Parent: "prod_order", Quantity: 4}, {Parent: "prod_order", Quantity: 3}},
+			"cus_1", "h_1",
+			[]Violation{{Wine: "prod_order", Limit: PerOrder, Max: 6, Requested: 7}},
+		},
+		{"customer limit left", []inventory.Item{{Parent: "prod_customer", Quantity: 2}}, "cus_1", "h_1", nil},
+		{
+			"over the customer limit",
+			[]inventory.Item{{Parent: "prod_customer", Quantity: 3}},
+			"cus_1", "h_1",
+			[]Violation{{Wine: "prod_customer", Limit: PerCustomer, Max: 6, Purchased: 4, Requested: 3}},
+		},
+		{"customer limit of a guest", []inventory.Item{{Parent: "prod_customer", Quantity: 6}}, "", "h_1", nil},
+		{
+			"over the household limit of another customer",
+			[]inventory.Item{{Parent: "prod_household", Quantity: 3}},
+			"cus_3", "h_2",
+			[]Violation{{Wine: "prod_household", Limit: PerHousehold, Max: 6, Purchased: 4, Requested: 3}},
+		},
+		{"household limit without household", []inventory.Item{{Parent: "prod_household", Quantity: 6}}, "cus_2", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Check(tt.items, rules, tt.customerID, tt.household, history, now)
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("Check() = %+v, want %+v", got, tt.want)
+			}
+
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("Check() violation %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package notifications
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/javierlopezdeancos/stipendivm/storage"
+)
+
+// Sent email delivered by the notifier, without its body
+type Sent struct {
+	Kind    Kind      `json:"kind"`
+	To      string    `json:"to"`
+	Subject string    `json:"subject"`
+	OrderID string    `json:"orderId,omitempty"`
+	SentAt  time.Time `json:"sentAt"`
+}
+
+// Log emails sent persisted on disk
+type Log struct {
+	mu   sync.Mutex
+	path string
+	sent []Sent
+	now  func() time.Time
+}
+
+// NewLog Load the emails sent stored in path, an empty log if it does not exist yet
+func NewLog(path string) (*Log, error) {
+	l := &Log{
+		path: path,
+		now:  time.Now,
+	}
+
+	if err := storage.ReadJSON(path, &l.sent); err != nil {
+		return nil, fmt.Errorf("notifications: error loading sent emails: %v", err)
+	}
+
+	return l, nil
+}
+
+// Append Record a sent email
+func (l *Log) Append(m Message, orderID string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sent = append(l.sent, Sent{
+		Kind:    m.Kind,
+		To:      m.To,
+		Subject: m.Subject,
+		OrderID: orderID,
+		SentAt:  l.now().UTC(),
+	})
+
+	if err := l.save(); err != nil {
+		l.sent = l.sent[:len(l.sent)-1]
+		return err
+	}
+
+	return nil
+}
+
+// List Emails sent matching a filter, oldest first, every email when filter is nil
+func (l *Log) List(filter func(s *Sent) bool) []Sent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	list := []Sent{}
+
+	for i := range l.sent {
+		if filter != nil && !filter(&l.sent[i]) {
+			continue
+		}
+
+		list = append(list, l.sent[i])
+	}
+
+	return list
+}
+
+// AnonymizeRecipient Replace an email address by another in every email sent to it, the number of emails changed
+func (l *Log) AnonymizeRecipient(email string, replacement string) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	changed := []int{}
+
+	for i := range l.sent {
+		if l.sent[i].To == email {
+			l.sent[i].To = replacement
+			changed = append(changed, i)
+		}
+	}
+
+	if len(changed) == 0 {
+		return 0, nil
+	}
+
+	if err := l.save(); err != nil {
+		for _, i := range changed {
+			l.sent[i].To = email
+		}
+
+		return 0, err
+	}
+
+	return len(changed), nil
+}
+
+func (l *Log) save() error {
+	if err := storage.WriteJSON(l.path, l.sent); err != nil {
+		return fmt.Errorf("notifications: error saving sent emails: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,241 @@
+package notifications
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Kind transactional email kind
+type Kind string
+
+// Transactional email kinds
+const (
+	OrderConfirmation Kind = "order_confirmation"
+	PaymentFailed     Kind = "payment_failed"
+	Shipment          Kind = "shipment"
+	Refund            Kind = "refund"
+	DisputeAlert      Kind = "dispute_alert"
+	MagicLink         Kind = "magic_link"
+)
+
+// DefaultLocale locale used when the customer one has no templates
+const DefaultLocale = "es"
+
+// Message email ready to be sent
+type Message struct {
+	Kind    Kind
+	From    string
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Sender deliver rendered emails
+type Sender interface {
+	Send(m Message) error
+}
+
+// Data values available to the templates
+type Data struct {
+	Name           string
+	OrderID        string
+	InvoiceNumber  string
+	TaxMention     string
+	Amount         string
+	Reason         string
+	Carrier        string
+	TrackingNumber string
+	Status         string
+	DueBy          string
+	Link           string
+	ValidFor       string
+}
+
+// Notifier render transactional emails and deliver them through its sender
+type Notifier struct {
+	Sender    Sender
+	From      string
+	TeamEmail string
+	Log       *Log
+}
+
+// Default notifier used by the server
+var Default *Notifier
+
+// Notify Render the email of a kind in the customer locale, send it and record it in the log when there is one
+func (n *Notifier) Notify(kind Kind, locale string, to string, data Data) error {
+	m, err := Render(kind, locale, data)
+
+	if err != nil {
+		return err
+	}
+
+	m.From = n.From
+	m.To = to
+
+	if err := n.Sender.Send(m); err != nil {
+		return fmt.Errorf("notifications: error sending %s to %s: %v", kind, to, err)
+	}
+
+	if n.Log != nil {
+		return n.Log.Append(m, data.OrderID)
+	}
+
+	return nil
+}
+
+// NotifyTeam Send an email to the team, in Spanish, when a team email is configured
+func (n *Notifier) NotifyTeam(kind Kind, data Data) error {
+	if n.TeamEmail == "" {
+		return fmt.Errorf("notifications: no team email configured to send %s", kind)
+	}
+
+	return n.Notify(kind, DefaultLocale, n.TeamEmail, data)
+}
+
+// Render Render the email of a kind, falling back to the default locale
+func Render(kind Kind, locale string, data Data) (Message, error) {
+	t, ok := templates[normalizeLocale(locale)][kind]
+
+	if !ok {
+		t, ok = templates[DefaultLocale][kind]
+	}
+
+	if !ok {
+		return Message{}, fmt.Errorf("notifications: no template for %s", kind)
+	}
+
+	subject, err := renderText(t.subject, data)
+
+	if err != nil {
+		return Message{}, fmt.Errorf("notifications: error rendering %s subject: %v", kind, err)
+	}
+
+	text, err := renderText(t.text, data)
+
+	if err != nil {
+		return Message{}, fmt.Errorf("notifications: error rendering %s text: %v", kind, err)
+	}
+
+	html, err := renderHTML(t.html, data)
+
+	if err != nil {
+		return Message{}, fmt.Errorf("notifications: error rendering %s html: %v", kind, err)
+	}
+
+	return Message{
+		Kind:    kind,
+		Subject: subject,
+		Text:    text,
+		HTML:    html,
+	}, nil
+}
+
+// normalizeLocale reduce a locale such as es-ES to its language
+func normalizeLocale(locale string) string {
+	locale = strings.ToLower(strings.TrimSpace(locale))
+
+	if i := strings.IndexAny(locale, "-_"); i >= 0 {
+		locale = locale[:i]
+	}
+
+	return locale
+}
+
+func renderText(source string, data Data) (string, error) {
+	t, err := texttemplate.New("").Parse(source)
+
+	if err != nil {
+		return "", err
+	}
+
+	var b bytes.Buffer
+
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+func renderHTML(source string, data Data) (string, error) {
+	t, err := htmltemplate.New("").Parse(layout)
+
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := t.New("content").Parse(source); err != nil {
+		return "", err
+	}
+
+	var b bytes.Buffer
+
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// Bytes Encode the message as a multipart text and html MIME email
+func (m Message) Bytes() ([]byte, error) {
+	var b bytes.Buffer
+	w := multipart.NewWriter(&b)
+
+	headers := []string{
+		"From: " + m.From,
+		"To: " + m.To,
+		"Subject: " + mime.QEncoding.Encode("utf-8", m.Subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary=" + w.Boundary(),
+	}
+
+	b.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+
+	parts := []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	}
+
+	for _, p := range parts {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", p.contentType)
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+
+		pw, err := w.CreatePart(header)
+
+		if err != nil {
+			return nil, fmt.Errorf("notifications: error encoding message: %v", err)
+		}
+
+		qp := quotedprintable.NewWriter(pw)
+
+		if _, err := qp.Write([]byte(p.body)); err != nil {
+			return nil, fmt.Errorf("notifications: error encoding message: %v", err)
+		}
+
+		if err := qp.Close(); err != nil {
+			return nil, fmt.Errorf("notifications: error encoding message: %v", err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("notifications: error encoding message: %v", err)
+	}
+
+	return b.Bytes(), nil
+}
@@ -0,0 +1,110 @@
+package notifications
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/customer"
+
+	"github.com/javierlopezdeancos/stipendivm/payments"
+)
+
+// Recipient who a payment intent email goes to
+type Recipient struct {
+	Email  string
+	Name   string
+	Locale string
+}
+
+// RetrieveRecipient Get the recipient of a payment intent from its customer, or its receipt email
+func RetrieveRecipient(pi *stripe.PaymentIntent) (Recipient, error) {
+	r := Recipient{
+		Email:  pi.ReceiptEmail,
+		Locale: DefaultLocale,
+	}
+
+	if pi.Customer != nil && pi.Customer.ID != "" {
+		c, err := customer.Get(pi.Customer.ID, nil)
+
+		if err != nil {
+			return r, fmt.Errorf("notifications: error fetching customer %s: %v", pi.Customer.ID, err)
+		}
+
+		r = CustomerRecipient(c)
+
+		if r.Email == "" {
+			r.Email = pi.ReceiptEmail
+		}
+	}
+
+	if r.Email == "" {
+		return r, fmt.Errorf("notifications: payment intent %s has no email to notify", pi.ID)
+	}
+
+	return r, nil
+}
+
+// CustomerRecipient Recipient of the emails sent to a customer, in its preferred locale
+func CustomerRecipient(c *stripe.Customer) Recipient {
+	r := Recipient{
+		Email:  c.Email,
+		Name:   c.Name,
+		Locale: DefaultLocale,
+	}
+
+	if len(c.PreferredLocales) > 0 {
+		r.Locale = c.PreferredLocales[0]
+	}
+
+	if locale := c.Metadata["locale"]; locale != "" {
+		r.Locale = locale
+	}
+
+	return r
+}
+
+// NotifyPaymentIntent Send an email about a payment intent to its customer
+func (n *Notifier) NotifyPaymentIntent(kind Kind, pi *stripe.PaymentIntent, data Data) error {
+	r, err := RetrieveRecipient(pi)
+
+	if err != nil {
+		return err
+	}
+
+	if data.Name == "" {
+		data.Name = r.Name
+	}
+
+	if data.OrderID == "" {
+		data.OrderID = pi.Metadata[payments.MetadataOrderID]
+	}
+
+	if data.OrderID == "" {
+		data.OrderID = pi.ID
+	}
+
+	if kind == PaymentFailed && data.Reason == "" && pi.LastPaymentError != nil {
+		code := string(pi.LastPaymentError.DeclineCode)
+
+		if code == "" {
+			code = string(pi.LastPaymentError.Code)
+		}
+
+		data.Reason = payments.DeclineMessage(code, r.Locale)
+	}
+
+	return n.Notify(kind, r.Locale, r.Email, data)
+}
+
+// FormatAmount Format an amount in cents with its currency
+func FormatAmount(amount int64, currency string) string {
+	sign := ""
+
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	return fmt.Sprintf("%s%d.%02d %s", sign, amount/100, amount%100, strings.ToUpper(currency))
+}
@@ -0,0 +1,95 @@
+package notifications
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/javierlopezdeancos/stipendivm/config"
+)
+
+// SMTPSender send emails through an SMTP server, a local catcher such as MailHog needs no credentials
+type SMTPSender struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+}
+
+// Send Send the message through the SMTP server
+func (s *SMTPSender) Send(m Message) error {
+	body, err := m.Bytes()
+
+	if err != nil {
+		return err
+	}
+
+	from, err := mail.ParseAddress(m.From)
+
+	if err != nil {
+		return fmt.Errorf("notifications: invalid sender address %q: %v", m.From, err)
+	}
+
+	var auth smtp.Auth
+
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+
+	return smtp.SendMail(s.Host+":"+s.Port, auth, from.Address, []string{m.To}, body)
+}
+
+// FileSender write every email as an .eml file in a directory, to read them in development
+type FileSender struct {
+	Directory string
+}
+
+var unsafeFileCharacters = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// Send Write the message to a new file in the directory
+func (s *FileSender) Send(m Message) error {
+	body, err := m.Bytes()
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.Directory, 0755); err != nil {
+		return fmt.Errorf("notifications: error creating directory %s: %v", s.Directory, err)
+	}
+
+	name := fmt.Sprintf(
+		"%s-%s-%s.eml",
+		time.Now().Format("20060102T150405.000000000"),
+		m.Kind,
+		unsafeFileCharacters.ReplaceAllString(m.To, "_"),
+	)
+
+	return ioutil.WriteFile(filepath.Join(s.Directory, name), body, 0644)
+}
+
+// NewNotifier Build the notifier with the sender configured by the mailer settings
+func NewNotifier(m config.Mailer) (*Notifier, error) {
+	var sender Sender
+
+	switch m.Sender {
+	case "smtp":
+		sender = &SMTPSender{
+			Host:     m.SMTPHost,
+			Port:     m.SMTPPort,
+			Username: m.SMTPUsername,
+			Password: m.SMTPPassword,
+		}
+	case "file":
+		sender = &FileSender{Directory: m.Directory}
+	default:
+		return nil, fmt.Errorf("notifications: unknown mail sender %q", m.Sender)
+	}
+
+	return &Notifier{Sender: sender, From: m.From, TeamEmail: m.TeamEmail}, nil
+}
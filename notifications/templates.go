@@ -0,0 +1,175 @@
+package notifications
+
+type template struct {
+	subject string
+	text    string
+	html    string
+}
+
+const layout = `<!DOCTYPE html>
+<html>
+  <body style="font-family: Georgia, serif; color: #3b0b1a; max-width: 600px; margin: 0 auto;">
+    <h1 style="font-weight: normal;">Quantvm</h1>
+    {{template "content" .}}
+  </body>
+</html>
+`
+
+var templates = map[string]map[Kind]template{
+	"es": {
+		OrderConfirmation: {
+			subject: "Hemos recibido tu pedido {{.OrderID}}",
+			text: `Hola {{.Name}},
+
+Gracias por tu compra. Hemos recibido el pago de {{.Amount}} de tu pedido {{.OrderID}}.
+{{if .InvoiceNumber}}Tu factura es la {{.InvoiceNumber}}.
+{{end}}{{if .TaxMention}}{{.TaxMention}}
+{{end}}
+Te avisaremos en cuanto salga de la bodega.
+
+Quantvm`,
+			html: `<p>Hola {{.Name}},</p>
+<p>Gracias por tu compra. Hemos recibido el pago de <strong>{{.Amount}}</strong> de tu pedido <strong>{{.OrderID}}</strong>.</p>
+{{if .InvoiceNumber}}<p>Tu factura es la {{.InvoiceNumber}}.</p>{{end}}
+{{if .TaxMention}}<p>{{.TaxMention}}</p>{{end}}
+<p>Te avisaremos en cuanto salga de la bodega.</p>`,
+		},
+		PaymentFailed: {
+			subject: "No hemos podido cobrar tu pedido {{.OrderID}}",
+			text: `Hola {{.Name}},
+
+El pago de {{.Amount}} de tu pedido {{.OrderID}} no se ha completado.
+{{if .Reason}}Motivo: {{.Reason}}
+{{end}}
+Puedes intentarlo de nuevo con otro método de pago.
+
+Quantvm`,
+			html: `<p>Hola {{.Name}},</p>
+<p>El pago de <strong>{{.Amount}}</strong> de tu pedido <strong>{{.OrderID}}</strong> no se ha completado.</p>
+{{if .Reason}}<p>Motivo: {{.Reason}}</p>{{end}}
+<p>Puedes intentarlo de nuevo con otro método de pago.</p>`,
+		},
+		Shipment: {
+			subject: "Tu pedido {{.OrderID}} está en camino",
+			text: `Hola {{.Name}},
+
+Tu pedido {{.OrderID}} ha salido de la bodega con {{.Carrier}}.
+Número de seguimiento: {{.TrackingNumber}}
+
+Quantvm`,
+			html: `<p>Hola {{.Name}},</p>
+<p>Tu pedido <strong>{{.OrderID}}</strong> ha salido de la bodega con {{.Carrier}}.</p>
+<p>Número de seguimiento: <strong>{{.TrackingNumber}}</strong></p>`,
+		},
+		Refund: {
+			subject: "Reembolso de tu pedido {{.OrderID}}",
+			text: `Hola {{.Name}},
+
+Hemos reembolsado {{.Amount}} de tu pedido {{.OrderID}}. Según tu banco, puede tardar unos días en aparecer.
+
+Quantvm`,
+			html: `<p>Hola {{.Name}},</p>
+<p>Hemos reembolsado <strong>{{.Amount}}</strong> de tu pedido <strong>{{.OrderID}}</strong>. Según tu banco, puede tardar unos días en aparecer.</p>`,
+		},
+		DisputeAlert: {
+			subject: "Disputa {{.Status}} en el pedido {{.OrderID}}",
+			text: `Disputa de {{.Amount}} en el pedido {{.OrderID}} del cliente {{.Name}}.
+
+Motivo: {{.Reason}}
+Estado: {{.Status}}
+{{if .DueBy}}Fecha límite para enviar pruebas: {{.DueBy}}
+{{end}}`,
+			html: `<p>Disputa de <strong>{{.Amount}}</strong> en el pedido <strong>{{.OrderID}}</strong> del cliente {{.Name}}.</p>
+<p>Motivo: {{.Reason}}<br>Estado: {{.Status}}</p>
+{{if .DueBy}}<p>Fecha límite para enviar pruebas: <strong>{{.DueBy}}</strong></p>{{end}}`,
+		},
+		MagicLink: {
+			subject: "Tu enlace para entrar en Quantvm",
+			text: `Hola {{.Name}},
+
+Entra en tu cuenta con este enlace, válido durante {{.ValidFor}} y una sola vez:
+
+{{.Link}}
+
+Si no lo has pedido tú, ignora este correo.
+
+Quantvm`,
+			html: `<p>Hola {{.Name}},</p>
+<p>Entra en tu cuenta con este enlace, válido durante {{.ValidFor}} y una sola vez:</p>
+<p><a href="{{.Link}}">Entrar en Quantvm</a></p>
+<p>Si no lo has pedido tú, ignora este correo.</p>`,
+		},
+	},
+	"en": {
+		OrderConfirmation: {
+			subject: "We have received your order {{.OrderID}}",
+			text: `Hi {{.Name}},
+
+Thank you for your purchase. We have received the payment of {{.Amount}} for your order {{.OrderID}}.
+{{if .InvoiceNumber}}Your invoice number is {{.InvoiceNumber}}.
+{{end}}{{if .TaxMention}}{{.TaxMention}}
+{{end}}
+We will let you know as soon as it leaves the cellar.
+
+Quantvm`,
+			html: `<p>Hi {{.Name}},</p>
+<p>Thank you for your purchase. We have received the payment of <strong>{{.Amount}}</strong> for your order <strong>{{.OrderID}}</strong>.</p>
+{{if .InvoiceNumber}}<p>Your invoice number is {{.InvoiceNumber}}.</p>{{end}}
+{{if .TaxMention}}<p>{{.TaxMention}}</p>{{end}}
+<p>We will let you know as soon as it leaves the cellar.</p>`,
+		},
+		PaymentFailed: {
+			subject: "We could not charge your order {{.OrderID}}",
+			text: `Hi {{.Name}},
+
+The payment of {{.Amount}} for your order {{.OrderID}} did not go through.
+{{if .Reason}}Reason: {{.Reason}}
+{{end}}
+You can try again with another payment method.
+
+Quantvm`,
+			html: `<p>Hi {{.Name}},</p>
+<p>The payment of <strong>{{.Amount}}</strong> for your order <strong>{{.OrderID}}</strong> did not go through.</p>
+{{if .Reason}}<p>Reason: {{.Reason}}</p>{{end}}
+<p>You can try again with another payment method.</p>`,
+		},
+		Shipment: {
+			subject: "Your order {{.OrderID}} is on its way",
+			text: `Hi {{.Name}},
+
+Your order {{.OrderID}} has left the cellar with {{.Carrier}}.
+Tracking number: {{.TrackingNumber}}
+
+Quantvm`,
+			html: `<p>Hi {{.Name}},</p>
+<p>Your order <strong>{{.OrderID}}</strong> has left the cellar with {{.Carrier}}.</p>
+<p>Tracking number: <strong>{{.TrackingNumber}}</strong></p>`,
+		},
+		Refund: {
+			subject: "Refund for your order {{.OrderID}}",
+			text: `Hi {{.Name}},
+
+We have refunded {{.Amount}} for your order {{.OrderID}}. Depending on your bank it may take a few days to show up.
+
+Quantvm`,
+			html: `<p>Hi {{.Name}},</p>
+<p>We have refunded <strong>{{.Amount}}</strong> for your order <strong>{{.OrderID}}</strong>. Depending on your bank it may take a few days to show up.</p>`,
+		},
+		MagicLink: {
+			subject: "Your link to sign in to Quantvm",
+			text: `Hi {{.Name}},
+
+Sign in to your account with this link, valid for {{.ValidFor}} and only once:
+
+{{.Link}}
+
+If you did not ask for it, just ignore this email.
+
+Quantvm`,
+			html: `<p>Hi {{.Name}},</p>
+<p>Sign in to your account with this link, valid for {{.ValidFor}} and only once:</p>
+<p><a href="{{.Link}}">Sign in to Quantvm</a></p>
+<p>If you did not ask for it, just ignore this email.</p>`,
+		},
+	},
+}
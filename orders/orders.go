@@ -0,0 +1,317 @@
+package orders
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/javierlopezdeancos/stipendivm/inventory"
+	"github.com/javierlopezdeancos/stipendivm/storage"
+)
+
+// Status order status
+type Status string
+
+// Order statuses
+const (
+	StatusPending        Status = "pending"
+	StatusRequiresAction Status = "requires_action"
+	StatusProcessing     Status = "processing"
+	StatusPaid           Status = "paid"
+	StatusFailed         Status = "payment_failed"
+	StatusCanceled       Status = "canceled"
+	StatusRefunded       Status = "refunded"
+	StatusDisputed       Status = "disputed"
+	StatusDisputeLost    Status = "dispute_lost"
+	StatusInvoiced       Status = "invoiced"
+)
+
+// Mandate SEPA Direct Debit mandate the customer accepted to pay an order
+type Mandate struct {
+	ID         string    `json:"id,omitempty"`
+	AcceptedAt time.Time `json:"acceptedAt"`
+	IPAddress  string    `json:"ipAddress"`
+	UserAgent  string    `json:"userAgent"`
+}
+
+// Dispute chargeback the customer opened on an order
+type Dispute struct {
+	ID            string    `json:"id"`
+	Reason        string    `json:"reason"`
+	Status        string    `json:"status"`
+	Amount        int64     `json:"amount"`
+	EvidenceDueBy time.Time `json:"evidenceDueBy"`
+	SubmittedAt   time.Time `json:"submittedAt,omitempty"`
+}
+
+// Risk fraud assessment of the checkout that placed an order
+type Risk struct {
+	AssessmentID string `json:"assessmentId"`
+	Score        int    `json:"score"`
+	Decision     string `json:"decision"`
+	Review       bool   `json:"review"`
+}
+
+// Invoice Stripe invoice a trade customer pays an order with on its payment terms
+type Invoice struct {
+	ID        string    `json:"id"`
+	Number    string    `json:"number"`
+	DueDate   time.Time `json:"dueDate"`
+	HostedURL string    `json:"hostedUrl"`
+}
+
+// Order a checkout and the payment intent paying it
+type Order struct {
+	ID              string           `json:"id"`
+	PaymentIntentID string           `json:"paymentIntentId"`
+	CustomerID      string           `json:"customerId"`
+	Household       string           `json:"household,omitempty"`
+	Items           []inventory.Item `json:"items"`
+	Amount          int64            `json:"amount"`
+	Currency        string           `json:"currency"`
+	Status          Status           `json:"status"`
+	Mandate         *Mandate         `json:"mandate,omitempty"`
+	Dispute         *Dispute         `json:"dispute,omitempty"`
+	Risk            *Risk            `json:"risk,omitempty"`
+	Invoice         *Invoice         `json:"invoice,omitempty"`
+	CreatedAt       time.Time        `json:"createdAt"`
+	UpdatedAt       time.Time        `json:"updatedAt"`
+}
+
+// Store orders persisted on disk
+type Store struct {
+	mu     sync.Mutex
+	path   string
+	orders map[string]*Order
+}
+
+// Default store used by the server
+var Default *Store
+
+// NewStore Load the orders stored in path, an empty store if it does not exist yet
+func NewStore(path string) (*Store, error) {
+	s := &Store{
+		path:   path,
+		orders: map[string]*Order{},
+	}
+
+	if err := storage.ReadJSON(path, &s.orders); err != nil {
+		return nil, fmt.Errorf("orders: error loading orders: %v", err)
+	}
+
+	return s, nil
+}
+
+// NewID New random order ID
+func NewID() string {
+	b := make([]byte, 8)
+
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("orders: error generating order ID: %v", err))
+	}
+
+	return "ord_" + hex.EncodeToString(b)
+}
+
+// Create Save a new pending order
+func (s *Store) Create(o Order) (*Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if o.ID == "" {
+		o.ID = NewID()
+	}
+
+	if _, ok := s.orders[o.ID]; ok {
+		return nil, fmt.Errorf("orders: order %s already exists", o.ID)
+	}
+
+	if o.Status == "" {
+		o.Status = StatusPending
+	}
+
+	now := time.Now().UTC()
+	o.CreatedAt = now
+	o.UpdatedAt = now
+
+	s.orders[o.ID] = &o
+
+	if err := s.save(); err != nil {
+		delete(s.orders, o.ID)
+		return nil, err
+	}
+
+	created := o
+
+	return &created, nil
+}
+
+// Get Get an order by ID
+func (s *Store) Get(id string) (*Order, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[id]
+
+	if !ok {
+		return nil, false
+	}
+
+	found := *o
+
+	return &found, true
+}
+
+// FindByPaymentIntent Find the order paid by a payment intent
+func (s *Store) FindByPaymentIntent(paymentIntentID string) (*Order, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, o := range s.orders {
+		if o.PaymentIntentID == paymentIntentID {
+			found := *o
+			return &found, true
+		}
+	}
+
+	return nil, false
+}
+
+// List Orders matching a filter, oldest first, every order when filter is nil
+func (s *Store) List(filter func(o *Order) bool) []*Order {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := []*Order{}
+
+	for _, o := range s.orders {
+		if filter != nil && !filter(o) {
+			continue
+		}
+
+		found := *o
+		list = append(list, &found)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+
+	return list
+}
+
+// Update Apply a change to an order and save it
+func (s *Store) Update(id string, change func(o *Order)) (*Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[id]
+
+	if !ok {
+		return nil, fmt.Errorf("orders: order %s not found", id)
+	}
+
+	previous := *o
+	change(o)
+	o.UpdatedAt = time.Now().UTC()
+
+	if err := s.save(); err != nil {
+		*o = previous
+		return nil, err
+	}
+
+	updated := *o
+
+	return &updated, nil
+}
+
+// UpdateStatus Change the status of an order
+func (s *Store) UpdateStatus(id string, status Status) (*Order, error) {
+	return s.Update(id, func(o *Order) {
+		o.Status = status
+	})
+}
+
+// ReassignCustomer Move the orders of a customer to another one
+func (s *Store) ReassignCustomer(from string, to string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reassigned := []*Order{}
+	now := time.Now().UTC()
+
+	for _, o := range s.orders {
+		if o.CustomerID == from {
+			o.CustomerID = to
+			o.UpdatedAt = now
+			reassigned = append(reassigned, o)
+		}
+	}
+
+	if len(reassigned) == 0 {
+		return nil
+	}
+
+	if err := s.save(); err != nil {
+		for _, o := range reassigned {
+			o.CustomerID = from
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// AnonymizeCustomer Remove the personal data of the orders of a customer, their household and the IP address and
+// user agent of their mandates, the number of orders changed
+func (s *Store) AnonymizeCustomer(customerID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous := map[string]Order{}
+	now := time.Now().UTC()
+
+	for id, o := range s.orders {
+		if o.CustomerID != customerID {
+			continue
+		}
+
+		previous[id] = *o
+
+		if o.Mandate != nil {
+			mandate := *o.Mandate
+			mandate.IPAddress = ""
+			mandate.UserAgent = ""
+			o.Mandate = &mandate
+		}
+
+		o.Household = ""
+		o.UpdatedAt = now
+	}
+
+	if len(previous) == 0 {
+		return 0, nil
+	}
+
+	if err := s.save(); err != nil {
+		for id, o := range previous {
+			*s.orders[id] = o
+		}
+
+		return 0, err
+	}
+
+	return len(previous), nil
+}
+
+func (s *Store) save() error {
+	if err := storage.WriteJSON(s.path, s.orders); err != nil {
+		return fmt.Errorf("orders: error saving orders: %v", err)
+	}
+
+	return nil
+}
@@ -3,18 +3,63 @@ package payments
 import (
 	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/stripe/stripe-go/v72"
 	"github.com/stripe/stripe-go/v72/paymentintent"
+	"github.com/stripe/stripe-go/v72/paymentmethod"
 
 	"github.com/javierlopezdeancos/stipendivm/config"
+	"github.com/javierlopezdeancos/stipendivm/customers"
 	"github.com/javierlopezdeancos/stipendivm/inventory"
+	"github.com/javierlopezdeancos/stipendivm/orders"
+	"github.com/javierlopezdeancos/stipendivm/quotes"
 )
 
+// Payment intent metadata keys owned by the server, every other key is a wine and its quantity
+const (
+	MetadataOrderID        = "orderId"
+	MetadataCarrier        = "carrier"
+	MetadataTrackingNumber = "trackingNumber"
+	MetadataShippedAt      = "shippedAt"
+	MetadataPromoCode      = "promoCode"
+	MetadataShippingOption = "shippingOption"
+	MetadataTaxRate        = "taxRate"
+	MetadataReverseCharge  = "reverseCharge"
+	MetadataBuyerVATID     = "buyerVatId"
+	MetadataBuyerName      = "buyerName"
+)
+
+var reservedMetadata = map[string]bool{
+	MetadataOrderID:        true,
+	MetadataCarrier:        true,
+	MetadataTrackingNumber: true,
+	MetadataShippedAt:      true,
+	MetadataPromoCode:      true,
+	MetadataShippingOption: true,
+	MetadataTaxRate:        true,
+	MetadataReverseCharge:  true,
+	MetadataBuyerVATID:     true,
+	MetadataBuyerName:      true,
+}
+
 // PaymentIntentsStatusData Payment Intent status data type
 type PaymentIntentsStatusData struct {
-	Status           string `json:"status"`
-	LastPaymentError string `json:"last_payment_error,omitempty"`
+	Status           string                    `json:"status"`
+	LastPaymentError string                    `json:"last_payment_error,omitempty"`
+	ErrorCode        string                    `json:"error_code,omitempty"`
+	DeclineCode      string                    `json:"decline_code,omitempty"`
+	NextAction       *PaymentIntentsNextAction `json:"next_action,omitempty"`
+	Amount           int64                     `json:"amount"`
+	Currency         string                    `json:"currency"`
+	OrderID          string                    `json:"order_id,omitempty"`
+}
+
+// PaymentIntentsNextAction Payment Intent next action the customer has to complete
+type PaymentIntentsNextAction struct {
+	Type        string `json:"type"`
+	RedirectURL string `json:"redirect_url,omitempty"`
+	ReturnURL   string `json:"return_url,omitempty"`
 }
 
 // PaymentIntentsStatus Payment Intent status type
@@ -24,45 +69,137 @@ type PaymentIntentsStatus struct {
 
 // IntentCreationRequest Intent creation request
 type IntentCreationRequest struct {
-	Currency   string           `json:"currency"`
-	CustomerID string           `json:"customerId"`
-	Items      []inventory.Item `json:"items"`
+	Currency        string                `json:"currency"`
+	CustomerID      string                `json:"customerId"`
+	Items           []inventory.Item      `json:"items"`
+	ShippingOption  config.ShippingOption `json:"shippingOption"`
+	Destination     quotes.Destination    `json:"destination"`
+	PromoCode       string                `json:"promoCode"`
+	PaymentMethodID string                `json:"paymentMethodId"`
+	// ShippingAddressID address of the customer address book to ship to, its default shipping address when empty
+	ShippingAddressID string `json:"shippingAddressId"`
+	// PayByInvoice trade customers only, the order is invoiced on their payment terms instead of paid at checkout
+	PayByInvoice bool `json:"payByInvoice"`
+	// RequestThreeDSecure set by the server on risky checkouts, never by the client
+	RequestThreeDSecure bool `json:"-"`
+	// OffSession set by the server on charges it makes without the customer, checkouts confirm saved payment
+	// methods on session
+	OffSession bool `json:"-"`
+	// Shipping set by the server from the address book, saved as the payment intent shipping details
+	Shipping *customers.Shipping `json:"-"`
+	// UnitAmount set by the server to the price list of trade customers, the catalog prices when nil
+	UnitAmount quotes.UnitAmountFunc `json:"-"`
+	// Buyer set by the server for businesses with a validated EU VAT number, who may buy under reverse charge
+	Buyer *quotes.Buyer `json:"-"`
+}
+
+// offSessionPaymentMethods saved payment method types that can be charged without the customer
+var offSessionPaymentMethods = map[string]bool{
+	"card":       true,
+	"sepa_debit": true,
 }
 
 // IntentShippingChangeRequest Intent shipping change request
 type IntentShippingChangeRequest struct {
 	Items          []inventory.Item      `json:"items"`
 	ShippingOption config.ShippingOption `json:"shippingOption"`
+	Destination    quotes.Destination    `json:"destination"`
+	PromoCode      string                `json:"promoCode"`
+	// UnitAmount set by the server to the price list of trade customers, the catalog prices when nil
+	UnitAmount quotes.UnitAmountFunc `json:"-"`
+	// Buyer set by the server for businesses with a validated EU VAT number, who may buy under reverse charge
+	Buyer *quotes.Buyer `json:"-"`
+}
+
+// IntentShipmentRequest Intent shipment request
+type IntentShipmentRequest struct {
+	Carrier        string `json:"carrier"`
+	TrackingNumber string `json:"trackingNumber"`
+}
+
+// IntentConfirmationRequest Intent confirmation request
+type IntentConfirmationRequest struct {
+	PaymentMethodID string `json:"paymentMethodId"`
+	ReturnURL       string `json:"returnUrl"`
+	MandateAccepted bool   `json:"mandateAccepted"`
+}
+
+// MandateAcceptance Where and when the customer accepted a direct debit mandate
+type MandateAcceptance struct {
+	IPAddress string
+	UserAgent string
 }
 
 // IntentCurrencyPaymentMethodsChangeRequest Intent currency payment methods change request
 type IntentCurrencyPaymentMethodsChangeRequest struct {
 	Currency       string   `json:"currency"`
+	Country        string   `json:"country"`
 	PaymentMethods []string `json:"payment_methods"`
+	// UnitAmount set by the server to the price list of trade customers, the catalog prices when nil
+	UnitAmount quotes.UnitAmountFunc `json:"-"`
+	// Buyer set by the server for businesses with a validated EU VAT number, who may buy under reverse charge
+	Buyer *quotes.Buyer `json:"-"`
 }
 
 // CreateIntent Create intent
 func CreateIntent(icr *IntentCreationRequest) (*stripe.PaymentIntent, error) {
-	amount, err := inventory.CalculatePaymentAmount(icr.Items)
+	q, err := quotes.Calculate(&quotes.Request{
+		Currency:       icr.Currency,
+		Items:          icr.Items,
+		ShippingOption: icr.ShippingOption,
+		Destination:    icr.Destination,
+		PromoCode:      icr.PromoCode,
+		Buyer:          icr.Buyer,
+	}, unitAmountOrCatalog(icr.UnitAmount))
 
 	if err != nil {
 		return nil, fmt.Errorf("payments: error computing payment amount: %v", err)
 	}
 
-	// build initial payment methods which should exclude currency specific ones
-	initPaymentMethods := config.GetPaymentMethods()
-	removeVal(initPaymentMethods, "au_becs_debit")
+	paymentMethods := config.GetCompatiblePaymentMethods(icr.Currency, icr.Destination.Country)
+
+	if err := config.ValidatePaymentMethods(paymentMethods, icr.Currency, icr.Destination.Country); err != nil {
+		return nil, err
+	}
 
 	params := &stripe.PaymentIntentParams{
-		Amount:             stripe.Int64(amount),
+		Amount:             stripe.Int64(q.Total),
 		Currency:           stripe.String(icr.Currency),
-		PaymentMethodTypes: stripe.StringSlice(initPaymentMethods),
+		PaymentMethodTypes: stripe.StringSlice(paymentMethods),
 		Customer:           stripe.String(icr.CustomerID),
 	}
 
-	for _, i := range icr.Items {
-		quantity := strconv.FormatInt(i.Quantity, 10)
-		params.AddMetadata(i.Parent, quantity)
+	if icr.Shipping != nil {
+		params.Shipping = customers.ShippingDetailsParams(*icr.Shipping)
+	}
+
+	if icr.RequestThreeDSecure {
+		params.PaymentMethodOptions = &stripe.PaymentIntentPaymentMethodOptionsParams{
+			Card: &stripe.PaymentIntentPaymentMethodOptionsCardParams{
+				RequestThreeDSecure: stripe.String(string(stripe.PaymentIntentPaymentMethodOptionsCardRequestThreeDSecureAny)),
+			},
+		}
+	}
+
+	orderID := orders.NewID()
+	AddOrderMetadata(&params.Params, orderID, icr.Items, q, icr.ShippingOption.ID)
+
+	if icr.PaymentMethodID != "" {
+		pm, err := customers.RetrievePaymentMethod(icr.CustomerID, icr.PaymentMethodID)
+
+		if err != nil {
+			return nil, fmt.Errorf("payments: error using saved payment method: %v", err)
+		}
+
+		paymentMethodType := string(pm.Type)
+
+		if err := config.ValidatePaymentMethods([]string{paymentMethodType}, icr.Currency, icr.Destination.Country); err != nil {
+			return nil, err
+		}
+
+		// it is only charged with ConfirmSavedIntent once the wines of the checkout are reserved
+		params.PaymentMethod = stripe.String(pm.ID)
+		params.PaymentMethodTypes = stripe.StringSlice([]string{paymentMethodType})
 	}
 
 	pi, err := paymentintent.New(params)
@@ -71,18 +208,57 @@ func CreateIntent(icr *IntentCreationRequest) (*stripe.PaymentIntent, error) {
 		return nil, fmt.Errorf("payments: error creating payment intent: %v", err)
 	}
 
+	_, err = orders.Default.Create(orders.Order{
+		ID:              orderID,
+		PaymentIntentID: pi.ID,
+		CustomerID:      icr.CustomerID,
+		Items:           icr.Items,
+		Amount:          q.Total,
+		Currency:        icr.Currency,
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("payments: error creating order of payment intent %s: %v", pi.ID, err)
+	}
+
 	return pi, nil
 }
 
-// helper function to remove a value from a slice
-func removeVal(slice []string, value string) []string {
-	for i, other := range slice {
-		if other == value {
-			return append(slice[:i], slice[i+1:]...)
-		}
+// AddOrderMetadata keep in the payment intent the order it pays, its wines and how its amount was calculated
+func AddOrderMetadata(params *stripe.Params, orderID string, items []inventory.Item, q *quotes.Quote, shippingOptionID string) {
+	for _, i := range items {
+		params.AddMetadata(i.Parent, strconv.FormatInt(i.Quantity, 10))
+	}
+
+	addQuoteMetadata(params, q, shippingOptionID)
+	params.AddMetadata(MetadataOrderID, orderID)
+}
+
+// unitAmountOrCatalog the price list of a request, the catalog prices when it has none
+func unitAmountOrCatalog(unitAmount quotes.UnitAmountFunc) quotes.UnitAmountFunc {
+	if unitAmount == nil {
+		return inventory.UnitAmount
 	}
 
-	return slice
+	return unitAmount
+}
+
+// addQuoteMetadata keep in the payment intent how its amount was calculated, and the business buying it under
+// reverse charge, an empty value unsets a buyer no longer buying under reverse charge
+func addQuoteMetadata(params *stripe.Params, q *quotes.Quote, shippingOptionID string) {
+	params.AddMetadata(MetadataPromoCode, q.PromoCode)
+	params.AddMetadata(MetadataShippingOption, shippingOptionID)
+	params.AddMetadata(MetadataTaxRate, strconv.FormatFloat(q.TaxRate, 'f', -1, 64))
+
+	reverseCharge, buyer := "", &quotes.Buyer{}
+
+	if q.ReverseCharge {
+		reverseCharge, buyer = "true", q.Buyer
+	}
+
+	params.AddMetadata(MetadataReverseCharge, reverseCharge)
+	params.AddMetadata(MetadataBuyerVATID, buyer.VATID)
+	params.AddMetadata(MetadataBuyerName, buyer.Name)
 }
 
 // RetrieveIntent Retrieve intent
@@ -96,29 +272,133 @@ func RetrieveIntent(paymentIntent string) (*stripe.PaymentIntent, error) {
 	return pi, nil
 }
 
-// ConfirmIntent Confirm intent
-func ConfirmIntent(paymentIntent string, source *stripe.Source) error {
+// redirectPaymentMethods payment method types confirmed by redirecting the customer to their bank
+var redirectPaymentMethods = map[string]bool{
+	"bancontact": true,
+	"eps":        true,
+	"giropay":    true,
+	"ideal":      true,
+	"p24":        true,
+	"sofort":     true,
+}
+
+// mandatePaymentMethods payment method types debiting the customer account under a mandate
+var mandatePaymentMethods = map[string]bool{
+	"sepa_debit": true,
+}
+
+// ConfirmIntent Confirm intent with a payment method, the return URL is where redirect payment methods come back to,
+// and the acceptance is recorded as the mandate of debit payment methods
+func ConfirmIntent(paymentIntent string, r *IntentConfirmationRequest, acceptance MandateAcceptance) (*stripe.PaymentIntent, error) {
 	pi, err := paymentintent.Get(paymentIntent, nil)
 
 	if err != nil {
-		return fmt.Errorf("payments: error fetching payment intent for confirmation: %v", err)
+		return nil, fmt.Errorf("payments: error fetching payment intent for confirmation: %v", err)
+	}
+
+	if pi.Status != stripe.PaymentIntentStatusRequiresPaymentMethod && pi.Status != stripe.PaymentIntentStatusRequiresConfirmation {
+		return nil, fmt.Errorf("payments: PaymentIntent already has a status of %s", pi.Status)
+	}
+
+	pm, err := paymentmethod.Get(r.PaymentMethodID, nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("payments: error fetching payment method for confirmation: %v", err)
+	}
+
+	paymentMethodType := string(pm.Type)
+
+	if !contains(pi.PaymentMethodTypes, paymentMethodType) {
+		return nil, &config.PaymentMethodError{Currency: pi.Currency, PaymentMethods: []string{paymentMethodType}}
 	}
 
-	if pi.Status != "requires_payment_method" {
-		return fmt.Errorf("payments: PaymentIntent already has a status of %s", pi.Status)
+	if redirectPaymentMethods[paymentMethodType] && r.ReturnURL == "" {
+		return nil, fmt.Errorf("payments: a return URL is required to confirm with %s", paymentMethodType)
 	}
 
 	params := &stripe.PaymentIntentConfirmParams{
-		PaymentMethod: stripe.String(source.ID),
+		PaymentMethod: stripe.String(pm.ID),
+	}
+
+	if r.ReturnURL != "" {
+		params.ReturnURL = stripe.String(r.ReturnURL)
+	}
+
+	var mandate *orders.Mandate
+
+	if mandatePaymentMethods[paymentMethodType] {
+		if !r.MandateAccepted {
+			return nil, fmt.Errorf("payments: the customer must accept the mandate to pay with %s", paymentMethodType)
+		}
+
+		mandate = &orders.Mandate{
+			AcceptedAt: time.Now().UTC(),
+			IPAddress:  acceptance.IPAddress,
+			UserAgent:  acceptance.UserAgent,
+		}
+
+		params.MandateData = &stripe.PaymentIntentMandateDataParams{
+			CustomerAcceptance: &stripe.PaymentIntentMandateDataCustomerAcceptanceParams{
+				AcceptedAt: mandate.AcceptedAt.Unix(),
+				Type:       stripe.MandateCustomerAcceptanceTypeOnline,
+				Online: &stripe.PaymentIntentMandateDataCustomerAcceptanceOnlineParams{
+					IPAddress: stripe.String(mandate.IPAddress),
+					UserAgent: stripe.String(mandate.UserAgent),
+				},
+			},
+		}
 	}
 
-	_, err = paymentintent.Confirm(pi.ID, params)
+	pi, err = paymentintent.Confirm(pi.ID, params)
 
 	if err != nil {
-		return fmt.Errorf("payments: error confirming PaymentIntent: %v", err)
+		return nil, fmt.Errorf("payments: error confirming PaymentIntent: %v", err)
 	}
 
-	return nil
+	if orderID := pi.Metadata[MetadataOrderID]; mandate != nil && orderID != "" {
+		_, err = orders.Default.Update(orderID, func(o *orders.Order) {
+			o.Mandate = mandate
+		})
+
+		if err != nil {
+			return nil, fmt.Errorf("payments: error saving mandate of payment intent %s: %v", pi.ID, err)
+		}
+	}
+
+	return pi, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ConfirmSavedIntent Charge the saved payment method a payment intent was created with, off session only for the
+// charges the server makes without the customer. A confirmation declined or needing authentication still returns the
+// payment intent, the client shows its error or confirms it on session with the customer.
+func ConfirmSavedIntent(pi *stripe.PaymentIntent, offSession bool) (*stripe.PaymentIntent, error) {
+	params := &stripe.PaymentIntentConfirmParams{}
+
+	if offSession && len(pi.PaymentMethodTypes) == 1 && offSessionPaymentMethods[pi.PaymentMethodTypes[0]] {
+		params.OffSession = stripe.Bool(true)
+	}
+
+	confirmed, err := paymentintent.Confirm(pi.ID, params)
+
+	if stripeError, ok := err.(*stripe.Error); ok && stripeError.PaymentIntent != nil {
+		confirmed, err = RetrieveIntent(stripeError.PaymentIntent.ID)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("payments: error confirming PaymentIntent %s with its saved payment method: %v", pi.ID, err)
+	}
+
+	return confirmed, nil
 }
 
 // CancelIntent Cancel indent
@@ -134,43 +414,179 @@ func CancelIntent(paymentIntent string) error {
 
 // UpdateShipping Update shipping
 func UpdateShipping(paymentIntent string, r *IntentShippingChangeRequest) (*stripe.PaymentIntent, error) {
-	amount, err := inventory.CalculatePaymentAmount(r.Items)
-
-	if err != nil {
-		return nil, fmt.Errorf("payments: error computing payment amount: %v", err)
+	if _, ok := config.GetShippingCost(r.ShippingOption.ID); !ok {
+		return nil, fmt.Errorf("payments: no cost found for shipping id %q", r.ShippingOption.ID)
 	}
 
-	shippingCost, ok := config.GetShippingCost(r.ShippingOption.ID)
+	q, err := quotes.Calculate(&quotes.Request{
+		Items:          r.Items,
+		ShippingOption: r.ShippingOption,
+		Destination:    r.Destination,
+		PromoCode:      r.PromoCode,
+		Buyer:          r.Buyer,
+	}, unitAmountOrCatalog(r.UnitAmount))
 
-	if !ok {
-		return nil, fmt.Errorf("payments: no cost found for shipping id %q", r.ShippingOption.ID)
+	if err != nil {
+		return nil, fmt.Errorf("payments: error computing payment amount: %v", err)
 	}
 
-	amount += shippingCost
-
 	params := &stripe.PaymentIntentParams{
-		Amount: stripe.Int64(amount),
+		Amount: stripe.Int64(q.Total),
 	}
 
+	addQuoteMetadata(&params.Params, q, r.ShippingOption.ID)
+
 	pi, err := paymentintent.Update(paymentIntent, params)
 
 	if err != nil {
 		return nil, fmt.Errorf("payments: error updating payment intent: %v", err)
 	}
 
+	if orderID := pi.Metadata[MetadataOrderID]; orderID != "" {
+		_, err = orders.Default.Update(orderID, func(o *orders.Order) {
+			o.Items = r.Items
+			o.Amount = q.Total
+		})
+
+		if err != nil {
+			return nil, fmt.Errorf("payments: error updating order of payment intent %s: %v", pi.ID, err)
+		}
+	}
+
 	return pi, nil
 }
 
-// UpdateCurrencyPaymentMethod Update payment currency
+// UpdateCurrencyPaymentMethod Update payment currency, with the compatible payment methods when none is requested. The
+// wines, shipping and promotion code of the payment intent are quoted again in the new currency, and the order takes
+// its amount and currency.
 func UpdateCurrencyPaymentMethod(paymentIntent string, r *IntentCurrencyPaymentMethodsChangeRequest) (*stripe.PaymentIntent, error) {
 	currency := r.Currency
 	paymentMethods := r.PaymentMethods
 
+	if len(paymentMethods) == 0 {
+		paymentMethods = config.GetCompatiblePaymentMethods(currency, r.Country)
+	}
+
+	if err := config.ValidatePaymentMethods(paymentMethods, currency, r.Country); err != nil {
+		return nil, err
+	}
+
+	current, err := RetrieveIntent(paymentIntent)
+
+	if err != nil {
+		return nil, err
+	}
+
+	destination, ok := ShippingDestination(current)
+
+	if !ok {
+		destination = quotes.Destination{Country: r.Country}
+	}
+
+	shippingOption := config.ShippingOption{ID: current.Metadata[MetadataShippingOption]}
+	items := Items(current)
+
+	q, err := quotes.Calculate(&quotes.Request{
+		Currency:       currency,
+		Items:          items,
+		ShippingOption: shippingOption,
+		Destination:    destination,
+		PromoCode:      current.Metadata[MetadataPromoCode],
+		Buyer:          r.Buyer,
+	}, unitAmountOrCatalog(r.UnitAmount))
+
+	if err != nil {
+		return nil, fmt.Errorf("payments: error computing payment amount: %v", err)
+	}
+
 	params := &stripe.PaymentIntentParams{
+		Amount:             stripe.Int64(q.Total),
 		Currency:           stripe.String(currency),
 		PaymentMethodTypes: stripe.StringSlice(paymentMethods),
 	}
 
+	addQuoteMetadata(&params.Params, q, shippingOption.ID)
+
+	pi, err := paymentintent.Update(paymentIntent, params)
+
+	if err != nil {
+		return nil, fmt.Errorf("payments: error updating payment intent: %v", err)
+	}
+
+	if orderID := pi.Metadata[MetadataOrderID]; orderID != "" {
+		_, err = orders.Default.Update(orderID, func(o *orders.Order) {
+			o.Amount = q.Total
+			o.Currency = currency
+		})
+
+		if err != nil {
+			return nil, fmt.Errorf("payments: error updating order of payment intent %s: %v", pi.ID, err)
+		}
+	}
+
+	return pi, nil
+}
+
+// ShippingDestination Destination of the shipping address of a payment intent, false when it has none
+func ShippingDestination(pi *stripe.PaymentIntent) (quotes.Destination, bool) {
+	if pi.Shipping.Address == nil || pi.Shipping.Address.Country == "" {
+		return quotes.Destination{}, false
+	}
+
+	return quotes.Destination{Country: pi.Shipping.Address.Country, PostalCode: pi.Shipping.Address.PostalCode}, true
+}
+
+// invoiced whether a payment intent pays an order invoiced to a trade customer
+func invoiced(paymentIntentID string) bool {
+	order, ok := orders.Default.FindByPaymentIntent(paymentIntentID)
+
+	return ok && order.Invoice != nil
+}
+
+// Items Wines and quantities stored in the payment intent metadata
+func Items(pi *stripe.PaymentIntent) []inventory.Item {
+	items := []inventory.Item{}
+
+	for key, value := range pi.Metadata {
+		if reservedMetadata[key] {
+			continue
+		}
+
+		quantity, err := strconv.ParseInt(value, 10, 64)
+
+		if err != nil {
+			continue
+		}
+
+		items = append(items, inventory.Item{Parent: key, Quantity: quantity})
+	}
+
+	return items
+}
+
+// UpdateShipment Record the carrier and tracking number the order was shipped with
+func UpdateShipment(paymentIntent string, r *IntentShipmentRequest) (*stripe.PaymentIntent, error) {
+	if r.Carrier == "" || r.TrackingNumber == "" {
+		return nil, fmt.Errorf("payments: carrier and tracking number are required")
+	}
+
+	current, err := RetrieveIntent(paymentIntent)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// debits keep processing for days, the wines stay reserved in the cellar until the funds settle, orders invoiced
+	// to trade customers are shipped before they pay on their terms
+	if current.Status != stripe.PaymentIntentStatusSucceeded && !invoiced(current.ID) {
+		return nil, fmt.Errorf("payments: PaymentIntent %s is %s, it can not be shipped until paid", current.ID, current.Status)
+	}
+
+	params := &stripe.PaymentIntentParams{}
+	params.AddMetadata(MetadataCarrier, r.Carrier)
+	params.AddMetadata(MetadataTrackingNumber, r.TrackingNumber)
+	params.AddMetadata(MetadataShippedAt, time.Now().UTC().Format("2006-01-02"))
+
 	pi, err := paymentintent.Update(paymentIntent, params)
 
 	if err != nil {
@@ -0,0 +1,202 @@
+package payments
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"github.com/javierlopezdeancos/stipendivm/config"
+	"github.com/javierlopezdeancos/stipendivm/inventory"
+	"github.com/javierlopezdeancos/stipendivm/orders"
+	"github.com/javierlopezdeancos/stipendivm/stripetest"
+)
+
+// openTestOrders empty order store in a temporary directory, used as the default one
+func openTestOrders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "payments")
+
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %v", err)
+	}
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	if orders.Default, err = orders.NewStore(filepath.Join(dir, "orders.json")); err != nil {
+		t.Fatalf("orders.NewStore() error = %v", err)
+	}
+}
+
+// tradePrices price list of a trade customer
+func tradePrices(wineID string) (int64, error) {
+	return map[string]int64{"prod_1": 1000, "prod_2": 2000}[wineID], nil
+}
+
+func TestItems(t *testing.T) {
+	pi := &stripe.PaymentIntent{
+		Metadata: map[string]string{
+			"prod_1":               "2",
+			"prod_2":               "1",
+			"prod_3":               "many",
+			MetadataOrderID:        "ord_1",
+			MetadataShippingOption: "express",
+			MetadataTaxRate:        "21",
+		},
+	}
+
+	want := []inventory.Item{{Parent: "prod_1", Quantity: 2}, {Parent: "prod_2", Quantity: 1}}
+
+	if got := Items(pi); !inventory.SameItems(got, want) || len(got) != len(want) {
+		t.Errorf("Items() = %+v, want %+v", got, want)
+	}
+}
+
+func TestUpdateCurrencyPaymentMethod(t *testing.T) {
+	metadata := `"prod_1": "2", "prod_2": "1", "orderId": "ord_1", "shippingOption": "express", "promoCode": "", "taxRate": "21"`
+	shipped := `{"id": "pi_1", "currency": "eur", "amount": 4500, "metadata": {` + metadata + `},
+		"shipping": {"name": "Ana García", "address": {"line1": "Calle Mayor 1", "postal_code": "28013", "city": "Madrid", "country": "ES"}}}`
+	guest := `{"id": "pi_1", "currency": "eur", "amount": 4500, "metadata": {` + metadata + `}}`
+
+	tests := []struct {
+		name           string
+		intent         string
+		request        IntentCurrencyPaymentMethodsChangeRequest
+		wantErr        bool
+		wantAmount     string
+		wantTaxRate    string
+		wantMethodType string
+	}{
+		{
+			"catalog prices to the shipping address",
+			shipped,
+			IntentCurrencyPaymentMethodsChangeRequest{Currency: "usd", Country: "ES"},
+			false, "5000", "21", "card",
+		},
+		{
+			"trade prices",
+			shipped,
+			IntentCurrencyPaymentMethodsChangeRequest{Currency: "usd", Country: "ES", UnitAmount: tradePrices},
+			false, "4500", "21", "card",
+		},
+		{
+			"guest outside the EU",
+			guest,
+			IntentCurrencyPaymentMethodsChangeRequest{Currency: "usd", Country: "US"},
+			false, "4132", "0", "card",
+		},
+		{
+			"payment method not enabled",
+			shipped,
+			IntentCurrencyPaymentMethodsChangeRequest{Currency: "usd", Country: "ES", PaymentMethods: []string{"sepa_debit"}},
+			true, "", "", "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestOrders(t)
+			api := stripetest.Start(t)
+
+			api.Handle("GET", "/v1/payment_intents/pi_1", tt.intent)
+			api.Handle("POST", "/v1/payment_intents/pi_1", `{"id": "pi_1", "metadata": {"orderId": "ord_1"}}`)
+			api.Handle("GET", "/v1/prices", `{"object": "list", "url": "/v1/prices", "has_more": false, "data": [
+				{"id": "price_1", "object": "price", "unit_amount": 1500, "currency": "eur"}
+			]}`)
+
+			_, err := orders.Default.Create(orders.Order{ID: "ord_1", PaymentIntentID: "pi_1", Amount: 4500, Currency: "eur"})
+
+			if err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+
+			r := tt.request
+			_, err = UpdateCurrencyPaymentMethod("pi_1", &r)
+
+			if tt.wantErr {
+				if _, ok := err.(*config.PaymentMethodError); !ok {
+					t.Fatalf("UpdateCurrencyPaymentMethod() error = %v, want a *config.PaymentMethodError", err)
+				}
+
+				if updates := api.Requests("POST", "/v1/payment_intents/pi_1"); len(updates) != 0 {
+					t.Errorf("UpdateCurrencyPaymentMethod() updated the payment intent on error")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("UpdateCurrencyPaymentMethod() error = %v", err)
+			}
+
+			updates := api.Requests("POST", "/v1/payment_intents/pi_1")
+
+			if len(updates) != 1 {
+				t.Fatalf("payment intent updated %d times, want once", len(updates))
+			}
+
+			form := updates[0].Form
+
+			if form.Get("amount") != tt.wantAmount || form.Get("currency") != "usd" {
+				t.Errorf("payment intent amount = %s %s, want %s usd", form.Get("amount"), form.Get("currency"), tt.wantAmount)
+			}
+
+			if taxRate := form.Get("metadata[" + MetadataTaxRate + "]"); taxRate != tt.wantTaxRate {
+				t.Errorf("payment intent tax rate = %s, want %s", taxRate, tt.wantTaxRate)
+			}
+
+			if methodType := form.Get("payment_method_types[0]"); methodType != tt.wantMethodType {
+				t.Errorf("payment intent payment method type = %s, want %s", methodType, tt.wantMethodType)
+			}
+
+			o, _ := orders.Default.Get("ord_1")
+
+			if amount := form.Get("amount"); o.Currency != "usd" || amount != strconv.FormatInt(o.Amount, 10) {
+				t.Errorf("order amount = %d %s, want %s usd", o.Amount, o.Currency, amount)
+			}
+		})
+	}
+}
+
+func TestConfirmSavedIntent(t *testing.T) {
+	tests := []struct {
+		name           string
+		types          []string
+		offSession     bool
+		wantOffSession string
+	}{
+		{"checkout on session", []string{"card"}, false, ""},
+		{"card off session", []string{"card"}, true, "true"},
+		{"debit off session", []string{"sepa_debit"}, true, "true"},
+		{"method not charged off session", []string{"bancontact"}, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := stripetest.Start(t)
+			api.Handle("POST", "/v1/payment_intents/pi_1/confirm", `{"id": "pi_1", "status": "succeeded"}`)
+
+			confirmed, err := ConfirmSavedIntent(&stripe.PaymentIntent{ID: "pi_1", PaymentMethodTypes: tt.types}, tt.offSession)
+
+			if err != nil {
+				t.Fatalf("ConfirmSavedIntent() error = %v", err)
+			}
+
+			if confirmed.Status != stripe.PaymentIntentStatusSucceeded {
+				t.Errorf("ConfirmSavedIntent() status = %s, want %s", confirmed.Status, stripe.PaymentIntentStatusSucceeded)
+			}
+
+			confirmations := api.Requests("POST", "/v1/payment_intents/pi_1/confirm")
+
+			if len(confirmations) != 1 {
+				t.Fatalf("payment intent confirmed %d times, want once", len(confirmations))
+			}
+
+			if offSession := confirmations[0].Form.Get("off_session"); offSession != tt.wantOffSession {
+				t.Errorf("off_session = %q, want %q", offSession, tt.wantOffSession)
+			}
+		})
+	}
+}
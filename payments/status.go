@@ -0,0 +1,101 @@
+package payments
+
+import (
+	"strings"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// declineMessages customer facing messages by decline or error code and language
+var declineMessages = map[string]map[string]string{
+	"es": {
+		"generic_decline":         "Tu banco ha rechazado el pago. Prueba con otra tarjeta o contacta con tu banco.",
+		"insufficient_funds":      "La tarjeta no tiene saldo suficiente.",
+		"lost_card":               "Tu banco ha rechazado el pago. Prueba con otra tarjeta.",
+		"stolen_card":             "Tu banco ha rechazado el pago. Prueba con otra tarjeta.",
+		"expired_card":            "La tarjeta ha caducado.",
+		"incorrect_cvc":           "El código de seguridad de la tarjeta no es correcto.",
+		"incorrect_number":        "El número de la tarjeta no es correcto.",
+		"processing_error":        "Ha habido un error procesando la tarjeta. Inténtalo de nuevo en unos minutos.",
+		"authentication_required": "Tu banco necesita que confirmes el pago.",
+		"card_velocity_exceeded":  "Has superado el límite de tu tarjeta. Prueba con otra tarjeta o contacta con tu banco.",
+		"do_not_honor":            "Tu banco ha rechazado el pago. Contacta con tu banco para saber el motivo.",
+		"currency_not_supported":  "La tarjeta no admite pagos en esta moneda.",
+		"default":                 "No hemos podido completar el pago. Prueba con otro método de pago.",
+	},
+	"en": {
+		"generic_decline":         "Your bank declined the payment. Try another card or contact your bank.",
+		"insufficient_funds":      "Your card has insufficient funds.",
+		"lost_card":               "Your bank declined the payment. Try another card.",
+		"stolen_card":             "Your bank declined the payment. Try another card.",
+		"expired_card":            "Your card has expired.",
+		"incorrect_cvc":           "Your card's security code is incorrect.",
+		"incorrect_number":        "Your card number is incorrect.",
+		"processing_error":        "An error occurred while processing your card. Try again in a few minutes.",
+		"authentication_required": "Your bank needs you to confirm the payment.",
+		"card_velocity_exceeded":  "You have exceeded your card limit. Try another card or contact your bank.",
+		"do_not_honor":            "Your bank declined the payment. Contact your bank to know why.",
+		"currency_not_supported":  "Your card does not support payments in this currency.",
+		"default":                 "We could not complete the payment. Try another payment method.",
+	},
+}
+
+// DeclineMessage Customer facing message of a decline code, or error code when there is none,
+// in the language of locale, Spanish by default
+func DeclineMessage(code string, locale string) string {
+	language := strings.ToLower(locale)
+
+	if i := strings.IndexAny(language, "-_;,"); i >= 0 {
+		language = language[:i]
+	}
+
+	messages, ok := declineMessages[strings.TrimSpace(language)]
+
+	if !ok {
+		messages = declineMessages["es"]
+	}
+
+	if message, ok := messages[code]; ok {
+		return message
+	}
+
+	return messages["default"]
+}
+
+// Status Status of a payment intent as shown to the customer, with messages in the language of locale
+func Status(pi *stripe.PaymentIntent, locale string) PaymentIntentsStatus {
+	data := PaymentIntentsStatusData{
+		Status:   string(pi.Status),
+		Amount:   pi.Amount,
+		Currency: pi.Currency,
+		OrderID:  pi.Metadata[MetadataOrderID],
+	}
+
+	if pi.LastPaymentError != nil {
+		data.ErrorCode = string(pi.LastPaymentError.Code)
+		data.DeclineCode = string(pi.LastPaymentError.DeclineCode)
+
+		code := data.DeclineCode
+
+		if code == "" {
+			code = data.ErrorCode
+		}
+
+		data.LastPaymentError = DeclineMessage(code, locale)
+	}
+
+	if pi.NextAction != nil {
+		data.NextAction = &PaymentIntentsNextAction{
+			Type: string(pi.NextAction.Type),
+		}
+
+		if pi.NextAction.RedirectToURL != nil {
+			data.NextAction.RedirectURL = pi.NextAction.RedirectToURL.URL
+			data.NextAction.ReturnURL = pi.NextAction.RedirectToURL.ReturnURL
+		}
+	}
+
+	return PaymentIntentsStatus{
+		PaymentIntent: data,
+	}
+}
@@ -0,0 +1,309 @@
+package privacy
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/customer"
+
+	"github.com/javierlopezdeancos/stipendivm/addressbook"
+	"github.com/javierlopezdeancos/stipendivm/config"
+	"github.com/javierlopezdeancos/stipendivm/consents"
+	"github.com/javierlopezdeancos/stipendivm/customers"
+	"github.com/javierlopezdeancos/stipendivm/invoices"
+	"github.com/javierlopezdeancos/stipendivm/notifications"
+	"github.com/javierlopezdeancos/stipendivm/orders"
+	"github.com/javierlopezdeancos/stipendivm/payments"
+	"github.com/javierlopezdeancos/stipendivm/risk"
+)
+
+// MetadataErasedAt customer metadata key with the time its personal data was erased
+const MetadataErasedAt = "erasedAt"
+
+// ErasedEmail recipient left in the emails sent to an erased customer
+const ErasedEmail = "erased"
+
+// DateLayout layout of the retention dates
+const DateLayout = "2006-01-02"
+
+// openStatuses order statuses that still need the customer data to be completed
+var openStatuses = []orders.Status{
+	orders.StatusPending,
+	orders.StatusRequiresAction,
+	orders.StatusProcessing,
+	orders.StatusDisputed,
+	orders.StatusInvoiced,
+}
+
+// Archive every personal data kept about a customer
+type Archive struct {
+	GeneratedAt     time.Time               `json:"generatedAt"`
+	Customer        customers.Customer      `json:"customer"`
+	StripeProfile   *stripe.Customer        `json:"stripeProfile"`
+	PaymentMethods  []*stripe.PaymentMethod `json:"paymentMethods"`
+	Addresses       []addressbook.Entry     `json:"addresses"`
+	Orders          []*orders.Order         `json:"orders"`
+	Invoices        []invoices.Record       `json:"invoices"`
+	Consents        []consents.Record       `json:"consents"`
+	Notifications   []notifications.Sent    `json:"notifications"`
+	RiskAssessments []risk.Assessment       `json:"riskAssessments"`
+}
+
+// Erasure what was anonymized of a customer and what is kept
+type Erasure struct {
+	CustomerID             string    `json:"customerId"`
+	PaymentMethodsDetached int       `json:"paymentMethodsDetached"`
+	Addresses              int       `json:"addresses"`
+	Orders                 int       `json:"orders"`
+	Consents               int       `json:"consents"`
+	Notifications          int       `json:"notifications"`
+	RiskAssessments        int       `json:"riskAssessments"`
+	InvoicesKept           int       `json:"invoicesKept"`
+	InvoicesRetainedUntil  string    `json:"invoicesRetainedUntil,omitempty"`
+	ErasedAt               time.Time `json:"erasedAt"`
+}
+
+// OpenOrdersError a customer that can not be erased yet as some orders still need its data
+type OpenOrdersError struct {
+	CustomerID string
+	Orders     []string
+}
+
+func (e *OpenOrdersError) Error() string {
+	return fmt.Sprintf("privacy: customer %s has open orders %v", e.CustomerID, e.Orders)
+}
+
+// isShipped whether a paid order has left the cellar, its address is needed until it is delivered to the carrier
+func isShipped(o *orders.Order) (bool, error) {
+	pi, err := payments.RetrieveIntent(o.PaymentIntentID)
+
+	if err != nil {
+		return false, err
+	}
+
+	return pi.Metadata[payments.MetadataShippedAt] != "", nil
+}
+
+// Export Gather the Stripe profile, payment methods, address book, orders, invoices, consents, emails sent and risk
+// assessments of a customer
+func Export(customerID string) (*Archive, error) {
+	found, err := customers.Get(customerID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	profile, err := customers.Retrieve(customerID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	paymentMethods, err := customers.ListPaymentMethods(customerID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	history := customerOrders(customerID)
+	orderIDs := map[string]bool{}
+
+	for _, o := range history {
+		orderIDs[o.ID] = true
+	}
+
+	email := customers.NormalizeEmail(profile.Email)
+
+	return &Archive{
+		GeneratedAt:    time.Now().UTC(),
+		Customer:       *found,
+		StripeProfile:  profile,
+		PaymentMethods: paymentMethods,
+		Addresses:      addressbook.Default.List(customerID),
+		Orders:         history,
+		Invoices:       customerInvoices(customerID),
+		Consents:       consents.Default.History(customerID),
+		Notifications: notifications.Default.Log.List(func(s *notifications.Sent) bool {
+			return (email != "" && customers.NormalizeEmail(s.To) == email) || orderIDs[s.OrderID]
+		}),
+		RiskAssessments: risk.Default.List(func(a *risk.Assessment) bool {
+			return a.Attempt.CustomerID == customerID || (email != "" && customers.NormalizeEmail(a.Attempt.Email) == email)
+		}),
+	}, nil
+}
+
+// Erase Anonymize the personal data of a customer in Stripe and in the local stores. Its saved payment methods are
+// detached, its address book removed and its open consents withdrawn. Orders keep their items and amounts and
+// invoices are kept untouched for the legal retention period, as they only reference the customer by its ID.
+func Erase(customerID string) (*Erasure, error) {
+	if _, err := customers.Get(customerID); err != nil {
+		return nil, err
+	}
+
+	history := customerOrders(customerID)
+	open := []string{}
+
+	for _, o := range history {
+		for _, status := range openStatuses {
+			if o.Status == status {
+				open = append(open, o.ID)
+			}
+		}
+
+		if o.Status != orders.StatusPaid {
+			continue
+		}
+
+		shipped, err := isShipped(o)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !shipped {
+			open = append(open, o.ID)
+		}
+	}
+
+	if len(open) > 0 {
+		return nil, &OpenOrdersError{CustomerID: customerID, Orders: open}
+	}
+
+	profile, err := customers.Retrieve(customerID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	erasure := &Erasure{CustomerID: customerID, ErasedAt: now}
+
+	paymentMethods, err := customers.ListPaymentMethods(customerID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pm := range paymentMethods {
+		if _, err := customers.DetachPaymentMethod(customerID, pm.ID); err != nil {
+			return nil, err
+		}
+
+		erasure.PaymentMethodsDetached++
+	}
+
+	if err := anonymizeProfile(profile, now); err != nil {
+		return nil, err
+	}
+
+	for _, c := range consents.Default.Current(customerID) {
+		if !c.Granted {
+			continue
+		}
+
+		if _, err := consents.Default.Record(consents.Record{
+			CustomerID: customerID,
+			Purpose:    c.Purpose,
+			Action:     consents.Withdrawn,
+			Source:     "erasure",
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	if erasure.Addresses, err = addressbook.Default.DeleteCustomer(customerID); err != nil {
+		return nil, err
+	}
+
+	if erasure.Consents, err = consents.Default.AnonymizeCustomer(customerID); err != nil {
+		return nil, err
+	}
+
+	if erasure.Orders, err = orders.Default.AnonymizeCustomer(customerID); err != nil {
+		return nil, err
+	}
+
+	if profile.Email != "" {
+		if erasure.Notifications, err = notifications.Default.Log.AnonymizeRecipient(profile.Email, ErasedEmail); err != nil {
+			return nil, err
+		}
+	}
+
+	if erasure.RiskAssessments, err = risk.Default.AnonymizeCustomer(customerID, profile.Email); err != nil {
+		return nil, err
+	}
+
+	kept := customerInvoices(customerID)
+	erasure.InvoicesKept = len(kept)
+	erasure.InvoicesRetainedUntil = retainedUntil(kept, config.GetInvoiceRetentionYears())
+
+	return erasure, nil
+}
+
+// anonymizeProfile empty the contact details, addresses and metadata of a Stripe customer, the customer is kept as
+// its payments and invoices reference it
+func anonymizeProfile(c *stripe.Customer, now time.Time) error {
+	params := &stripe.CustomerParams{
+		Description: stripe.String(""),
+		Email:       stripe.String(""),
+		Name:        stripe.String(""),
+		Phone:       stripe.String(""),
+	}
+
+	// an empty value unsets the whole address and shipping
+	params.AddExtra("address", "")
+	params.AddExtra("shipping", "")
+
+	for key := range c.Metadata {
+		params.AddMetadata(key, "")
+	}
+
+	params.AddMetadata(MetadataErasedAt, now.Format(time.RFC3339))
+
+	if _, err := customer.Update(c.ID, params); err != nil {
+		return fmt.Errorf("privacy: error anonymizing customer %s: %v", c.ID, err)
+	}
+
+	return nil
+}
+
+func customerOrders(customerID string) []*orders.Order {
+	return orders.Default.List(func(o *orders.Order) bool {
+		return o.CustomerID == customerID
+	})
+}
+
+func customerInvoices(customerID string) []invoices.Record {
+	records := []invoices.Record{}
+
+	for _, r := range invoices.Default.Records() {
+		if r.CustomerID == customerID {
+			records = append(records, r)
+		}
+	}
+
+	return records
+}
+
+// retainedUntil day the last of the invoices can be removed, empty when there are none
+func retainedUntil(records []invoices.Record, years int) string {
+	dates := []time.Time{}
+
+	for _, r := range records {
+		if date, err := time.Parse("02-01-2006", r.IssueDate); err == nil {
+			dates = append(dates, date)
+		}
+	}
+
+	if len(dates) == 0 {
+		return ""
+	}
+
+	sort.Slice(dates, func(i, j int) bool {
+		return dates[i].Before(dates[j])
+	})
+
+	return dates[len(dates)-1].AddDate(years, 0, 0).Format(DateLayout)
+}
@@ -0,0 +1,319 @@
+package privacy
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"github.com/javierlopezdeancos/stipendivm/addressbook"
+	"github.com/javierlopezdeancos/stipendivm/config"
+	"github.com/javierlopezdeancos/stipendivm/consents"
+	"github.com/javierlopezdeancos/stipendivm/customers"
+	"github.com/javierlopezdeancos/stipendivm/inventory"
+	"github.com/javierlopezdeancos/stipendivm/invoices"
+	"github.com/javierlopezdeancos/stipendivm/notifications"
+	"github.com/javierlopezdeancos/stipendivm/orders"
+	"github.com/javierlopezdeancos/stipendivm/payments"
+	"github.com/javierlopezdeancos/stipendivm/risk"
+	"github.com/javierlopezdeancos/stipendivm/stripetest"
+)
+
+const customerJSON = `{"id": "cus_1", "object": "customer", "email": "ana@example.com", "name": "Ana García", "metadata": {"locale": "es"}}`
+
+// openTestStores empty stores in a temporary directory, used as the default ones, the directory is returned
+func openTestStores(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "privacy")
+
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %v", err)
+	}
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	if orders.Default, err = orders.NewStore(filepath.Join(dir, "orders.json")); err != nil {
+		t.Fatalf("orders.NewStore() error = %v", err)
+	}
+
+	if addressbook.Default, err = addressbook.NewBook(filepath.Join(dir, "addresses.json")); err != nil {
+		t.Fatalf("addressbook.NewBook() error = %v", err)
+	}
+
+	if consents.Default, err = consents.NewLedger(filepath.Join(dir, "consents.json"), map[string]string{consents.Marketing: "2026-01"}); err != nil {
+		t.Fatalf("consents.NewLedger() error = %v", err)
+	}
+
+	if risk.Default, err = risk.NewLedger(filepath.Join(dir, "risk.json"), config.Risk{Window: time.Hour, MaxAttempts: 5}); err != nil {
+		t.Fatalf("risk.NewLedger() error = %v", err)
+	}
+
+	invoices.Default, err = invoices.NewRegistry(filepath.Join(dir, "invoices.json"), config.Issuer{NIF: "B12345674", Name: "Bodegas SL", Series: "Q"})
+
+	if err != nil {
+		t.Fatalf("invoices.NewRegistry() error = %v", err)
+	}
+
+	log, err := notifications.NewLog(filepath.Join(dir, "notifications.json"))
+
+	if err != nil {
+		t.Fatalf("notifications.NewLog() error = %v", err)
+	}
+
+	notifications.Default = &notifications.Notifier{Log: log}
+
+	return dir
+}
+
+// createOrder order of the customer paid with a payment intent, in a status
+func createOrder(t *testing.T, paymentIntentID string, status orders.Status) *orders.Order {
+	o, err := orders.Default.Create(orders.Order{
+		PaymentIntentID: paymentIntentID,
+		CustomerID:      "cus_1",
+		Items:           []inventory.Item{{Parent: "prod_1", Quantity: 1}},
+		Amount:          12100,
+		Currency:        "eur",
+		Status:          status,
+	})
+
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	return o
+}
+
+func TestLogRecord(t *testing.T) {
+	dir := openTestStores(t)
+
+	l, err := NewLog(filepath.Join(dir, "requests.json"))
+
+	if err != nil {
+		t.Fatalf("NewLog() error = %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		request     Request
+		err         error
+		wantOutcome string
+	}{
+		{"completed", Request{Type: RequestExport, CustomerID: "cus_1"}, nil, Completed},
+		{"customer not found", Request{Type: RequestErasure, CustomerID: "cus_2"}, &customers.NotFoundError{ID: "cus_2"}, Rejected},
+		{"open orders", Request{Type: RequestErasure, CustomerID: "cus_1"}, &OpenOrdersError{CustomerID: "cus_1", Orders: []string{"ord_1"}}, Rejected},
+		{"Stripe down", Request{Type: RequestErasure, CustomerID: "cus_1"}, errors.New("customers: error fetching customer cus_1"), Failed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorded, err := l.Record(tt.request, tt.err)
+
+			if err != nil {
+				t.Fatalf("Record() error = %v", err)
+			}
+
+			if recorded.ID == "" || recorded.Outcome != tt.wantOutcome || recorded.CompletedAt.IsZero() {
+				t.Errorf("Record() = %+v, want a %s request", recorded, tt.wantOutcome)
+			}
+
+			if tt.err != nil && recorded.Error != tt.err.Error() {
+				t.Errorf("Record() error = %q, want %q", recorded.Error, tt.err.Error())
+			}
+		})
+	}
+
+	reloaded, err := NewLog(l.path)
+
+	if err != nil {
+		t.Fatalf("NewLog() error = %v", err)
+	}
+
+	if requests := reloaded.List("cus_1"); len(requests) != 3 {
+		t.Errorf("List(cus_1) = %+v, want 3 requests", requests)
+	}
+
+	if requests := reloaded.List(""); len(requests) != 4 {
+		t.Errorf("List() = %+v, want 4 requests", requests)
+	}
+}
+
+func TestEraseOpenOrders(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    orders.Status
+		shippedAt string
+		wantOpen  bool
+	}{
+		{"pending", orders.StatusPending, "", true},
+		{"processing", orders.StatusProcessing, "", true},
+		{"invoiced", orders.StatusInvoiced, "", true},
+		{"disputed", orders.StatusDisputed, "", true},
+		{"paid not shipped", orders.StatusPaid, "", true},
+		{"paid and shipped", orders.StatusPaid, "2026-09-01T10:00:00Z", false},
+		{"canceled", orders.StatusCanceled, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestStores(t)
+			api := stripetest.Start(t)
+
+			api.Handle("GET", "/v1/customers/cus_1", customerJSON)
+			api.Handle("GET", "/v1/payment_intents/pi_1", `{"id": "pi_1", "metadata": {"`+payments.MetadataShippedAt+`": "`+tt.shippedAt+`"}}`)
+			api.Handle("GET", "/v1/payment_methods", `{"object": "list", "url": "/v1/payment_methods", "has_more": false, "data": []}`)
+			api.Handle("POST", "/v1/customers/cus_1", customerJSON)
+
+			o := createOrder(t, "pi_1", tt.status)
+			_, err := Erase("cus_1")
+			openOrdersErr, open := err.(*OpenOrdersError)
+
+			if open != tt.wantOpen {
+				t.Fatalf("Erase() error = %v, want open orders %v", err, tt.wantOpen)
+			}
+
+			if open && (len(openOrdersErr.Orders) != 1 || openOrdersErr.Orders[0] != o.ID) {
+				t.Errorf("Erase() open orders = %v, want [%s]", openOrdersErr.Orders, o.ID)
+			}
+
+			if !open && err != nil {
+				t.Fatalf("Erase() error = %v", err)
+			}
+
+			if updates := api.Requests("POST", "/v1/customers/cus_1"); open && len(updates) != 0 {
+				t.Errorf("Erase() anonymized a customer with open orders")
+			}
+		})
+	}
+}
+
+func TestEraseCustomerNotFound(t *testing.T) {
+	openTestStores(t)
+	stripetest.Start(t)
+
+	if _, err := Erase("cus_1"); err == nil {
+		t.Fatalf("Erase() of a customer not found, want error")
+	} else if _, ok := err.(*customers.NotFoundError); !ok {
+		t.Errorf("Erase() error = %v, want a *customers.NotFoundError", err)
+	}
+}
+
+func TestErase(t *testing.T) {
+	openTestStores(t)
+	api := stripetest.Start(t)
+
+	api.Handle("GET", "/v1/customers/cus_1", customerJSON)
+	api.Handle("POST", "/v1/customers/cus_1", `{"id": "cus_1", "object": "customer"}`)
+	api.Handle("GET", "/v1/payment_intents/pi_1", `{"id": "pi_1", "metadata": {"`+payments.MetadataShippedAt+`": "2026-09-01T10:00:00Z"}}`)
+	api.Handle("GET", "/v1/payment_methods", `{"object": "list", "url": "/v1/payment_methods", "has_more": false, "data": [
+		{"id": "pm_1", "object": "payment_method", "type": "card", "customer": "cus_1"}
+	]}`)
+	api.Handle("GET", "/v1/payment_methods/pm_1", `{"id": "pm_1", "object": "payment_method", "type": "card", "customer": "cus_1"}`)
+	api.Handle("POST", "/v1/payment_methods/pm_1/detach", `{"id": "pm_1", "object": "payment_method", "type": "card"}`)
+
+	o := createOrder(t, "pi_1", orders.StatusPaid)
+
+	_, err := addressbook.Default.Add("cus_1", addressbook.Entry{
+		Name:    "Ana García",
+		Address: customers.Address{Street: "Calle Mayor 1", City: "Madrid", PostalCode: "28013", Country: "ES"},
+	})
+
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	if _, err := consents.Default.Record(consents.Record{CustomerID: "cus_1", Purpose: consents.Marketing, Action: consents.Granted, IPAddress: "10.0.0.1"}); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+
+	if err := notifications.Default.Log.Append(notifications.Message{Kind: notifications.OrderConfirmation, To: "ana@example.com"}, o.ID); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	if _, err := risk.Default.Assess(risk.Attempt{IP: "10.0.0.1", CustomerID: "cus_1", Email: "ana@example.com"}); err != nil {
+		t.Fatalf("Assess() error = %v", err)
+	}
+
+	paid := &stripe.PaymentIntent{
+		ID:             "pi_1",
+		Status:         stripe.PaymentIntentStatusSucceeded,
+		Amount:         12100,
+		AmountReceived: 12100,
+		Currency:       "eur",
+		Customer:       &stripe.Customer{ID: "cus_1"},
+		Metadata:       map[string]string{payments.MetadataTaxRate: "21"},
+	}
+
+	if _, _, err := invoices.Default.Issue(paid); err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+
+	erasure, err := Erase("cus_1")
+
+	if err != nil {
+		t.Fatalf("Erase() error = %v", err)
+	}
+
+	want := Erasure{
+		CustomerID:             "cus_1",
+		PaymentMethodsDetached: 1,
+		Addresses:              1,
+		Orders:                 1,
+		Consents:               2,
+		Notifications:          1,
+		RiskAssessments:        1,
+		InvoicesKept:           1,
+	}
+
+	got := *erasure
+	got.ErasedAt = time.Time{}
+	got.InvoicesRetainedUntil = ""
+
+	if got != want {
+		t.Errorf("Erase() = %+v, want %+v", got, want)
+	}
+
+	if erasure.InvoicesRetainedUntil == "" {
+		t.Errorf("Erase() kept invoices without a retention date")
+	}
+
+	if detached := api.Requests("POST", "/v1/payment_methods/pm_1/detach"); len(detached) != 1 {
+		t.Errorf("payment method detached %d times, want once", len(detached))
+	}
+
+	updates := api.Requests("POST", "/v1/customers/cus_1")
+
+	if len(updates) != 1 {
+		t.Fatalf("customer updated %d times, want once", len(updates))
+	}
+
+	form := updates[0].Form
+
+	for _, field := range []string{"email", "name", "phone", "description", "address", "shipping", "metadata[locale]"} {
+		if values, ok := form[field]; !ok || values[0] != "" {
+			t.Errorf("customer %s = %v, want it emptied", field, values)
+		}
+	}
+
+	if form.Get("metadata["+MetadataErasedAt+"]") == "" {
+		t.Errorf("customer anonymized without %s", MetadataErasedAt)
+	}
+
+	if current := consents.Default.Current("cus_1"); len(current) != 1 || current[0].Granted {
+		t.Errorf("Current() = %+v, want the marketing consent withdrawn", current)
+	}
+
+	if entries := addressbook.Default.List("cus_1"); len(entries) != 0 {
+		t.Errorf("address book = %+v, want it empty", entries)
+	}
+
+	if sent := notifications.Default.Log.List(nil); sent[0].To != ErasedEmail {
+		t.Errorf("email sent to %s, want %s", sent[0].To, ErasedEmail)
+	}
+
+	if _, issued := invoices.Default.Find("pi_1"); !issued {
+		t.Errorf("invoice of the erased customer removed")
+	}
+}
@@ -0,0 +1,133 @@
+package privacy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/javierlopezdeancos/stipendivm/customers"
+	"github.com/javierlopezdeancos/stipendivm/storage"
+)
+
+// Request types
+const (
+	RequestExport  = "export"
+	RequestErasure = "erasure"
+)
+
+// Request outcomes
+const (
+	Completed = "completed"
+	Rejected  = "rejected"
+	Failed    = "failed"
+)
+
+// Request data subject request and its outcome
+type Request struct {
+	ID          string    `json:"id"`
+	Type        string    `json:"type"`
+	CustomerID  string    `json:"customerId"`
+	Outcome     string    `json:"outcome"`
+	Error       string    `json:"error,omitempty"`
+	IPAddress   string    `json:"ipAddress,omitempty"`
+	Erasure     *Erasure  `json:"erasure,omitempty"`
+	RequestedAt time.Time `json:"requestedAt"`
+	CompletedAt time.Time `json:"completedAt"`
+}
+
+// Log data subject requests persisted on disk
+type Log struct {
+	mu       sync.Mutex
+	path     string
+	requests []Request
+	now      func() time.Time
+}
+
+// Default log used by the server
+var Default *Log
+
+// NewLog Load the requests stored in path, an empty log if it does not exist yet
+func NewLog(path string) (*Log, error) {
+	l := &Log{
+		path: path,
+		now:  time.Now,
+	}
+
+	if err := storage.ReadJSON(path, &l.requests); err != nil {
+		return nil, fmt.Errorf("privacy: error loading requests: %v", err)
+	}
+
+	return l, nil
+}
+
+// Record Append a request with the outcome of the error it ended with, rejected for a customer not found or with
+// open orders and failed for any other error
+func (l *Log) Record(r Request, err error) (*Request, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	r.ID = newID()
+	r.CompletedAt = l.now().UTC()
+	r.Outcome = Completed
+
+	switch err.(type) {
+	case nil:
+	case *customers.NotFoundError, *OpenOrdersError:
+		r.Outcome = Rejected
+		r.Error = err.Error()
+	default:
+		r.Outcome = Failed
+		r.Error = err.Error()
+	}
+
+	if r.Outcome == Failed {
+		fmt.Printf("🔴 [ERROR] Privacy %s request of customer %s failed: %s\n", r.Type, r.CustomerID, r.Error)
+	} else {
+		fmt.Printf("🔵 [INFO] Privacy %s request of customer %s %s\n", r.Type, r.CustomerID, r.Outcome)
+	}
+
+	l.requests = append(l.requests, r)
+
+	if err := l.save(); err != nil {
+		l.requests = l.requests[:len(l.requests)-1]
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// List Requests of a customer, oldest first, every request when customerID is empty
+func (l *Log) List(customerID string) []Request {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	list := []Request{}
+
+	for _, r := range l.requests {
+		if customerID == "" || r.CustomerID == customerID {
+			list = append(list, r)
+		}
+	}
+
+	return list
+}
+
+func newID() string {
+	b := make([]byte, 8)
+
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("privacy: error generating request ID: %v", err))
+	}
+
+	return "dsr_" + hex.EncodeToString(b)
+}
+
+func (l *Log) save() error {
+	if err := storage.WriteJSON(l.path, l.requests); err != nil {
+		return fmt.Errorf("privacy: error saving requests: %v", err)
+	}
+
+	return nil
+}
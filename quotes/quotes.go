@@ -0,0 +1,178 @@
+package quotes
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/javierlopezdeancos/stipendivm/config"
+	"github.com/javierlopezdeancos/stipendivm/inventory"
+)
+
+// Destination where an order is shipped to
+type Destination struct {
+	Country    string `json:"country"`
+	PostalCode string `json:"postalCode"`
+}
+
+// ReverseChargeMention legal mention of the invoices of intra-EU sales to VAT registered businesses
+const ReverseChargeMention = "Entrega intracomunitaria exenta de IVA, art. 25 Ley 37/1992. Inversión del sujeto pasivo."
+
+// Buyer VAT registered business a cart is sold to, with a validated VAT number of the country of its prefix
+type Buyer struct {
+	Name    string `json:"name"`
+	VATID   string `json:"vatId"`
+	Country string `json:"country"`
+}
+
+// Request Quote request, the same cart the checkout receives
+type Request struct {
+	Currency       string                `json:"currency"`
+	Items          []inventory.Item      `json:"items"`
+	ShippingOption config.ShippingOption `json:"shippingOption"`
+	Destination    Destination           `json:"destination"`
+	PromoCode      string                `json:"promoCode"`
+	// Buyer set by the server for logged in businesses with a validated EU VAT number, never by the client
+	Buyer *Buyer `json:"-"`
+}
+
+// Line Quote line of a wine
+type Line struct {
+	Wine       string `json:"wine"`
+	Quantity   int64  `json:"quantity"`
+	UnitAmount int64  `json:"unitAmount"`
+	Amount     int64  `json:"amount"`
+	Discount   int64  `json:"discount"`
+}
+
+// Quote Order amount breakdown, every amount in cents
+type Quote struct {
+	Currency  string  `json:"currency"`
+	Lines     []Line  `json:"lines"`
+	Subtotal  int64   `json:"subtotal"`
+	Discount  int64   `json:"discount"`
+	PromoCode string  `json:"promoCode,omitempty"`
+	Shipping  int64   `json:"shipping"`
+	TaxRate   float64 `json:"taxRate"`
+	Taxes     int64   `json:"taxes"`
+	Total     int64   `json:"total"`
+	// ReverseCharge the buyer accounts for the VAT of an intra-EU sale, the quote is zero-rated
+	ReverseCharge bool   `json:"reverseCharge"`
+	Buyer         *Buyer `json:"buyer,omitempty"`
+	TaxMention    string `json:"taxMention,omitempty"`
+}
+
+// UnitAmountFunc Get the price of a wine bottle
+type UnitAmountFunc func(wineID string) (int64, error)
+
+// Calculate Calculate the quote of a cart. Prices include Spanish VAT, when the destination
+// is not charged VAT it is taken out of the total instead of being reported as taxes, as it is
+// for EU businesses buying under reverse charge.
+func Calculate(r *Request, unitAmount UnitAmountFunc) (*Quote, error) {
+	q := &Quote{
+		Currency: r.Currency,
+		Lines:    []Line{},
+	}
+
+	promotionCode, err := promotion(r.PromoCode)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range r.Items {
+		if item.Quantity <= 0 {
+			return nil, fmt.Errorf("quotes: wine %s has no bottles selected", item.Parent)
+		}
+
+		price, err := unitAmount(item.Parent)
+
+		if err != nil {
+			return nil, fmt.Errorf("quotes: error getting price of wine %s: %v", item.Parent, err)
+		}
+
+		line := Line{
+			Wine:       item.Parent,
+			Quantity:   item.Quantity,
+			UnitAmount: price,
+			Amount:     price * item.Quantity,
+		}
+
+		line.Discount = int64(math.Round(float64(line.Amount) * promotionCode.PercentOff / 100))
+
+		q.Lines = append(q.Lines, line)
+		q.Subtotal += line.Amount
+		q.Discount += line.Discount
+	}
+
+	if promotionCode.AmountOff > 0 {
+		q.Discount += promotionCode.AmountOff
+
+		if q.Discount > q.Subtotal {
+			q.Discount = q.Subtotal
+		}
+	}
+
+	q.PromoCode = promotionCode.Code
+
+	if r.ShippingOption.ID != "" {
+		shippingCost, ok := config.GetShippingCost(r.ShippingOption.ID)
+
+		if !ok {
+			return nil, fmt.Errorf("quotes: no cost found for shipping id %q", r.ShippingOption.ID)
+		}
+
+		q.Shipping = shippingCost
+	}
+
+	gross := q.Subtotal - q.Discount + q.Shipping
+	q.TaxRate = config.GetTaxRate(r.Destination.Country, r.Destination.PostalCode)
+
+	if q.TaxRate > 0 && IsReverseCharge(r.Buyer, r.Destination) {
+		q.TaxRate = 0
+		q.ReverseCharge = true
+		q.Buyer = r.Buyer
+		q.TaxMention = ReverseChargeMention
+	}
+
+	if q.TaxRate > 0 {
+		q.Taxes = gross - int64(math.Round(float64(gross)*100/(100+q.TaxRate)))
+		q.Total = gross
+	} else {
+		q.Total = int64(math.Round(float64(gross) * 100 / (100 + config.GetVATRate())))
+	}
+
+	return q, nil
+}
+
+// IsReverseCharge whether a sale is intra-EU to a business registered for VAT out of Spain, shipped to an EU country
+// other than Spain
+func IsReverseCharge(b *Buyer, d Destination) bool {
+	if b == nil || b.VATID == "" {
+		return false
+	}
+
+	country := strings.ToUpper(strings.TrimSpace(d.Country))
+
+	return b.Country != "ES" && country != "ES" && config.IsEUCountry(country)
+}
+
+// IsZeroRated whether Spanish VAT is not charged on a sale, shipped out of the EU, to Canarias, Ceuta or Melilla, or
+// under reverse charge
+func IsZeroRated(b *Buyer, d Destination) bool {
+	return config.GetTaxRate(d.Country, d.PostalCode) == 0 || IsReverseCharge(b, d)
+}
+
+func promotion(code string) (config.PromotionCode, error) {
+	if code == "" {
+		return config.PromotionCode{}, nil
+	}
+
+	promotionCode, ok := config.GetPromotionCode(code)
+
+	if !ok {
+		return config.PromotionCode{}, fmt.Errorf("quotes: unknown promotion code %q", code)
+	}
+
+	return promotionCode, nil
+}
@@ -0,0 +1,57 @@
+package quotes
+
+import "testing"
+
+func TestIsReverseCharge(t *testing.T) {
+	business := &Buyer{Name: "Vins de Bordeaux SARL", VATID: "FR40303265045", Country: "FR"}
+
+	tests := []struct {
+		name        string
+		buyer       *Buyer
+		destination Destination
+		want        bool
+	}{
+		{"EU business shipped to its country", business, Destination{Country: "FR", PostalCode: "33000"}, true},
+		{"EU business shipped to another EU country", business, Destination{Country: "de", PostalCode: "10115"}, true},
+		{"EU business shipped to Spain", business, Destination{Country: "ES", PostalCode: "28013"}, false},
+		{"EU business shipped out of the EU", business, Destination{Country: "CH", PostalCode: "1201"}, false},
+		{"Spanish business", &Buyer{Name: "Bodegas SL", VATID: "ESB12345674", Country: "ES"}, Destination{Country: "FR"}, false},
+		{"business without VAT number", &Buyer{Name: "Vins de Bordeaux SARL", Country: "FR"}, Destination{Country: "FR"}, false},
+		{"consumer", nil, Destination{Country: "FR", PostalCode: "33000"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsReverseCharge(tt.buyer, tt.destination); got != tt.want {
+				t.Errorf("IsReverseCharge() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsZeroRated(t *testing.T) {
+	business := &Buyer{Name: "Vins de Bordeaux SARL", VATID: "FR40303265045", Country: "FR"}
+
+	tests := []struct {
+		name        string
+		buyer       *Buyer
+		destination Destination
+		want        bool
+	}{
+		{"consumer in Spain", nil, Destination{Country: "ES", PostalCode: "28013"}, false},
+		{"consumer in the EU", nil, Destination{Country: "FR", PostalCode: "33000"}, false},
+		{"consumer in Canarias", nil, Destination{Country: "ES", PostalCode: "35001"}, true},
+		{"consumer in Ceuta", nil, Destination{Country: "ES", PostalCode: "51001"}, true},
+		{"consumer out of the EU", nil, Destination{Country: "US", PostalCode: "10001"}, true},
+		{"EU business under reverse charge", business, Destination{Country: "FR", PostalCode: "33000"}, true},
+		{"unknown destination", nil, Destination{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsZeroRated(tt.buyer, tt.destination); got != tt.want {
+				t.Errorf("IsZeroRated() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package reconciliation
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"github.com/javierlopezdeancos/stipendivm/invoices"
+	"github.com/javierlopezdeancos/stipendivm/orders"
+)
+
+// DateLayout layout of the report dates, days are UTC as in the Stripe reports
+const DateLayout = "2006-01-02"
+
+// CSV tables
+const (
+	TableDays      = "days"
+	TableUnmatched = "unmatched"
+)
+
+// Transaction balance transaction matched to the payment intent and order it belongs to
+type Transaction struct {
+	Date                 string `json:"date"`
+	BalanceTransactionID string `json:"balanceTransactionId"`
+	Type                 string `json:"type"`
+	PaymentIntentID      string `json:"paymentIntentId,omitempty"`
+	OrderID              string `json:"orderId,omitempty"`
+	Currency             string `json:"currency"`
+	Amount               int64  `json:"amount"`
+	Fee                  int64  `json:"fee"`
+	Net                  int64  `json:"net"`
+}
+
+// Day movements of a day in a currency
+type Day struct {
+	Date     string `json:"date"`
+	Currency string `json:"currency"`
+	Charges  int64  `json:"charges"`
+	Refunds  int64  `json:"refunds"`
+	Disputes int64  `json:"disputes"`
+	Fees     int64  `json:"fees"`
+	Net      int64  `json:"net"`
+	Payouts  int64  `json:"payouts"`
+}
+
+// Report balance transactions and payouts of a date range reconciled with the orders
+type Report struct {
+	From         string        `json:"from"`
+	To           string        `json:"to"`
+	Transactions []Transaction `json:"transactions"`
+	Unmatched    []Transaction `json:"unmatched"`
+	Days         []Day         `json:"days"`
+}
+
+// Reconcile Match the balance transactions created and the payouts arriving between two dates to the orders,
+// to is exclusive
+func Reconcile(source Source, from time.Time, to time.Time, history []*orders.Order) (*Report, error) {
+	transactions, err := source.BalanceTransactions(from, to)
+
+	if err != nil {
+		return nil, err
+	}
+
+	payouts, err := source.Payouts(from, to)
+
+	if err != nil {
+		return nil, err
+	}
+
+	byPaymentIntent := map[string]*orders.Order{}
+
+	for _, o := range history {
+		byPaymentIntent[o.PaymentIntentID] = o
+	}
+
+	r := &Report{
+		From:         from.UTC().Format(DateLayout),
+		To:           to.UTC().Add(-time.Second).Format(DateLayout),
+		Transactions: []Transaction{},
+		Unmatched:    []Transaction{},
+		Days:         []Day{},
+	}
+
+	days := map[string]*Day{}
+
+	day := func(timestamp int64, currency stripe.Currency) *Day {
+		date := time.Unix(timestamp, 0).UTC().Format(DateLayout)
+		key := date + "|" + string(currency)
+
+		if _, ok := days[key]; !ok {
+			days[key] = &Day{Date: date, Currency: string(currency)}
+		}
+
+		return days[key]
+	}
+
+	for _, bt := range transactions {
+		// payouts are reported on the day they arrive, from the payouts themselves
+		if bt.Type == stripe.BalanceTransactionTypePayout {
+			continue
+		}
+
+		t := Transaction{
+			Date:                 time.Unix(bt.Created, 0).UTC().Format(DateLayout),
+			BalanceTransactionID: bt.ID,
+			Type:                 string(bt.Type),
+			PaymentIntentID:      paymentIntentID(bt),
+			Currency:             string(bt.Currency),
+			Amount:               bt.Amount,
+			Fee:                  bt.Fee,
+			Net:                  bt.Net,
+		}
+
+		if o, ok := byPaymentIntent[t.PaymentIntentID]; ok && t.PaymentIntentID != "" {
+			t.OrderID = o.ID
+		}
+
+		r.Transactions = append(r.Transactions, t)
+
+		d := day(bt.Created, bt.Currency)
+		d.Fees += bt.Fee
+		d.Net += bt.Net
+
+		switch bt.Type {
+		case stripe.BalanceTransactionTypeCharge, stripe.BalanceTransactionTypePayment:
+			d.Charges += bt.Amount
+
+			if t.OrderID == "" {
+				r.Unmatched = append(r.Unmatched, t)
+			}
+		case stripe.BalanceTransactionTypeRefund, stripe.BalanceTransactionTypePaymentRefund:
+			d.Refunds += bt.Amount
+		case stripe.BalanceTransactionTypeAdjustment:
+			if bt.Source != nil && bt.Source.Type == stripe.BalanceTransactionSourceTypeDispute {
+				d.Disputes += bt.Amount
+			}
+		}
+	}
+
+	for _, p := range payouts {
+		if p.Status != stripe.PayoutStatusPaid {
+			continue
+		}
+
+		day(p.ArrivalDate, p.Currency).Payouts += p.Amount
+	}
+
+	for _, d := range days {
+		r.Days = append(r.Days, *d)
+	}
+
+	sort.Slice(r.Days, func(i, j int) bool {
+		if r.Days[i].Date != r.Days[j].Date {
+			return r.Days[i].Date < r.Days[j].Date
+		}
+
+		return r.Days[i].Currency < r.Days[j].Currency
+	})
+
+	return r, nil
+}
+
+// paymentIntentID payment intent a balance transaction comes from, empty when its source is not expanded or has none
+func paymentIntentID(bt *stripe.BalanceTransaction) string {
+	s := bt.Source
+
+	if s == nil {
+		return ""
+	}
+
+	switch {
+	case s.Charge != nil && s.Charge.PaymentIntent != nil:
+		return s.Charge.PaymentIntent.ID
+	case s.Refund != nil && s.Refund.PaymentIntent != nil:
+		return s.Refund.PaymentIntent.ID
+	case s.Refund != nil && s.Refund.Charge != nil && s.Refund.Charge.PaymentIntent != nil:
+		return s.Refund.Charge.PaymentIntent.ID
+	case s.Dispute != nil && s.Dispute.PaymentIntent != nil:
+		return s.Dispute.PaymentIntent.ID
+	default:
+		return ""
+	}
+}
+
+// WriteCSV Write a table of the report as CSV, the days table or the unmatched charges one
+func (r *Report) WriteCSV(w io.Writer, table string) error {
+	records := [][]string{}
+
+	switch table {
+	case "", TableDays:
+		records = append(records, []string{"date", "currency", "charges", "refunds", "disputes", "fees", "net", "payouts"})
+
+		for _, d := range r.Days {
+			records = append(records, []string{
+				d.Date,
+				d.Currency,
+				invoices.FormatAmount(d.Charges),
+				invoices.FormatAmount(d.Refunds),
+				invoices.FormatAmount(d.Disputes),
+				invoices.FormatAmount(d.Fees),
+				invoices.FormatAmount(d.Net),
+				invoices.FormatAmount(d.Payouts),
+			})
+		}
+	case TableUnmatched:
+		records = append(records, []string{"date", "balance_transaction", "type", "payment_intent", "currency", "amount", "fee", "net"})
+
+		for _, t := range r.Unmatched {
+			records = append(records, []string{
+				t.Date,
+				t.BalanceTransactionID,
+				t.Type,
+				t.PaymentIntentID,
+				t.Currency,
+				invoices.FormatAmount(t.Amount),
+				invoices.FormatAmount(t.Fee),
+				invoices.FormatAmount(t.Net),
+			})
+		}
+	default:
+		return fmt.Errorf("reconciliation: unknown table %s, use %s or %s", table, TableDays, TableUnmatched)
+	}
+
+	cw := csv.NewWriter(w)
+
+	if err := cw.WriteAll(records); err != nil {
+		return fmt.Errorf("reconciliation: error writing csv: %v", err)
+	}
+
+	return nil
+}
+
+// ParseRange Parse a from and to date, both included, into the range the report covers
+func ParseRange(from string, to string) (time.Time, time.Time, error) {
+	start, err := time.Parse(DateLayout, from)
+
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("reconciliation: invalid from date %q, use %s", from, DateLayout)
+	}
+
+	end, err := time.Parse(DateLayout, to)
+
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("reconciliation: invalid to date %q, use %s", to, DateLayout)
+	}
+
+	if end.Before(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("reconciliation: to date %s is before from date %s", to, from)
+	}
+
+	return start, end.AddDate(0, 0, 1), nil
+}
@@ -0,0 +1,179 @@
+package reconciliation
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/javierlopezdeancos/stipendivm/orders"
+)
+
+func TestReconcileFixture(t *testing.T) {
+	fixture, err := LoadFixture("testdata/fixture.json")
+
+	if err != nil {
+		t.Fatalf("LoadFixture() error = %v", err)
+	}
+
+	history := []*orders.Order{
+		{ID: "order_1", PaymentIntentID: "pi_1JB5ZmDlYJqCrNtiHn2TeYkP"},
+	}
+
+	tests := []struct {
+		name          string
+		from          string
+		to            string
+		wantDays      []Day
+		wantMatched   map[string]string
+		wantUnmatched []string
+		wantDaysCSV   string
+	}{
+		{
+			name: "whole fixture",
+			from: "2026-07-05",
+			to:   "2026-07-07",
+			wantDays: []Day{
+				{Date: "2026-07-05", Currency: "eur", Charges: 6690, Fees: 149, Net: 6541},
+				{Date: "2026-07-06", Currency: "eur", Refunds: -1500, Net: -1500},
+				{Date: "2026-07-07", Currency: "eur", Payouts: 5041},
+			},
+			wantMatched: map[string]string{
+				"txn_1JB5ZpDlYJqCrNtiK9qE4n1a": "order_1",
+				"txn_1JB6AqDlYJqCrNtiU3YpPq0c": "",
+				"txn_1JB7BrDlYJqCrNtiR4s8GhJk": "order_1",
+			},
+			wantUnmatched: []string{"txn_1JB6AqDlYJqCrNtiU3YpPq0c"},
+			wantDaysCSV: "date,currency,charges,refunds,disputes,fees,net,payouts\n" +
+				"2026-07-05,eur,66.90,0.00,0.00,1.49,65.41,0.00\n" +
+				"2026-07-06,eur,0.00,-15.00,0.00,0.00,-15.00,0.00\n" +
+				"2026-07-07,eur,0.00,0.00,0.00,0.00,0.00,50.41\n",
+		},
+		{
+			name: "refund day only",
+			from: "2026-07-06",
+			to:   "2026-07-06",
+			wantDays: []Day{
+				{Date: "2026-07-06", Currency: "eur", Refunds: -1500, Net: -1500},
+			},
+			wantMatched: map[string]string{
+				"txn_1JB7BrDlYJqCrNtiR4s8GhJk": "order_1",
+			},
+			wantDaysCSV: "date,currency,charges,refunds,disputes,fees,net,payouts\n" +
+				"2026-07-06,eur,0.00,-15.00,0.00,0.00,-15.00,0.00\n",
+		},
+		{
+			name:        "no movements",
+			from:        "2026-08-01",
+			to:          "2026-08-31",
+			wantDaysCSV: "date,currency,charges,refunds,disputes,fees,net,payouts\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to, err := ParseRange(tt.from, tt.to)
+
+			if err != nil {
+				t.Fatalf("ParseRange() error = %v", err)
+			}
+
+			report, err := Reconcile(fixture, from, to, history)
+
+			if err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+
+			if report.From != tt.from || report.To != tt.to {
+				t.Errorf("Reconcile() range = %s %s, want %s %s", report.From, report.To, tt.from, tt.to)
+			}
+
+			if len(report.Days) != len(tt.wantDays) {
+				t.Fatalf("Reconcile() days = %+v, want %+v", report.Days, tt.wantDays)
+			}
+
+			for i, d := range report.Days {
+				if d != tt.wantDays[i] {
+					t.Errorf("Reconcile() day %d = %+v, want %+v", i, d, tt.wantDays[i])
+				}
+			}
+
+			if len(report.Transactions) != len(tt.wantMatched) {
+				t.Errorf("Reconcile() has %d transactions, want %d", len(report.Transactions), len(tt.wantMatched))
+			}
+
+			for _, transaction := range report.Transactions {
+				if orderID, ok := tt.wantMatched[transaction.BalanceTransactionID]; !ok || transaction.OrderID != orderID {
+					t.Errorf("Reconcile() matched %s to order %q, want %q", transaction.BalanceTransactionID, transaction.OrderID, orderID)
+				}
+			}
+
+			if len(report.Unmatched) != len(tt.wantUnmatched) {
+				t.Fatalf("Reconcile() unmatched = %+v, want %v", report.Unmatched, tt.wantUnmatched)
+			}
+
+			for i, transaction := range report.Unmatched {
+				if transaction.BalanceTransactionID != tt.wantUnmatched[i] {
+					t.Errorf("Reconcile() unmatched %d = %s, want %s", i, transaction.BalanceTransactionID, tt.wantUnmatched[i])
+				}
+			}
+
+			var days bytes.Buffer
+
+			if err := report.WriteCSV(&days, TableDays); err != nil {
+				t.Fatalf("WriteCSV() error = %v", err)
+			}
+
+			if days.String() != tt.wantDaysCSV {
+				t.Errorf("WriteCSV(%s) = %q, want %q", TableDays, days.String(), tt.wantDaysCSV)
+			}
+		})
+	}
+}
+
+func TestReportWriteCSV(t *testing.T) {
+	report := &Report{
+		Unmatched: []Transaction{
+			{
+				Date:                 "2026-07-05",
+				BalanceTransactionID: "txn_1",
+				Type:                 "charge",
+				PaymentIntentID:      "pi_1",
+				Currency:             "eur",
+				Amount:               2100,
+				Fee:                  57,
+				Net:                  2043,
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		table   string
+		want    string
+		wantErr bool
+	}{
+		{"days by default", "", "date,currency,charges,refunds,disputes,fees,net,payouts\n", false},
+		{
+			"unmatched",
+			TableUnmatched,
+			"date,balance_transaction,type,payment_intent,currency,amount,fee,net\n" +
+				"2026-07-05,txn_1,charge,pi_1,eur,21.00,0.57,20.43\n",
+			false,
+		},
+		{"unknown table", "payouts", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := report.WriteCSV(&out, tt.table)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WriteCSV(%q) error = %v, want error %v", tt.table, err, tt.wantErr)
+			}
+
+			if out.String() != tt.want {
+				t.Errorf("WriteCSV(%q) = %q, want %q", tt.table, out.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package reconciliation
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/balancetransaction"
+	"github.com/stripe/stripe-go/v72/payout"
+)
+
+// Source where the balance transactions and payouts of a date range are read from
+type Source interface {
+	BalanceTransactions(from time.Time, to time.Time) ([]*stripe.BalanceTransaction, error)
+	Payouts(from time.Time, to time.Time) ([]*stripe.Payout, error)
+}
+
+// StripeSource read from the Stripe API, or stripe-mock when the API URL points to it
+type StripeSource struct{}
+
+// BalanceTransactions Balance transactions created in the range, with their source expanded
+func (StripeSource) BalanceTransactions(from time.Time, to time.Time) ([]*stripe.BalanceTransaction, error) {
+	params := &stripe.BalanceTransactionListParams{
+		CreatedRange: &stripe.RangeQueryParams{
+			GreaterThanOrEqual: from.Unix(),
+			LesserThan:         to.Unix(),
+		},
+	}
+
+	params.AddExpand("data.source")
+
+	transactions := []*stripe.BalanceTransaction{}
+	i := balancetransaction.List(params)
+
+	for i.Next() {
+		transactions = append(transactions, i.BalanceTransaction())
+	}
+
+	if err := i.Err(); err != nil {
+		return nil, fmt.Errorf("reconciliation: error listing balance transactions: %v", err)
+	}
+
+	return transactions, nil
+}
+
+// Payouts Payouts arriving in the range
+func (StripeSource) Payouts(from time.Time, to time.Time) ([]*stripe.Payout, error) {
+	params := &stripe.PayoutListParams{
+		ArrivalDateRange: &stripe.RangeQueryParams{
+			GreaterThanOrEqual: from.Unix(),
+			LesserThan:         to.Unix(),
+		},
+	}
+
+	payouts := []*stripe.Payout{}
+	i := payout.List(params)
+
+	for i.Next() {
+		payouts = append(payouts, i.Payout())
+	}
+
+	if err := i.Err(); err != nil {
+		return nil, fmt.Errorf("reconciliation: error listing payouts: %v", err)
+	}
+
+	return payouts, nil
+}
+
+// Fixture balance transactions and payouts recorded from the Stripe API, as returned by it
+type Fixture struct {
+	Transactions []*stripe.BalanceTransaction `json:"balance_transactions"`
+	AllPayouts   []*stripe.Payout             `json:"payouts"`
+}
+
+// LoadFixture Load a recorded fixture file
+func LoadFixture(path string) (*Fixture, error) {
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("reconciliation: error reading fixture: %v", err)
+	}
+
+	f := &Fixture{}
+
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("reconciliation: error decoding fixture: %v", err)
+	}
+
+	return f, nil
+}
+
+// BalanceTransactions Recorded balance transactions created in the range
+func (f *Fixture) BalanceTransactions(from time.Time, to time.Time) ([]*stripe.BalanceTransaction, error) {
+	transactions := []*stripe.BalanceTransaction{}
+
+	for _, t := range f.Transactions {
+		if inRange(t.Created, from, to) {
+			transactions = append(transactions, t)
+		}
+	}
+
+	return transactions, nil
+}
+
+// Payouts Recorded payouts arriving in the range
+func (f *Fixture) Payouts(from time.Time, to time.Time) ([]*stripe.Payout, error) {
+	payouts := []*stripe.Payout{}
+
+	for _, p := range f.AllPayouts {
+		if inRange(p.ArrivalDate, from, to) {
+			payouts = append(payouts, p)
+		}
+	}
+
+	return payouts, nil
+}
+
+func inRange(timestamp int64, from time.Time, to time.Time) bool {
+	return timestamp >= from.Unix() && timestamp < to.Unix()
+}
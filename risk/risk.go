@@ -0,0 +1,332 @@
+package risk
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/javierlopezdeancos/stipendivm/config"
+	"github.com/javierlopezdeancos/stipendivm/storage"
+)
+
+// Decision what to do with a checkout attempt
+type Decision string
+
+// Decisions from the lowest to the highest risk
+const (
+	Allow     Decision = "allow"
+	Review    Decision = "review"
+	Challenge Decision = "challenge"
+	Block     Decision = "block"
+)
+
+// Scores from which each decision is taken
+const (
+	ReviewScore    = 25
+	ChallengeScore = 50
+	BlockScore     = 80
+)
+
+// Signal names
+const (
+	SignalIPVelocity       = "ip_velocity"
+	SignalCustomerVelocity = "customer_velocity"
+	SignalEmailVelocity    = "email_velocity"
+	SignalCountryMismatch  = "country_mismatch"
+	SignalLargeOrder       = "large_order"
+)
+
+// Attempt checkout attempt to score
+type Attempt struct {
+	IP              string `json:"ip"`
+	CustomerID      string `json:"customerId,omitempty"`
+	Email           string `json:"email,omitempty"`
+	BillingCountry  string `json:"billingCountry,omitempty"`
+	ShippingCountry string `json:"shippingCountry,omitempty"`
+	Bottles         int64  `json:"bottles"`
+}
+
+// Signal risk signal an attempt raised and the score it adds
+type Signal struct {
+	Name   string `json:"name"`
+	Score  int    `json:"score"`
+	Detail string `json:"detail"`
+}
+
+// Assessment scored attempt and the decision taken on it
+type Assessment struct {
+	ID              string    `json:"id"`
+	Attempt         Attempt   `json:"attempt"`
+	Score           int       `json:"score"`
+	Decision        Decision  `json:"decision"`
+	Signals         []Signal  `json:"signals"`
+	PaymentIntentID string    `json:"paymentIntentId,omitempty"`
+	OrderID         string    `json:"orderId,omitempty"`
+	CreatedAt       time.Time `json:"createdAt"`
+}
+
+// Ledger assessments persisted on disk, the attempts of its window are the velocity signals source
+type Ledger struct {
+	mu          sync.Mutex
+	path        string
+	limits      config.Risk
+	assessments []Assessment
+	now         func() time.Time
+}
+
+// Default ledger used by the server
+var Default *Ledger
+
+// NewLedger Load the assessments stored in path, an empty ledger if it does not exist yet
+func NewLedger(path string, limits config.Risk) (*Ledger, error) {
+	l := &Ledger{
+		path:   path,
+		limits: limits,
+		now:    time.Now,
+	}
+
+	if err := storage.ReadJSON(path, &l.assessments); err != nil {
+		return nil, fmt.Errorf("risk: error loading assessments: %v", err)
+	}
+
+	return l, nil
+}
+
+// Assess Score a checkout attempt against the ones in the window and record the decision
+func (l *Ledger) Assess(a Attempt) (*Assessment, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now().UTC()
+	score, signals := Score(a, l.assessments, l.limits, now)
+
+	assessment := Assessment{
+		ID:        newID(),
+		Attempt:   a,
+		Score:     score,
+		Decision:  DecisionFor(score),
+		Signals:   signals,
+		CreatedAt: now,
+	}
+
+	previous := l.assessments
+	l.assessments = append(prune(l.assessments, now.Add(-l.limits.Window)), assessment)
+
+	if err := l.save(); err != nil {
+		l.assessments = previous
+		return nil, err
+	}
+
+	return &assessment, nil
+}
+
+// prune Assessments kept after the ones made before since are dropped. The attempts that never became an order are
+// only needed for velocity, the ones of an order are kept as the record of its review.
+func prune(assessments []Assessment, since time.Time) []Assessment {
+	kept := []Assessment{}
+
+	for _, a := range assessments {
+		if a.OrderID != "" || !a.CreatedAt.Before(since) {
+			kept = append(kept, a)
+		}
+	}
+
+	return kept
+}
+
+// Attach Link an assessment to the payment intent and order its attempt created
+func (l *Ledger) Attach(id string, paymentIntentID string, orderID string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i := range l.assessments {
+		if l.assessments[i].ID != id {
+			continue
+		}
+
+		previous := l.assessments[i]
+		l.assessments[i].PaymentIntentID = paymentIntentID
+		l.assessments[i].OrderID = orderID
+
+		if err := l.save(); err != nil {
+			l.assessments[i] = previous
+			return err
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("risk: assessment %s not found", id)
+}
+
+// List Assessments matching a filter, oldest first, every assessment when filter is nil
+func (l *Ledger) List(filter func(a *Assessment) bool) []Assessment {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	list := []Assessment{}
+
+	for i := range l.assessments {
+		if filter != nil && !filter(&l.assessments[i]) {
+			continue
+		}
+
+		list = append(list, l.assessments[i])
+	}
+
+	return list
+}
+
+// AnonymizeCustomer Remove the IP address and email of the attempts of a customer or made with its email, the
+// number of assessments changed. Their scores and signals are kept.
+func (l *Ledger) AnonymizeCustomer(customerID string, email string) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	email = normalizeEmail(email)
+	previous := map[int]Assessment{}
+
+	for i := range l.assessments {
+		a := &l.assessments[i].Attempt
+
+		if (customerID == "" || a.CustomerID != customerID) && (email == "" || normalizeEmail(a.Email) != email) {
+			continue
+		}
+
+		previous[i] = l.assessments[i]
+		a.IP = ""
+		a.Email = ""
+	}
+
+	if len(previous) == 0 {
+		return 0, nil
+	}
+
+	if err := l.save(); err != nil {
+		for i, a := range previous {
+			l.assessments[i] = a
+		}
+
+		return 0, err
+	}
+
+	return len(previous), nil
+}
+
+// Score Score an attempt given the previous assessments, every attempt counts for velocity, even blocked ones
+func Score(a Attempt, history []Assessment, limits config.Risk, now time.Time) (int, []Signal) {
+	signals := []Signal{}
+	since := now.Add(-limits.Window)
+
+	velocities := []struct {
+		name  string
+		value string
+		match func(previous Attempt) string
+	}{
+		{SignalIPVelocity, a.IP, func(previous Attempt) string { return previous.IP }},
+		{SignalCustomerVelocity, a.CustomerID, func(previous Attempt) string { return previous.CustomerID }},
+		{SignalEmailVelocity, normalizeEmail(a.Email), func(previous Attempt) string { return normalizeEmail(previous.Email) }},
+	}
+
+	for _, v := range velocities {
+		if v.value == "" {
+			continue
+		}
+
+		attempts := 1
+
+		for _, previous := range history {
+			if !previous.CreatedAt.Before(since) && v.match(previous.Attempt) == v.value {
+				attempts++
+			}
+		}
+
+		if attempts <= limits.MaxAttempts {
+			continue
+		}
+
+		score := 40
+
+		if attempts > 2*limits.MaxAttempts {
+			score = BlockScore
+		}
+
+		signals = append(signals, Signal{
+			Name:   v.name,
+			Score:  score,
+			Detail: fmt.Sprintf("%d attempts in %s, %d allowed", attempts, limits.Window, limits.MaxAttempts),
+		})
+	}
+
+	billing := strings.ToUpper(strings.TrimSpace(a.BillingCountry))
+	shipping := strings.ToUpper(strings.TrimSpace(a.ShippingCountry))
+
+	if billing != "" && shipping != "" && billing != shipping {
+		signals = append(signals, Signal{
+			Name:   SignalCountryMismatch,
+			Score:  25,
+			Detail: fmt.Sprintf("billed in %s, shipped to %s", billing, shipping),
+		})
+	}
+
+	if limits.MaxBottles > 0 && a.Bottles > limits.MaxBottles {
+		score := 30
+
+		if a.Bottles > 2*limits.MaxBottles {
+			score = 50
+		}
+
+		signals = append(signals, Signal{
+			Name:   SignalLargeOrder,
+			Score:  score,
+			Detail: fmt.Sprintf("%d bottles, %d usual at most", a.Bottles, limits.MaxBottles),
+		})
+	}
+
+	total := 0
+
+	for _, s := range signals {
+		total += s.Score
+	}
+
+	return total, signals
+}
+
+// DecisionFor Decision taken on an attempt with a score
+func DecisionFor(score int) Decision {
+	switch {
+	case score >= BlockScore:
+		return Block
+	case score >= ChallengeScore:
+		return Challenge
+	case score >= ReviewScore:
+		return Review
+	default:
+		return Allow
+	}
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func newID() string {
+	b := make([]byte, 8)
+
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("risk: error generating assessment ID: %v", err))
+	}
+
+	return "risk_" + hex.EncodeToString(b)
+}
+
+func (l *Ledger) save() error {
+	if err := storage.WriteJSON(l.path, l.assessments); err != nil {
+		return fmt.Errorf("risk: error saving assessments: %v", err)
+	}
+
+	return nil
+}
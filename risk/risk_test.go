@@ -0,0 +1,195 @@
+package risk
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/javierlopezdeancos/stipendivm/config"
+)
+
+var testLimits = config.Risk{Window: time.Hour, MaxAttempts: 2, MaxBottles: 12}
+
+// newTestLedger empty ledger stored in a temporary directory with a clock the test moves
+func newTestLedger(t *testing.T, now *time.Time) *Ledger {
+	dir, err := ioutil.TempDir("", "risk")
+
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %v", err)
+	}
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	l, err := NewLedger(filepath.Join(dir, "assessments.json"), testLimits)
+
+	if err != nil {
+		t.Fatalf("NewLedger() error = %v", err)
+	}
+
+	l.now = func() time.Time { return *now }
+
+	return l
+}
+
+func TestScore(t *testing.T) {
+	now := time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC)
+	history := []Assessment{
+		{Attempt: Attempt{IP: "10.0.0.1", Email: "Ana@example.com"}, CreatedAt: now.Add(-10 * time.Minute)},
+		{Attempt: Attempt{IP: "10.0.0.1", Email: "ana@example.com "}, CreatedAt: now.Add(-20 * time.Minute)},
+		{Attempt: Attempt{IP: "10.0.0.2", CustomerID: "cus_1"}, CreatedAt: now.Add(-30 * time.Minute)},
+		{Attempt: Attempt{IP: "10.0.0.2", CustomerID: "cus_1"}, CreatedAt: now.Add(-40 * time.Minute)},
+		{Attempt: Attempt{IP: "10.0.0.2", CustomerID: "cus_1"}, CreatedAt: now.Add(-50 * time.Minute)},
+		{Attempt: Attempt{IP: "10.0.0.2", CustomerID: "cus_1"}, CreatedAt: now.Add(-59 * time.Minute)},
+		{Attempt: Attempt{IP: "10.0.0.2", CustomerID: "cus_1"}, CreatedAt: now.Add(-2 * time.Hour)},
+	}
+
+	tests := []struct {
+		name         string
+		attempt      Attempt
+		wantScore    int
+		wantSignals  []string
+		wantDecision Decision
+	}{
+		{"first attempt", Attempt{IP: "10.0.0.9", Bottles: 6}, 0, nil, Allow},
+		{"country mismatch", Attempt{IP: "10.0.0.9", BillingCountry: "es", ShippingCountry: " FR"}, 25, []string{SignalCountryMismatch}, Review},
+		{"large order", Attempt{IP: "10.0.0.9", Bottles: 13}, 30, []string{SignalLargeOrder}, Review},
+		{"very large order", Attempt{IP: "10.0.0.9", Bottles: 25}, 50, []string{SignalLargeOrder}, Challenge},
+		{
+			"IP and email velocity",
+			Attempt{IP: "10.0.0.1", Email: "ANA@example.com"},
+			80, []string{SignalIPVelocity, SignalEmailVelocity}, Block,
+		},
+		{
+			"customer velocity twice the attempts allowed",
+			Attempt{IP: "10.0.0.9", CustomerID: "cus_1"},
+			BlockScore, []string{SignalCustomerVelocity}, Block,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score, signals := Score(tt.attempt, history, testLimits, now)
+
+			if score != tt.wantScore {
+				t.Errorf("Score() = %d, want %d", score, tt.wantScore)
+			}
+
+			if len(signals) != len(tt.wantSignals) {
+				t.Fatalf("Score() signals = %+v, want %v", signals, tt.wantSignals)
+			}
+
+			for i, s := range signals {
+				if s.Name != tt.wantSignals[i] {
+					t.Errorf("Score() signal %d = %s, want %s", i, s.Name, tt.wantSignals[i])
+				}
+			}
+
+			if decision := DecisionFor(score); decision != tt.wantDecision {
+				t.Errorf("DecisionFor(%d) = %s, want %s", score, decision, tt.wantDecision)
+			}
+		})
+	}
+}
+
+func TestLedgerAssess(t *testing.T) {
+	now := time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC)
+	l := newTestLedger(t, &now)
+	attempt := Attempt{IP: "10.0.0.1", CustomerID: "cus_1", Email: "ana@example.com", Bottles: 6}
+
+	first, err := l.Assess(attempt)
+
+	if err != nil {
+		t.Fatalf("Assess() error = %v", err)
+	}
+
+	if err := l.Attach(first.ID, "pi_1", "order_1"); err != nil {
+		t.Fatalf("Attach() error = %v", err)
+	}
+
+	if err := l.Attach("risk_unknown", "pi_2", "order_2"); err == nil {
+		t.Errorf("Attach() of an unknown assessment, want error")
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := l.Assess(attempt); err != nil {
+			t.Fatalf("Assess() error = %v", err)
+		}
+	}
+
+	if assessments := l.List(nil); len(assessments) != 3 || assessments[1].Decision != Allow || assessments[2].Decision != Block {
+		t.Fatalf("List() = %+v, want 3 assessments, the last one blocked", assessments)
+	}
+
+	// attempts out of the window are pruned on the next assessment, the ones of an order are kept
+	now = now.Add(2 * time.Hour)
+	last, err := l.Assess(attempt)
+
+	if err != nil {
+		t.Fatalf("Assess() error = %v", err)
+	}
+
+	if last.Decision != Allow {
+		t.Errorf("Assess() after the window = %s, want %s", last.Decision, Allow)
+	}
+
+	assessments := l.List(nil)
+
+	if len(assessments) != 2 || assessments[0].OrderID != "order_1" || assessments[1].ID != last.ID {
+		t.Fatalf("List() after the window = %+v, want the attached and the last assessments", assessments)
+	}
+
+	reloaded, err := NewLedger(l.path, testLimits)
+
+	if err != nil {
+		t.Fatalf("NewLedger() error = %v", err)
+	}
+
+	if len(reloaded.List(nil)) != 2 {
+		t.Errorf("reloaded ledger = %+v, want 2 assessments", reloaded.List(nil))
+	}
+}
+
+func TestLedgerAnonymizeCustomer(t *testing.T) {
+	now := time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC)
+	l := newTestLedger(t, &now)
+
+	attempts := []Attempt{
+		{IP: "10.0.0.1", CustomerID: "cus_1", Email: "ana@example.com"},
+		{IP: "10.0.0.2", Email: " ANA@example.com"},
+		{IP: "10.0.0.3", CustomerID: "cus_2", Email: "luis@example.com"},
+	}
+
+	for _, a := range attempts {
+		if _, err := l.Assess(a); err != nil {
+			t.Fatalf("Assess() error = %v", err)
+		}
+	}
+
+	changed, err := l.AnonymizeCustomer("cus_1", "ana@example.com")
+
+	if err != nil {
+		t.Fatalf("AnonymizeCustomer() error = %v", err)
+	}
+
+	if changed != 2 {
+		t.Errorf("AnonymizeCustomer() = %d, want 2", changed)
+	}
+
+	assessments := l.List(nil)
+
+	for i, a := range assessments[:2] {
+		if a.Attempt.IP != "" || a.Attempt.Email != "" {
+			t.Errorf("assessment %d = %+v, want its IP and email removed", i, a.Attempt)
+		}
+	}
+
+	if assessments[2].Attempt != attempts[2] {
+		t.Errorf("assessment of another customer = %+v, want %+v", assessments[2].Attempt, attempts[2])
+	}
+
+	if changed, err := l.AnonymizeCustomer("cus_9", ""); err != nil || changed != 0 {
+		t.Errorf("AnonymizeCustomer() of a customer without attempts = %d, %v, want 0", changed, err)
+	}
+}
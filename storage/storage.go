@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ReadJSON Read a JSON document from disk into v, a missing file leaves v untouched
+func ReadJSON(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("storage: error reading %s: %v", path, err)
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("storage: error decoding %s: %v", path, err)
+	}
+
+	return nil
+}
+
+// WriteJSON Write v as a JSON document to disk, replacing the previous file atomically
+func WriteJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+
+	if err != nil {
+		return fmt.Errorf("storage: error encoding %s: %v", path, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("storage: error creating directory for %s: %v", path, err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+
+	if err != nil {
+		return fmt.Errorf("storage: error creating temporary file for %s: %v", path, err)
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("storage: error writing %s: %v", path, err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("storage: error writing %s: %v", path, err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("storage: error replacing %s: %v", path, err)
+	}
+
+	return nil
+}
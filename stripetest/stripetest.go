@@ -0,0 +1,95 @@
+package stripetest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// Request request the Stripe client made to the fake API
+type Request struct {
+	Method string
+	Path   string
+	Form   url.Values
+}
+
+// Server fake Stripe API answering canned JSON, the requests without an answer get a Stripe resource missing error
+type Server struct {
+	mu        sync.Mutex
+	responses map[string]string
+	requests  []Request
+}
+
+// Start Point the Stripe client to a new fake API until the test ends
+func Start(t *testing.T) *Server {
+	s := &Server{responses: map[string]string{}}
+	server := httptest.NewServer(http.HandlerFunc(s.serve))
+	previous := stripe.GetBackend(stripe.APIBackend)
+	previousKey := stripe.Key
+
+	stripe.Key = "sk_test_stripetest"
+	stripe.SetBackend(stripe.APIBackend, stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{
+		URL:               stripe.String(server.URL),
+		MaxNetworkRetries: stripe.Int64(0),
+		LeveledLogger:     &stripe.LeveledLogger{Level: stripe.LevelNull},
+	}))
+
+	t.Cleanup(func() {
+		server.Close()
+		stripe.Key = previousKey
+		stripe.SetBackend(stripe.APIBackend, previous)
+	})
+
+	return s
+}
+
+// Handle Answer the requests of a method to a path, as /v1/payment_intents/pi_1, with a JSON body
+func (s *Server) Handle(method string, path string, body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.responses[method+" "+path] = body
+}
+
+// Requests Requests made of a method to a path, oldest first
+func (s *Server) Requests(method string, path string) []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requests := []Request{}
+
+	for _, r := range s.requests {
+		if r.Method == method && r.Path == path {
+			requests = append(requests, r)
+		}
+	}
+
+	return requests
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Method: r.Method, Path: r.URL.Path, Form: r.PostForm})
+	body, ok := s.responses[r.Method+" "+r.URL.Path]
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"error": {"type": "invalid_request_error", "code": "resource_missing", "message": "No such resource: %s %s"}}`, r.Method, r.URL.Path)
+		return
+	}
+
+	fmt.Fprint(w, body)
+}
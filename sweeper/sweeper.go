@@ -0,0 +1,125 @@
+package sweeper
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"github.com/javierlopezdeancos/stipendivm/inventory"
+	"github.com/javierlopezdeancos/stipendivm/orders"
+	"github.com/javierlopezdeancos/stipendivm/payments"
+)
+
+// Abandoned payment intent of an order nobody paid
+type Abandoned struct {
+	OrderID         string           `json:"orderId"`
+	PaymentIntentID string           `json:"paymentIntentId"`
+	Items           []inventory.Item `json:"items"`
+	CreatedAt       time.Time        `json:"createdAt"`
+}
+
+// Summary result of a sweep
+type Summary struct {
+	DryRun    bool        `json:"dryRun"`
+	Abandoned []Abandoned `json:"abandoned"`
+	Canceled  int         `json:"canceled"`
+	Errors    []string    `json:"errors"`
+}
+
+// sweeping held by the sweep running, the periodic one and the ones requested by the admin never overlap
+var sweeping sync.Mutex
+
+// Sweep Cancel the payment intents of unpaid orders older than maxAge still waiting for a payment method,
+// releasing their stock. A dry run only lists them.
+func Sweep(maxAge time.Duration, dryRun bool) *Summary {
+	sweeping.Lock()
+	defer sweeping.Unlock()
+
+	summary := &Summary{
+		DryRun:    dryRun,
+		Abandoned: []Abandoned{},
+		Errors:    []string{},
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+
+	unpaid := orders.Default.List(func(o *orders.Order) bool {
+		return (o.Status == orders.StatusPending || o.Status == orders.StatusFailed) && o.Invoice == nil && o.CreatedAt.Before(cutoff)
+	})
+
+	for _, o := range unpaid {
+		pi, err := payments.RetrieveIntent(o.PaymentIntentID)
+
+		if err != nil {
+			summary.Errors = append(summary.Errors, err.Error())
+			continue
+		}
+
+		if pi.Status != stripe.PaymentIntentStatusRequiresPaymentMethod {
+			continue
+		}
+
+		summary.Abandoned = append(summary.Abandoned, Abandoned{
+			OrderID:         o.ID,
+			PaymentIntentID: pi.ID,
+			Items:           o.Items,
+			CreatedAt:       o.CreatedAt,
+		})
+
+		if dryRun {
+			continue
+		}
+
+		if err := cancel(o, pi.ID); err != nil {
+			summary.Errors = append(summary.Errors, err.Error())
+			continue
+		}
+
+		summary.Canceled++
+	}
+
+	return summary
+}
+
+func cancel(o *orders.Order, paymentIntentID string) error {
+	if err := payments.CancelIntent(paymentIntentID); err != nil {
+		return err
+	}
+
+	if _, err := inventory.Reservations.Release(paymentIntentID); err != nil {
+		return err
+	}
+
+	_, err := orders.Default.UpdateStatus(o.ID, orders.StatusCanceled)
+
+	return err
+}
+
+// Print Print the summary of a sweep
+func (s *Summary) Print() {
+	if s.DryRun {
+		fmt.Printf("🔵 [INFO] Sweeper dry run, %d abandoned payment intents would be canceled\n", len(s.Abandoned))
+	} else {
+		fmt.Printf("🔵 [INFO] Sweeper canceled %d of %d abandoned payment intents\n", s.Canceled, len(s.Abandoned))
+	}
+
+	for _, a := range s.Abandoned {
+		fmt.Printf("   %s  order %s  created %s  %d wines\n", a.PaymentIntentID, a.OrderID, a.CreatedAt.Format(time.RFC3339), len(a.Items))
+	}
+
+	for _, err := range s.Errors {
+		fmt.Printf("🔴 [ERROR] %s\n", err)
+	}
+}
+
+// Run Sweep every interval until the server stops
+func Run(interval time.Duration, maxAge time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		Sweep(maxAge, false).Print()
+	}
+}
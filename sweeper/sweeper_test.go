@@ -0,0 +1,124 @@
+package sweeper
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/javierlopezdeancos/stipendivm/inventory"
+	"github.com/javierlopezdeancos/stipendivm/orders"
+	"github.com/javierlopezdeancos/stipendivm/stripetest"
+)
+
+// openTestStores empty order and reservation stores in a temporary directory, used as the default ones
+func openTestStores(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sweeper")
+
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %v", err)
+	}
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	if orders.Default, err = orders.NewStore(filepath.Join(dir, "orders.json")); err != nil {
+		t.Fatalf("orders.NewStore() error = %v", err)
+	}
+
+	if inventory.Reservations, err = inventory.NewReservationStore(filepath.Join(dir, "reservations.json")); err != nil {
+		t.Fatalf("inventory.NewReservationStore() error = %v", err)
+	}
+}
+
+// createOrder order of a payment intent holding a bottle of a wine, created age ago
+func createOrder(t *testing.T, paymentIntentID string, status orders.Status, age time.Duration, invoice *orders.Invoice) *orders.Order {
+	items := []inventory.Item{{Parent: "prod_1", Quantity: 1}}
+
+	o, err := orders.Default.Create(orders.Order{PaymentIntentID: paymentIntentID, Items: items, Status: status})
+
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	o, err = orders.Default.Update(o.ID, func(o *orders.Order) {
+		o.CreatedAt = o.CreatedAt.Add(-age)
+		o.Invoice = invoice
+	})
+
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	if err := inventory.Reservations.Reserve(paymentIntentID, items, map[string]int64{"prod_1": 10}); err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+
+	return o
+}
+
+func TestSweep(t *testing.T) {
+	openTestStores(t)
+	api := stripetest.Start(t)
+
+	api.Handle("GET", "/v1/payment_intents/pi_abandoned", `{"id": "pi_abandoned", "status": "requires_payment_method"}`)
+	api.Handle("GET", "/v1/payment_intents/pi_failed", `{"id": "pi_failed", "status": "requires_payment_method"}`)
+	api.Handle("GET", "/v1/payment_intents/pi_processing", `{"id": "pi_processing", "status": "processing"}`)
+	api.Handle("POST", "/v1/payment_intents/pi_abandoned/cancel", `{"id": "pi_abandoned", "status": "canceled"}`)
+	api.Handle("POST", "/v1/payment_intents/pi_failed/cancel", `{"id": "pi_failed", "status": "canceled"}`)
+
+	abandoned := createOrder(t, "pi_abandoned", orders.StatusPending, 2*time.Hour, nil)
+	failed := createOrder(t, "pi_failed", orders.StatusFailed, 2*time.Hour, nil)
+	processing := createOrder(t, "pi_processing", orders.StatusPending, 2*time.Hour, nil)
+	recent := createOrder(t, "pi_recent", orders.StatusPending, 0, nil)
+	invoiced := createOrder(t, "pi_invoiced", orders.StatusPending, 2*time.Hour, &orders.Invoice{ID: "in_1"})
+	missing := createOrder(t, "pi_missing", orders.StatusPending, 2*time.Hour, nil)
+
+	dryRun := Sweep(time.Hour, true)
+
+	if !dryRun.DryRun || len(dryRun.Abandoned) != 2 || dryRun.Canceled != 0 || len(dryRun.Errors) != 1 {
+		t.Fatalf("Sweep() dry run = %+v, want 2 abandoned, none canceled and 1 error", dryRun)
+	}
+
+	if requests := api.Requests("POST", "/v1/payment_intents/pi_abandoned/cancel"); len(requests) != 0 {
+		t.Errorf("Sweep() dry run canceled pi_abandoned")
+	}
+
+	summary := Sweep(time.Hour, false)
+
+	if summary.DryRun || len(summary.Abandoned) != 2 || summary.Canceled != 2 || len(summary.Errors) != 1 {
+		t.Fatalf("Sweep() = %+v, want 2 abandoned and canceled and 1 error", summary)
+	}
+
+	tests := []struct {
+		name       string
+		order      *orders.Order
+		wantStatus orders.Status
+		wantHeld   bool
+	}{
+		{"abandoned", abandoned, orders.StatusCanceled, false},
+		{"failed", failed, orders.StatusCanceled, false},
+		{"still processing", processing, orders.StatusPending, true},
+		{"recent", recent, orders.StatusPending, true},
+		{"invoiced", invoiced, orders.StatusPending, true},
+		{"payment intent not found", missing, orders.StatusPending, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, _ := orders.Default.Get(tt.order.ID)
+
+			if o.Status != tt.wantStatus {
+				t.Errorf("order status = %s, want %s", o.Status, tt.wantStatus)
+			}
+
+			if _, held := inventory.Reservations.Held(tt.order.PaymentIntentID); held != tt.wantHeld {
+				t.Errorf("reservation held = %v, want %v", held, tt.wantHeld)
+			}
+		})
+	}
+
+	if again := Sweep(time.Hour, false); len(again.Abandoned) != 0 || again.Canceled != 0 {
+		t.Errorf("Sweep() again = %+v, want nothing left to cancel", again)
+	}
+}
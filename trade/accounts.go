@@ -0,0 +1,190 @@
+package trade
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/customer"
+
+	"github.com/javierlopezdeancos/stipendivm/config"
+	"github.com/javierlopezdeancos/stipendivm/customers"
+	"github.com/javierlopezdeancos/stipendivm/inventory"
+)
+
+// Customer metadata keys of trade accounts
+const (
+	MetadataPriceList        = "priceList"
+	MetadataPaymentTermsDays = "paymentTermsDays"
+)
+
+// Account trade account of a business customer, buying at the prices of its price list and paying its invoices on
+// its payment terms
+type Account struct {
+	CustomerID       string     `json:"customerId"`
+	Company          string     `json:"company"`
+	NifCif           string     `json:"nifCif"`
+	PriceList        *PriceList `json:"priceList"`
+	PaymentTermsDays int64      `json:"paymentTermsDays"`
+}
+
+// Assignment price list and payment terms given to a business customer, the default terms when PaymentTermsDays is 0
+type Assignment struct {
+	PriceList        string `json:"priceList"`
+	PaymentTermsDays int64  `json:"paymentTermsDays"`
+}
+
+// NotBusinessError a customer without the company name and CIF or EU VAT number a trade account needs
+type NotBusinessError struct {
+	CustomerID string
+}
+
+func (e *NotBusinessError) Error() string {
+	return fmt.Sprintf("trade: customer %s is not a business with a CIF or EU VAT number", e.CustomerID)
+}
+
+// Minimum a wine, or the whole order when Wine is empty, under the minimum quantity of a price list
+type Minimum struct {
+	Wine      string `json:"wine,omitempty"`
+	Minimum   int64  `json:"minimum"`
+	Requested int64  `json:"requested"`
+}
+
+// IsBusiness whether a customer is a company identified by a CIF or EU VAT number, a NIF or NIE is a person
+func IsBusiness(c *stripe.Customer) bool {
+	if c == nil || c.Metadata[customers.MetadataCompany] == "" || c.Metadata[customers.MetadataNifCif] == "" {
+		return false
+	}
+
+	taxIDType := c.Metadata[customers.MetadataTaxIDType]
+
+	return taxIDType == customers.TaxIDCIF || taxIDType == customers.TaxIDVAT
+}
+
+// AccountOf Trade account of a customer, nil for guests, consumers and businesses without a price list
+func AccountOf(c *stripe.Customer) (*Account, error) {
+	if !IsBusiness(c) || c.Metadata[MetadataPriceList] == "" {
+		return nil, nil
+	}
+
+	list, err := Default.Get(c.Metadata[MetadataPriceList])
+
+	if err != nil {
+		return nil, err
+	}
+
+	days, err := strconv.ParseInt(c.Metadata[MetadataPaymentTermsDays], 10, 64)
+
+	if err != nil || days <= 0 {
+		days = config.GetTradePaymentTerms()
+	}
+
+	return &Account{
+		CustomerID:       c.ID,
+		Company:          c.Metadata[customers.MetadataCompany],
+		NifCif:           c.Metadata[customers.MetadataNifCif],
+		PriceList:        list,
+		PaymentTermsDays: days,
+	}, nil
+}
+
+// Find Trade account of a customer ID, nil for guests and customers without one
+func Find(customerID string) (*Account, error) {
+	if customerID == "" {
+		return nil, nil
+	}
+
+	c, err := customers.Retrieve(customerID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return AccountOf(c)
+}
+
+// Assign Open the trade account of a business customer, or change its price list and payment terms
+func Assign(customerID string, a Assignment) (*Account, error) {
+	if _, err := customers.Get(customerID); err != nil {
+		return nil, err
+	}
+
+	c, err := customers.Retrieve(customerID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !IsBusiness(c) {
+		return nil, &NotBusinessError{CustomerID: customerID}
+	}
+
+	if _, err := Default.Get(a.PriceList); err != nil {
+		return nil, err
+	}
+
+	if a.PaymentTermsDays <= 0 {
+		a.PaymentTermsDays = config.GetTradePaymentTerms()
+	}
+
+	params := &stripe.CustomerParams{}
+	params.AddMetadata(MetadataPriceList, a.PriceList)
+	params.AddMetadata(MetadataPaymentTermsDays, strconv.FormatInt(a.PaymentTermsDays, 10))
+
+	updated, err := customer.Update(customerID, params)
+
+	if err != nil {
+		return nil, fmt.Errorf("trade: error assigning price list to customer %s: %v", customerID, err)
+	}
+
+	fmt.Printf("🔵 [INFO] Customer %s buys at price list %s on %d days terms\n", customerID, a.PriceList, a.PaymentTermsDays)
+
+	return AccountOf(updated)
+}
+
+// Close Close the trade account of a customer, who buys at the catalog prices again
+func Close(customerID string) error {
+	if _, err := customers.Get(customerID); err != nil {
+		return err
+	}
+
+	params := &stripe.CustomerParams{}
+	params.AddMetadata(MetadataPriceList, "")
+	params.AddMetadata(MetadataPaymentTermsDays, "")
+
+	if _, err := customer.Update(customerID, params); err != nil {
+		return fmt.Errorf("trade: error closing trade account of customer %s: %v", customerID, err)
+	}
+
+	return nil
+}
+
+// UnitAmount Price of a wine bottle in the price list of the account, the catalog price when it has none
+func (a *Account) UnitAmount(wineID string) (int64, error) {
+	if price, ok := a.PriceList.Prices[wineID]; ok {
+		return price, nil
+	}
+
+	return inventory.UnitAmount(wineID)
+}
+
+// CheckMinimums Wines of an order under their minimum quantity in the price list of the account, and the order itself
+// when it has fewer bottles than the minimum of the list. The lines of the same wine are added up.
+func (a *Account) CheckMinimums(items []inventory.Item) []Minimum {
+	minimums := []Minimum{}
+	bottles := int64(0)
+
+	for _, item := range inventory.Merge(items) {
+		bottles += item.Quantity
+
+		if minimum := a.PriceList.MinimumQuantities[item.Parent]; item.Quantity < minimum {
+			minimums = append(minimums, Minimum{Wine: item.Parent, Minimum: minimum, Requested: item.Quantity})
+		}
+	}
+
+	if bottles < a.PriceList.MinimumBottles {
+		minimums = append(minimums, Minimum{Minimum: a.PriceList.MinimumBottles, Requested: bottles})
+	}
+
+	return minimums
+}
@@ -0,0 +1,241 @@
+package trade
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/invoice"
+	"github.com/stripe/stripe-go/v72/invoiceitem"
+	"github.com/stripe/stripe-go/v72/paymentintent"
+
+	"github.com/javierlopezdeancos/stipendivm/inventory"
+	"github.com/javierlopezdeancos/stipendivm/invoices"
+	"github.com/javierlopezdeancos/stipendivm/orders"
+	"github.com/javierlopezdeancos/stipendivm/payments"
+	"github.com/javierlopezdeancos/stipendivm/quotes"
+)
+
+// CreateInvoice Invoice an order to a trade customer at the prices of its price list, due in the days of its payment
+// terms. The invoice is finalized but not sent until Send, once the checkout has reserved its wines. The payment
+// intent of the invoice carries the order metadata, so paying the invoice completes the order as any checkout. What
+// was created in Stripe is undone when a step fails.
+func CreateInvoice(a *Account, ir *payments.IntentCreationRequest) (*orders.Order, error) {
+	q, err := quotes.Calculate(&quotes.Request{
+		Currency:       ir.Currency,
+		Items:          ir.Items,
+		ShippingOption: ir.ShippingOption,
+		Destination:    ir.Destination,
+		PromoCode:      ir.PromoCode,
+		Buyer:          ir.Buyer,
+	}, a.UnitAmount)
+
+	if err != nil {
+		return nil, fmt.Errorf("trade: error computing invoice amount: %v", err)
+	}
+
+	orderID := orders.NewID()
+
+	params := &stripe.InvoiceParams{
+		Customer:         stripe.String(a.CustomerID),
+		CollectionMethod: stripe.String(string(stripe.InvoiceCollectionMethodSendInvoice)),
+		DaysUntilDue:     stripe.Int64(a.PaymentTermsDays),
+		AutoAdvance:      stripe.Bool(false),
+		Description:      stripe.String(fmt.Sprintf("Order %s, payment due in %d days", orderID, a.PaymentTermsDays)),
+	}
+
+	if q.TaxMention != "" {
+		params.Footer = stripe.String(q.TaxMention)
+	}
+
+	params.AddMetadata(payments.MetadataOrderID, orderID)
+	// the invoice only bills the items added to it, never other pending items of the customer
+	params.AddExtra("pending_invoice_items_behavior", "exclude")
+
+	inv, err := invoice.New(params)
+
+	if err != nil {
+		return nil, fmt.Errorf("trade: error creating invoice of order %s: %v", orderID, err)
+	}
+
+	invoiceID := inv.ID
+
+	if err := addInvoiceItems(invoiceID, a.CustomerID, ir, q); err != nil {
+		deleteDraft(invoiceID)
+		return nil, err
+	}
+
+	if inv, err = invoice.FinalizeInvoice(invoiceID, nil); err != nil {
+		deleteDraft(invoiceID)
+		return nil, fmt.Errorf("trade: error finalizing invoice %s: %v", invoiceID, err)
+	}
+
+	if inv.PaymentIntent == nil {
+		voidInvoice(invoiceID)
+		return nil, fmt.Errorf("trade: invoice %s has no payment intent", invoiceID)
+	}
+
+	piParams := &stripe.PaymentIntentParams{}
+	payments.AddOrderMetadata(&piParams.Params, orderID, ir.Items, q, ir.ShippingOption.ID)
+
+	pi, err := paymentintent.Update(inv.PaymentIntent.ID, piParams)
+
+	if err != nil {
+		voidInvoice(invoiceID)
+		return nil, fmt.Errorf("trade: error updating payment intent of invoice %s: %v", invoiceID, err)
+	}
+
+	order, err := orders.Default.Create(orders.Order{
+		ID:              orderID,
+		PaymentIntentID: pi.ID,
+		CustomerID:      a.CustomerID,
+		Items:           ir.Items,
+		Amount:          q.Total,
+		Currency:        ir.Currency,
+		Status:          orders.StatusInvoiced,
+		Invoice: &orders.Invoice{
+			ID:        inv.ID,
+			Number:    inv.Number,
+			DueDate:   time.Unix(inv.DueDate, 0).UTC(),
+			HostedURL: inv.HostedInvoiceURL,
+		},
+	})
+
+	if err != nil {
+		voidInvoice(invoiceID)
+		return nil, fmt.Errorf("trade: error creating order of invoice %s: %v", invoiceID, err)
+	}
+
+	return order, nil
+}
+
+// Send Issue the invoice of an order in the registry, as the wines are shipped before they are paid, and have Stripe
+// email it to the customer
+func Send(order *orders.Order) error {
+	pi, err := payments.RetrieveIntent(order.PaymentIntentID)
+
+	if err != nil {
+		return err
+	}
+
+	record, err := invoices.Default.IssueOnCredit(pi)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := invoice.SendInvoice(order.Invoice.ID, nil); err != nil {
+		return fmt.Errorf("trade: error sending invoice %s: %v", order.Invoice.ID, err)
+	}
+
+	fmt.Printf(
+		"🔵 [INFO] Order %s invoiced to customer %s as %s, due %s\n",
+		order.ID,
+		order.CustomerID,
+		record.Number,
+		order.Invoice.DueDate.Format("2006-01-02"),
+	)
+
+	return nil
+}
+
+// Void Void the invoice of an order whose checkout failed after it was created, the order is canceled and its wines
+// released. The invoice.voided webhook rectifies it with a credit note if it was already issued.
+func Void(order *orders.Order) {
+	voidInvoice(order.Invoice.ID)
+
+	if _, err := inventory.Reservations.Release(order.PaymentIntentID); err != nil {
+		fmt.Printf("🔴 [ERROR] %v\n", err)
+	}
+
+	if _, err := orders.Default.UpdateStatus(order.ID, orders.StatusCanceled); err != nil {
+		fmt.Printf("🔴 [ERROR] %v\n", err)
+	}
+}
+
+// deleteDraft delete a draft invoice and the items added to it
+func deleteDraft(invoiceID string) {
+	if _, err := invoice.Del(invoiceID, nil); err != nil {
+		fmt.Printf("🔴 [ERROR] trade: error deleting draft invoice %s: %v\n", invoiceID, err)
+	}
+}
+
+// voidInvoice void a finalized invoice, so it can not be paid
+func voidInvoice(invoiceID string) {
+	if _, err := invoice.VoidInvoice(invoiceID, nil); err != nil {
+		fmt.Printf("🔴 [ERROR] trade: error voiding invoice %s: %v\n", invoiceID, err)
+	}
+}
+
+// addInvoiceItems add the quote lines to a draft invoice. Prices include VAT, the promotion code discount and the VAT
+// of a destination not charged VAT are taken out in lines of their own. The items already added are removed when one
+// fails.
+func addInvoiceItems(invoiceID string, customerID string, ir *payments.IntentCreationRequest, q *quotes.Quote) error {
+	items := []*stripe.InvoiceItemParams{}
+
+	for _, line := range q.Lines {
+		description := line.Wine
+
+		if product, err := inventory.RetrieveWine(line.Wine); err == nil {
+			description = product.Name
+		}
+
+		items = append(items, &stripe.InvoiceItemParams{
+			Description: stripe.String(description),
+			Quantity:    stripe.Int64(line.Quantity),
+			UnitAmount:  stripe.Int64(line.UnitAmount),
+		})
+	}
+
+	if q.Shipping > 0 {
+		items = append(items, &stripe.InvoiceItemParams{
+			Description: stripe.String(strings.TrimSpace("Shipping " + ir.ShippingOption.Label)),
+			Amount:      stripe.Int64(q.Shipping),
+		})
+	}
+
+	if q.Discount > 0 {
+		items = append(items, &stripe.InvoiceItemParams{
+			Description: stripe.String("Promotion " + q.PromoCode),
+			Amount:      stripe.Int64(-q.Discount),
+		})
+	}
+
+	if gross := q.Subtotal - q.Discount + q.Shipping; q.Total != gross {
+		description := "VAT not charged at destination"
+
+		if q.ReverseCharge {
+			description = "VAT not charged, reverse charge"
+		}
+
+		items = append(items, &stripe.InvoiceItemParams{
+			Description: stripe.String(description),
+			Amount:      stripe.Int64(q.Total - gross),
+		})
+	}
+
+	added := []string{}
+
+	for _, params := range items {
+		params.Customer = stripe.String(customerID)
+		params.Currency = stripe.String(ir.Currency)
+		params.Invoice = stripe.String(invoiceID)
+
+		item, err := invoiceitem.New(params)
+
+		if err != nil {
+			for _, id := range added {
+				if _, err := invoiceitem.Del(id, nil); err != nil {
+					fmt.Printf("🔴 [ERROR] trade: error removing invoice item %s: %v\n", id, err)
+				}
+			}
+
+			return fmt.Errorf("trade: error adding items to invoice %s: %v", invoiceID, err)
+		}
+
+		added = append(added, item.ID)
+	}
+
+	return nil
+}
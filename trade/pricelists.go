@@ -0,0 +1,197 @@
+package trade
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/javierlopezdeancos/stipendivm/customers"
+	"github.com/javierlopezdeancos/stipendivm/storage"
+)
+
+// FieldInvalidAmount price or quantity of a price list that is not positive
+const FieldInvalidAmount = "invalid_amount"
+
+// PriceList trade prices and minimum quantities of the wines sold to the customers it is assigned to. Prices are in
+// cents and include VAT, as the catalog prices, wines without a trade price are sold at the catalog price.
+type PriceList struct {
+	ID                string           `json:"id"`
+	Name              string           `json:"name"`
+	Prices            map[string]int64 `json:"prices"`
+	MinimumQuantities map[string]int64 `json:"minimumQuantities"`
+	// MinimumBottles bottles every order must have at least, none when 0
+	MinimumBottles int64     `json:"minimumBottles"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// PriceListNotFoundError a price list that does not exist
+type PriceListNotFoundError struct {
+	ID string
+}
+
+func (e *PriceListNotFoundError) Error() string {
+	return fmt.Sprintf("trade: price list %s not found", e.ID)
+}
+
+// PriceLists price lists persisted on disk
+type PriceLists struct {
+	mu    sync.Mutex
+	path  string
+	lists []PriceList
+	now   func() time.Time
+}
+
+// Default price lists used by the server
+var Default *PriceLists
+
+// NewPriceLists Load the price lists stored in path, none if it does not exist yet
+func NewPriceLists(path string) (*PriceLists, error) {
+	p := &PriceLists{
+		path: path,
+		now:  time.Now,
+	}
+
+	if err := storage.ReadJSON(path, &p.lists); err != nil {
+		return nil, fmt.Errorf("trade: error loading price lists: %v", err)
+	}
+
+	return p, nil
+}
+
+// List Every price list sorted by ID
+func (p *PriceLists) List() []PriceList {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	lists := append([]PriceList{}, p.lists...)
+
+	sort.Slice(lists, func(i, j int) bool {
+		return lists[i].ID < lists[j].ID
+	})
+
+	return lists
+}
+
+// Get Get a price list
+func (p *PriceLists) Get(id string) (*PriceList, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	i := p.find(id)
+
+	if i < 0 {
+		return nil, &PriceListNotFoundError{ID: id}
+	}
+
+	found := p.lists[i]
+
+	return &found, nil
+}
+
+// Save Create a price list or replace the one with its ID
+func (p *PriceLists) Save(l PriceList) (*PriceList, error) {
+	if fields := validatePriceList(l); len(fields) > 0 {
+		return nil, &customers.ValidationError{Fields: fields}
+	}
+
+	if l.Prices == nil {
+		l.Prices = map[string]int64{}
+	}
+
+	if l.MinimumQuantities == nil {
+		l.MinimumQuantities = map[string]int64{}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	previous := append([]PriceList{}, p.lists...)
+	l.UpdatedAt = p.now().UTC()
+
+	if i := p.find(l.ID); i >= 0 {
+		p.lists[i] = l
+	} else {
+		p.lists = append(p.lists, l)
+	}
+
+	if err := p.save(); err != nil {
+		p.lists = previous
+		return nil, err
+	}
+
+	return &l, nil
+}
+
+func validatePriceList(l PriceList) []customers.FieldError {
+	fields := []customers.FieldError{}
+
+	if strings.TrimSpace(l.ID) == "" {
+		fields = append(fields, customers.FieldError{
+			Field:   "id",
+			Code:    customers.FieldRequired,
+			Message: "The price list ID is required",
+		})
+	}
+
+	if strings.TrimSpace(l.Name) == "" {
+		fields = append(fields, customers.FieldError{
+			Field:   "name",
+			Code:    customers.FieldRequired,
+			Message: "The price list name is required",
+		})
+	}
+
+	for wine, price := range l.Prices {
+		if price <= 0 {
+			fields = append(fields, customers.FieldError{
+				Field:   "prices." + wine,
+				Code:    FieldInvalidAmount,
+				Message: "The trade price must be greater than zero",
+			})
+		}
+	}
+
+	for wine, quantity := range l.MinimumQuantities {
+		if quantity < 0 {
+			fields = append(fields, customers.FieldError{
+				Field:   "minimumQuantities." + wine,
+				Code:    FieldInvalidAmount,
+				Message: "The minimum quantity can not be negative",
+			})
+		}
+	}
+
+	if l.MinimumBottles < 0 {
+		fields = append(fields, customers.FieldError{
+			Field:   "minimumBottles",
+			Code:    FieldInvalidAmount,
+			Message: "The minimum bottles can not be negative",
+		})
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Field < fields[j].Field
+	})
+
+	return fields
+}
+
+func (p *PriceLists) find(id string) int {
+	for i, l := range p.lists {
+		if l.ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (p *PriceLists) save() error {
+	if err := storage.WriteJSON(p.path, p.lists); err != nil {
+		return fmt.Errorf("trade: error saving price lists: %v", err)
+	}
+
+	return nil
+}
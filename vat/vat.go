@@ -0,0 +1,134 @@
+package vat
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"github.com/javierlopezdeancos/stipendivm/customers"
+	"github.com/javierlopezdeancos/stipendivm/quotes"
+)
+
+// Sources of a validation
+const (
+	SourceFormat = "format"
+	SourceOnline = "online"
+)
+
+// Validator check online that a VAT number, already valid in format, is registered for intra-EU trade
+type Validator interface {
+	Validate(id customers.TaxID) (bool, error)
+}
+
+// Validation result of validating a VAT number
+type Validation struct {
+	VATID     string    `json:"vatId"`
+	Country   string    `json:"country"`
+	Valid     bool      `json:"valid"`
+	Source    string    `json:"source"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// Checker validate EU VAT numbers by their format, and with the Online validator when it is set. A failing online
+// validator falls back to the format, so checkouts never depend on it. Online results are kept for ttl.
+type Checker struct {
+	Online Validator
+
+	mu    sync.Mutex
+	ttl   time.Duration
+	cache map[string]Validation
+	now   func() time.Time
+}
+
+// Default checker used by the server
+var Default = NewChecker(nil, 24*time.Hour)
+
+// NewChecker Checker validating online with online, nil to validate only the format, keeping its results for ttl
+func NewChecker(online Validator, ttl time.Duration) *Checker {
+	return &Checker{
+		Online: online,
+		ttl:    ttl,
+		cache:  map[string]Validation{},
+		now:    time.Now,
+	}
+}
+
+// Validate Validate an EU VAT number with its country prefix, NIF, NIE and CIF without prefix are not valid
+func (c *Checker) Validate(number string) Validation {
+	now := c.now().UTC()
+	id, err := customers.ParseTaxID(number)
+
+	if err != nil || id.Type != customers.TaxIDVAT {
+		return Validation{VATID: customers.NormalizeTaxID(number), Source: SourceFormat, CheckedAt: now}
+	}
+
+	validation := Validation{
+		VATID:     id.Value,
+		Country:   id.Country,
+		Valid:     true,
+		Source:    SourceFormat,
+		CheckedAt: now,
+	}
+
+	if c.Online == nil {
+		return validation
+	}
+
+	if cached, ok := c.cached(id.Value, now); ok {
+		return cached
+	}
+
+	// the online validator is not called holding the lock, a slow answer does not hold the other checkouts
+	valid, err := c.Online.Validate(id)
+
+	if err != nil {
+		fmt.Printf("🔴 [ERROR] vat: error validating %s online, checked by its format: %v\n", id.Value, err)
+		return validation
+	}
+
+	validation.Valid = valid
+	validation.Source = SourceOnline
+
+	c.mu.Lock()
+	c.cache[id.Value] = validation
+	c.mu.Unlock()
+
+	return validation
+}
+
+// cached online validation of a VAT number still fresh at now
+func (c *Checker) cached(vatID string, now time.Time) (Validation, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.cache[vatID]
+
+	return cached, ok && now.Sub(cached.CheckedAt) < c.ttl
+}
+
+// BuyerOf Business buyer of a customer with a company name and a valid EU VAT number, nil otherwise
+func (c *Checker) BuyerOf(customer *stripe.Customer) *quotes.Buyer {
+	if customer == nil || customer.Metadata[customers.MetadataTaxIDType] != customers.TaxIDVAT {
+		return nil
+	}
+
+	company := customer.Metadata[customers.MetadataCompany]
+
+	if company == "" {
+		return nil
+	}
+
+	validation := c.Validate(customer.Metadata[customers.MetadataNifCif])
+
+	if !validation.Valid {
+		return nil
+	}
+
+	return &quotes.Buyer{
+		Name:    company,
+		VATID:   validation.VATID,
+		Country: validation.Country,
+	}
+}
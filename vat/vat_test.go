@@ -0,0 +1,143 @@
+package vat
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"github.com/javierlopezdeancos/stipendivm/customers"
+)
+
+// fakeValidator online validator answering valid for the numbers it registers, counting its calls
+type fakeValidator struct {
+	registered map[string]bool
+	err        error
+	calls      int
+}
+
+func (f *fakeValidator) Validate(id customers.TaxID) (bool, error) {
+	f.calls++
+
+	if f.err != nil {
+		return false, f.err
+	}
+
+	return f.registered[id.Value], nil
+}
+
+func TestCheckerValidate(t *testing.T) {
+	tests := []struct {
+		name        string
+		online      Validator
+		number      string
+		wantVATID   string
+		wantCountry string
+		wantValid   bool
+		wantSource  string
+	}{
+		{"valid format offline", nil, "fr 40 303 265 045", "FR40303265045", "FR", true, SourceFormat},
+		{"Greek prefix", nil, "GR123456789", "EL123456789", "EL", true, SourceFormat},
+		{"invalid format", nil, "FR123", "FR123", "", false, SourceFormat},
+		{"Spanish CIF without prefix", nil, "B12345674", "B12345674", "", false, SourceFormat},
+		{"unknown country", nil, "XX123456789", "XX123456789", "", false, SourceFormat},
+		{
+			"registered online",
+			&fakeValidator{registered: map[string]bool{"DE123456789": true}},
+			"DE123456789", "DE123456789", "DE", true, SourceOnline,
+		},
+		{"not registered online", &fakeValidator{}, "DE123456789", "DE123456789", "DE", false, SourceOnline},
+		{
+			"online validator down",
+			&fakeValidator{err: errors.New("timeout")},
+			"DE123456789", "DE123456789", "DE", true, SourceFormat,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewChecker(tt.online, time.Hour).Validate(tt.number)
+
+			if got.VATID != tt.wantVATID || got.Country != tt.wantCountry || got.Valid != tt.wantValid || got.Source != tt.wantSource {
+				t.Errorf(
+					"Validate(%q) = %s %s %v %s, want %s %s %v %s",
+					tt.number,
+					got.VATID, got.Country, got.Valid, got.Source,
+					tt.wantVATID, tt.wantCountry, tt.wantValid, tt.wantSource,
+				)
+			}
+		})
+	}
+}
+
+func TestCheckerCache(t *testing.T) {
+	online := &fakeValidator{registered: map[string]bool{"PT123456789": true}}
+	checker := NewChecker(online, time.Hour)
+	now := time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC)
+	checker.now = func() time.Time { return now }
+
+	checker.Validate("PT123456789")
+	now = now.Add(59 * time.Minute)
+	checker.Validate("PT123456789")
+
+	if online.calls != 1 {
+		t.Fatalf("online validator called %d times within the ttl, want 1", online.calls)
+	}
+
+	now = now.Add(2 * time.Minute)
+	checker.Validate("PT123456789")
+
+	if online.calls != 2 {
+		t.Fatalf("online validator called %d times after the ttl, want 2", online.calls)
+	}
+
+	failing := &fakeValidator{err: errors.New("unavailable")}
+	checker = NewChecker(failing, time.Hour)
+	checker.Validate("PT123456789")
+	checker.Validate("PT123456789")
+
+	if failing.calls != 2 {
+		t.Fatalf("failed validations cached, online validator called %d times, want 2", failing.calls)
+	}
+}
+
+func TestBuyerOf(t *testing.T) {
+	business := func(taxIDType string, nifCif string, company string) *stripe.Customer {
+		return &stripe.Customer{Metadata: map[string]string{
+			customers.MetadataTaxIDType: taxIDType,
+			customers.MetadataNifCif:    nifCif,
+			customers.MetadataCompany:   company,
+		}}
+	}
+
+	tests := []struct {
+		name      string
+		customer  *stripe.Customer
+		wantVATID string
+	}{
+		{"EU business", business(customers.TaxIDVAT, "IT12345678901", "Vini Srl"), "IT12345678901"},
+		{"without company name", business(customers.TaxIDVAT, "IT12345678901", ""), ""},
+		{"Spanish CIF", business(customers.TaxIDCIF, "B12345674", "Bodegas SL"), ""},
+		{"invalid VAT number", business(customers.TaxIDVAT, "IT123", "Vini Srl"), ""},
+		{"guest", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buyer := NewChecker(nil, time.Hour).BuyerOf(tt.customer)
+
+			if tt.wantVATID == "" {
+				if buyer != nil {
+					t.Errorf("BuyerOf() = %+v, want nil", buyer)
+				}
+
+				return
+			}
+
+			if buyer == nil || buyer.VATID != tt.wantVATID || buyer.Name != tt.customer.Metadata[customers.MetadataCompany] {
+				t.Errorf("BuyerOf() = %+v, want buyer %s", buyer, tt.wantVATID)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package vat
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/javierlopezdeancos/stipendivm/customers"
+)
+
+// VIESURL REST endpoint of the VAT Information Exchange System of the European Commission
+const VIESURL = "https://ec.europa.eu/taxation_customs/vies/rest-api/ms/%s/vat/%s"
+
+// VIES validate VAT numbers against the registry of the European Commission
+type VIES struct {
+	Client *http.Client
+	URL    string
+}
+
+// NewVIES VIES validator waiting timeout for its answer
+func NewVIES(timeout time.Duration) *VIES {
+	return &VIES{
+		Client: &http.Client{Timeout: timeout},
+		URL:    VIESURL,
+	}
+}
+
+// Validate Whether VIES has a VAT number registered
+func (v *VIES) Validate(id customers.TaxID) (bool, error) {
+	response, err := v.Client.Get(fmt.Sprintf(v.URL, id.Country, id.Value[len(id.Country):]))
+
+	if err != nil {
+		return false, fmt.Errorf("vat: error requesting VIES: %v", err)
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("vat: VIES answered %s", response.Status)
+	}
+
+	result := struct {
+		IsValid   bool   `json:"isValid"`
+		UserError string `json:"userError"`
+	}{}
+
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return false, fmt.Errorf("vat: error decoding VIES answer: %v", err)
+	}
+
+	// VIES answers VALID or INVALID, any other user error is the member state service being down
+	if !result.IsValid && result.UserError != "" && result.UserError != "INVALID" {
+		return false, fmt.Errorf("vat: VIES could not validate %s: %s", id.Value, result.UserError)
+	}
+
+	return result.IsValid, nil
+}
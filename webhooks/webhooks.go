@@ -2,10 +2,14 @@ package webhooks
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/stripe/stripe-go/v72"
 
 	"github.com/javierlopezdeancos/stipendivm/inventory"
+	"github.com/javierlopezdeancos/stipendivm/invoices"
+	"github.com/javierlopezdeancos/stipendivm/notifications"
+	"github.com/javierlopezdeancos/stipendivm/orders"
 	"github.com/javierlopezdeancos/stipendivm/payments"
 )
 
@@ -15,29 +19,139 @@ func HandlePaymentIntent(event stripe.Event, pi *stripe.PaymentIntent) (bool, er
 	case "payment_intent.succeeded":
 		fmt.Printf("🔔  Webhook received! Payment for PaymentIntent %s succeeded\n", pi.ID)
 
-		for wineId, wineQuantity := range pi.Metadata {
-			inventory.UpdateWineStock(wineId, wineQuantity)
+		// the sale is invoiced before anything else, a payment is never left without its invoice
+		invoice, created, err := invoices.Default.Issue(pi)
+
+		if err != nil {
+			return true, err
+		}
+
+		// the customer is confirmed once, with the invoice, a retried webhook finds it already issued
+		if created {
+			fmt.Printf("🔔  Invoice %s issued for PaymentIntent %s\n", invoice.Number, pi.ID)
+
+			notify(notifications.OrderConfirmation, pi, notifications.Data{
+				Amount:        notifications.FormatAmount(invoice.TotalAmount, pi.Currency),
+				InvoiceNumber: invoice.Number,
+				TaxMention:    invoice.TaxMention,
+			})
+		}
+
+		if err := takeOutOfStock(pi); err != nil {
+			return true, err
+		}
+
+		if err := updateOrderStatus(pi, orders.StatusPaid); err != nil {
+			return true, err
+		}
+
+		if err := recordMandate(pi); err != nil {
+			return true, err
 		}
 
 		return true, nil
 
+	case "payment_intent.processing":
+		// debits take days to settle, the wines stay reserved and are not shipped meanwhile
+		fmt.Printf("🔔  Webhook received! Payment for PaymentIntent %s is processing\n", pi.ID)
+
+		return true, updateOrderStatus(pi, orders.StatusProcessing)
+
+	case "payment_intent.requires_action":
+		fmt.Printf("🔔  Webhook received! PaymentIntent %s requires the customer action\n", pi.ID)
+
+		return true, updateOrderStatus(pi, orders.StatusRequiresAction)
+
 	case "payment_intent.payment_failed":
-		if pi.LastPaymentError.PaymentMethod != nil {
-			fmt.Printf(
-				"🔔  Webhook received! Payment on %s %s for PaymentIntent %s failed\n",
-				"payment_method",
-				pi.LastPaymentError.PaymentMethod.ID,
-				pi.ID,
-			)
-		} else {
-			fmt.Printf(
-				"🔔  Webhook received! Payment on %s %s for PaymentIntent %s failed\n",
-				"source",
-				pi.LastPaymentError.Source.ID,
-				pi.ID,
-			)
+		paymentMethod := "unknown"
+
+		if pi.LastPaymentError != nil && pi.LastPaymentError.PaymentMethod != nil {
+			paymentMethod = pi.LastPaymentError.PaymentMethod.ID
+		}
+
+		fmt.Printf(
+			"🔔  Webhook received! Payment on %s %s for PaymentIntent %s failed\n",
+			"payment_method",
+			paymentMethod,
+			pi.ID,
+		)
+
+		order, ok := orders.Default.FindByPaymentIntent(pi.ID)
+
+		// an invoice can be paid again until it is due, its order stays invoiced
+		if ok && order.Invoice != nil {
+			return true, nil
+		}
+
+		// a debit failing after processing will not be retried, its wines go back to stock
+		if ok && order.Status == orders.StatusProcessing {
+			if _, err := inventory.Reservations.Release(pi.ID); err != nil {
+				return true, err
+			}
+		}
+
+		if err := updateOrderStatus(pi, orders.StatusFailed); err != nil {
+			return true, err
+		}
+
+		notify(notifications.PaymentFailed, pi, notifications.Data{
+			Amount: notifications.FormatAmount(pi.Amount, pi.Currency),
+		})
+
+		return true, nil
+
+	case "payment_intent.canceled":
+		fmt.Printf("🔔  Webhook received! PaymentIntent %s canceled\n", pi.ID)
+
+		if _, err := inventory.Reservations.Release(pi.ID); err != nil {
+			return true, err
+		}
+
+		return true, updateOrderStatus(pi, orders.StatusCanceled)
+
+	default:
+		return false, nil
+	}
+}
+
+// HandleCharge Handle charge
+func HandleCharge(event stripe.Event, charge *stripe.Charge) (bool, error) {
+	switch event.Type {
+	case "charge.refunded":
+		if charge.PaymentIntent == nil {
+			return false, nil
+		}
+
+		pi, err := payments.RetrieveIntent(charge.PaymentIntent.ID)
+
+		if err != nil {
+			return true, err
+		}
+
+		amount := charge.AmountRefunded - invoices.Default.Credited(pi.ID)
+
+		if amount <= 0 {
+			return true, nil
+		}
+
+		creditNote, err := invoices.Default.IssueCreditNote(pi, amount)
+
+		if err != nil {
+			return true, err
+		}
+
+		fmt.Printf("🔔  Webhook received! Credit note %s issued for PaymentIntent %s\n", creditNote.Number, pi.ID)
+
+		if charge.Refunded {
+			if err := updateOrderStatus(pi, orders.StatusRefunded); err != nil {
+				return true, err
+			}
 		}
 
+		notify(notifications.Refund, pi, notifications.Data{
+			Amount: notifications.FormatAmount(amount, pi.Currency),
+		})
+
 		return true, nil
 
 	default:
@@ -45,19 +159,209 @@ func HandlePaymentIntent(event stripe.Event, pi *stripe.PaymentIntent) (bool, er
 	}
 }
 
-func HandleSource(event stripe.Event, source *stripe.Source) (bool, error) {
-	paymentIntent := source.Metadata["paymentIntent"]
+// HandleInvoice Handle invoice
+func HandleInvoice(event stripe.Event, inv *stripe.Invoice) (bool, error) {
+	switch event.Type {
+	case "invoice.voided":
+		if inv.PaymentIntent == nil {
+			return false, nil
+		}
+
+		order, ok := orders.Default.FindByPaymentIntent(inv.PaymentIntent.ID)
+
+		if !ok || order.Invoice == nil {
+			return false, nil
+		}
+
+		fmt.Printf("🔔  Webhook received! Invoice %s of order %s voided\n", inv.ID, order.ID)
+
+		pi, err := payments.RetrieveIntent(inv.PaymentIntent.ID)
+
+		if err != nil {
+			return true, err
+		}
+
+		if _, err := inventory.Reservations.Release(pi.ID); err != nil {
+			return true, err
+		}
+
+		// the sale was invoiced when sent, voiding it is rectified with a credit note, an invoice voided before it was
+		// sent was never issued
+		if _, issued := invoices.Default.Find(pi.ID); !issued {
+			fmt.Printf("🔔  Invoice %s voided before it was issued\n", inv.ID)
+		} else if amount := pi.Amount - invoices.Default.Credited(pi.ID); amount > 0 {
+			creditNote, err := invoices.Default.IssueCreditNote(pi, amount)
+
+			if err != nil {
+				return true, err
+			}
+
+			fmt.Printf("🔔  Credit note %s issued for voided invoice %s\n", creditNote.Number, inv.ID)
+		}
+
+		return true, updateOrderStatus(pi, orders.StatusCanceled)
+
+	default:
+		return false, nil
+	}
+}
+
+// HandleDispute Handle dispute
+func HandleDispute(event stripe.Event, d *stripe.Dispute) (bool, error) {
+	switch event.Type {
+	case "charge.dispute.created", "charge.dispute.updated", "charge.dispute.closed":
+		fmt.Printf("🔔  Webhook received! Dispute %s is %s\n", d.ID, d.Status)
+
+		if d.PaymentIntent == nil {
+			return true, nil
+		}
+
+		order, ok := orders.Default.FindByPaymentIntent(d.PaymentIntent.ID)
+
+		if !ok {
+			fmt.Printf("🔴 [ERROR] No order paid by PaymentIntent %s for dispute %s\n", d.PaymentIntent.ID, d.ID)
+			return true, nil
+		}
+
+		order, err := orders.Default.Update(order.ID, func(o *orders.Order) {
+			if o.Dispute == nil {
+				o.Dispute = &orders.Dispute{ID: d.ID}
+			}
+
+			o.Dispute.Reason = string(d.Reason)
+			o.Dispute.Status = string(d.Status)
+			o.Dispute.Amount = d.Amount
+
+			if d.EvidenceDetails != nil && d.EvidenceDetails.DueBy > 0 {
+				o.Dispute.EvidenceDueBy = time.Unix(d.EvidenceDetails.DueBy, 0).UTC()
+			}
+
+			o.Status = disputeOrderStatus(d)
+		})
 
-	if paymentIntent == "" {
+		if err != nil {
+			return true, err
+		}
+
+		// the team has to act on new disputes and know how closed ones ended
+		if event.Type != "charge.dispute.updated" {
+			alertTeam(d, order)
+		}
+
+		return true, nil
+
+	default:
 		return false, nil
 	}
+}
+
+// disputeOrderStatus status of the order a dispute is opened on, a won dispute leaves it paid again
+func disputeOrderStatus(d *stripe.Dispute) orders.Status {
+	switch d.Status {
+	case stripe.DisputeStatusWon, stripe.DisputeStatusWarningClosed:
+		return orders.StatusPaid
+	case stripe.DisputeStatusLost:
+		return orders.StatusDisputeLost
+	case stripe.DisputeStatusChargeRefunded:
+		return orders.StatusRefunded
+	default:
+		return orders.StatusDisputed
+	}
+}
+
+// alertTeam Email the team about a dispute, a failed email is logged and never fails the webhook
+func alertTeam(d *stripe.Dispute, order *orders.Order) {
+	data := notifications.Data{
+		Name:    order.CustomerID,
+		OrderID: order.ID,
+		Amount:  notifications.FormatAmount(d.Amount, string(d.Currency)),
+		Reason:  string(d.Reason),
+		Status:  string(d.Status),
+	}
+
+	if order.Dispute != nil && !order.Dispute.EvidenceDueBy.IsZero() {
+		data.DueBy = order.Dispute.EvidenceDueBy.Format("2006-01-02 15:04 MST")
+	}
 
-	if source.Status == "chargeable" {
-		fmt.Printf("🔔  Webhook received! The source %s is chargeable\n", source.ID)
-		return true, payments.ConfirmIntent(paymentIntent, source)
-	} else if source.Status == "failed" || source.Status == "canceled" {
-		return true, payments.CancelIntent(paymentIntent)
+	if err := notifications.Default.NotifyTeam(notifications.DisputeAlert, data); err != nil {
+		fmt.Printf("🔴 [ERROR] %v\n", err)
 	}
+}
+
+// takeOutOfStock take the wines sold out of stock and release their reservation, which kept them from being sold
+// meanwhile. Each wine is released once its stock is updated, a retried webhook only takes out the wines still held
+// and never does it twice.
+func takeOutOfStock(pi *stripe.PaymentIntent) error {
+	items, ok := inventory.Reservations.Held(pi.ID)
+
+	if !ok {
+		return nil
+	}
+
+	for _, item := range inventory.Merge(items) {
+		if _, err := inventory.DecreaseWineStock(item.Parent, item.Quantity); err != nil {
+			return err
+		}
+
+		if err := inventory.Reservations.ReleaseWine(pi.ID, item.Parent); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// updateOrderStatus change the status of the order a payment intent pays, if it has one. An order missing from the
+// store is logged, failing the webhook would only have Stripe retry it in vain.
+func updateOrderStatus(pi *stripe.PaymentIntent, status orders.Status) error {
+	orderID := pi.Metadata[payments.MetadataOrderID]
+
+	if orderID == "" {
+		return nil
+	}
+
+	if _, ok := orders.Default.Get(orderID); !ok {
+		fmt.Printf("🔴 [ERROR] Order %s of PaymentIntent %s not found, not marked %s\n", orderID, pi.ID, status)
+		return nil
+	}
+
+	_, err := orders.Default.UpdateStatus(orderID, status)
 
-	return false, nil
+	return err
+}
+
+// recordMandate save the ID of the mandate a debit was charged under in the order it paid
+func recordMandate(pi *stripe.PaymentIntent) error {
+	if pi.Charges == nil || len(pi.Charges.Data) == 0 {
+		return nil
+	}
+
+	details := pi.Charges.Data[0].PaymentMethodDetails
+
+	if details == nil || details.SepaDebit == nil || details.SepaDebit.Mandate == nil {
+		return nil
+	}
+
+	order, ok := orders.Default.FindByPaymentIntent(pi.ID)
+
+	if !ok {
+		return nil
+	}
+
+	_, err := orders.Default.Update(order.ID, func(o *orders.Order) {
+		if o.Mandate == nil {
+			o.Mandate = &orders.Mandate{}
+		}
+
+		o.Mandate.ID = details.SepaDebit.Mandate.ID
+	})
+
+	return err
+}
+
+// notify Email the customer of a payment intent, a failed email is logged and never fails the webhook
+func notify(kind notifications.Kind, pi *stripe.PaymentIntent, data notifications.Data) {
+	if err := notifications.Default.NotifyPaymentIntent(kind, pi, data); err != nil {
+		fmt.Printf("🔴 [ERROR] %v\n", err)
+	}
 }
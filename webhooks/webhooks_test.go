@@ -0,0 +1,265 @@
+package webhooks
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+
+	"github.com/javierlopezdeancos/stipendivm/config"
+	"github.com/javierlopezdeancos/stipendivm/inventory"
+	"github.com/javierlopezdeancos/stipendivm/invoices"
+	"github.com/javierlopezdeancos/stipendivm/notifications"
+	"github.com/javierlopezdeancos/stipendivm/orders"
+	"github.com/javierlopezdeancos/stipendivm/payments"
+	"github.com/javierlopezdeancos/stipendivm/stripetest"
+)
+
+// sender keep the emails sent instead of delivering them
+type sender struct {
+	sent []notifications.Message
+}
+
+func (s *sender) Send(m notifications.Message) error {
+	s.sent = append(s.sent, m)
+	return nil
+}
+
+// openTestStores empty stores in a temporary directory and a notifier keeping its emails, used as the default ones
+func openTestStores(t *testing.T) *sender {
+	dir, err := ioutil.TempDir("", "webhooks")
+
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %v", err)
+	}
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	if orders.Default, err = orders.NewStore(filepath.Join(dir, "orders.json")); err != nil {
+		t.Fatalf("orders.NewStore() error = %v", err)
+	}
+
+	if inventory.Reservations, err = inventory.NewReservationStore(filepath.Join(dir, "reservations.json")); err != nil {
+		t.Fatalf("inventory.NewReservationStore() error = %v", err)
+	}
+
+	invoices.Default, err = invoices.NewRegistry(filepath.Join(dir, "invoices.json"), config.Issuer{NIF: "B12345674", Name: "Bodegas SL", Series: "Q"})
+
+	if err != nil {
+		t.Fatalf("invoices.NewRegistry() error = %v", err)
+	}
+
+	s := &sender{}
+	notifications.Default = &notifications.Notifier{Sender: s, From: "tienda@example.com"}
+
+	return s
+}
+
+// checkout order of a payment intent paying 2 and 1 bottles of a wine and 3 of another, with its wines reserved
+func checkout(t *testing.T, id string) (*stripe.PaymentIntent, *orders.Order) {
+	items := []inventory.Item{{Parent: "prod_1", Quantity: 2}, {Parent: "prod_2", Quantity: 3}, {Parent: "prod_1", Quantity: 1}}
+
+	o, err := orders.Default.Create(orders.Order{PaymentIntentID: id, Items: items, Amount: 12100, Currency: "eur"})
+
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	if err := inventory.Reservations.Reserve(id, items, map[string]int64{"prod_1": 10, "prod_2": 10}); err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+
+	pi := &stripe.PaymentIntent{
+		ID:             id,
+		Amount:         12100,
+		AmountReceived: 12100,
+		Currency:       "eur",
+		ReceiptEmail:   "ana@example.com",
+		Metadata:       map[string]string{payments.MetadataOrderID: o.ID, payments.MetadataTaxRate: "21"},
+	}
+
+	return pi, o
+}
+
+func TestHandlePaymentIntentSucceeded(t *testing.T) {
+	sent := openTestStores(t)
+	api := stripetest.Start(t)
+
+	api.Handle("GET", "/v1/products/prod_1", `{"id": "prod_1", "metadata": {"quantity": "10"}}`)
+	api.Handle("POST", "/v1/products/prod_1", `{"id": "prod_1", "metadata": {"quantity": "7"}}`)
+	api.Handle("GET", "/v1/products/prod_2", `{"id": "prod_2", "metadata": {"quantity": "5"}}`)
+	api.Handle("POST", "/v1/products/prod_2", `{"id": "prod_2", "metadata": {"quantity": "2"}}`)
+
+	pi, o := checkout(t, "pi_1")
+	pi.Status = stripe.PaymentIntentStatusSucceeded
+	event := stripe.Event{Type: "payment_intent.succeeded"}
+
+	// a retried webhook finds the wines already taken out of stock and the invoice issued
+	for i := 0; i < 2; i++ {
+		if handled, err := HandlePaymentIntent(event, pi); !handled || err != nil {
+			t.Fatalf("HandlePaymentIntent() = %v, %v, want handled", handled, err)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		wine         string
+		wantQuantity string
+	}{
+		{"lines of a wine added up", "prod_1", "7"},
+		{"wine of a single line", "prod_2", "2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updates := api.Requests("POST", "/v1/products/"+tt.wine)
+
+			if len(updates) != 1 {
+				t.Fatalf("stock of %s updated %d times, want once", tt.wine, len(updates))
+			}
+
+			if quantity := updates[0].Form.Get("metadata[quantity]"); quantity != tt.wantQuantity {
+				t.Errorf("stock of %s = %s, want %s", tt.wine, quantity, tt.wantQuantity)
+			}
+		})
+	}
+
+	if _, held := inventory.Reservations.Held(pi.ID); held {
+		t.Errorf("reservation of a paid payment intent still held")
+	}
+
+	if paid, _ := orders.Default.Get(o.ID); paid.Status != orders.StatusPaid {
+		t.Errorf("order status = %s, want %s", paid.Status, orders.StatusPaid)
+	}
+
+	if _, issued := invoices.Default.Find(pi.ID); !issued {
+		t.Errorf("no invoice issued for the paid payment intent")
+	}
+
+	if len(sent.sent) != 1 || sent.sent[0].Kind != notifications.OrderConfirmation || sent.sent[0].To != "ana@example.com" {
+		t.Errorf("emails sent = %+v, want one order confirmation to ana@example.com", sent.sent)
+	}
+}
+
+func TestHandlePaymentIntentSucceededStockError(t *testing.T) {
+	openTestStores(t)
+	api := stripetest.Start(t)
+
+	api.Handle("GET", "/v1/products/prod_1", `{"id": "prod_1", "metadata": {"quantity": "10"}}`)
+	api.Handle("POST", "/v1/products/prod_1", `{"id": "prod_1", "metadata": {"quantity": "7"}}`)
+
+	pi, o := checkout(t, "pi_1")
+	pi.Status = stripe.PaymentIntentStatusSucceeded
+	event := stripe.Event{Type: "payment_intent.succeeded"}
+
+	if _, err := HandlePaymentIntent(event, pi); err == nil {
+		t.Fatalf("HandlePaymentIntent() with a wine not found, want error")
+	}
+
+	// the wine whose stock was not updated stays reserved, so Stripe retrying the webhook takes it out of stock
+	if held, _ := inventory.Reservations.Held(pi.ID); len(held) != 1 || held[0].Parent != "prod_2" {
+		t.Errorf("reservation held = %+v, want only prod_2", held)
+	}
+
+	if pending, _ := orders.Default.Get(o.ID); pending.Status != orders.StatusPending {
+		t.Errorf("order status = %s, want %s", pending.Status, orders.StatusPending)
+	}
+
+	api.Handle("GET", "/v1/products/prod_2", `{"id": "prod_2", "metadata": {"quantity": "5"}}`)
+	api.Handle("POST", "/v1/products/prod_2", `{"id": "prod_2", "metadata": {"quantity": "2"}}`)
+
+	if handled, err := HandlePaymentIntent(event, pi); !handled || err != nil {
+		t.Fatalf("HandlePaymentIntent() retried = %v, %v, want handled", handled, err)
+	}
+
+	for _, wine := range []string{"prod_1", "prod_2"} {
+		if updates := api.Requests("POST", "/v1/products/"+wine); len(updates) != 1 {
+			t.Errorf("stock of %s updated %d times, want once", wine, len(updates))
+		}
+	}
+
+	if _, held := inventory.Reservations.Held(pi.ID); held {
+		t.Errorf("reservation of a paid payment intent still held")
+	}
+
+	if paid, _ := orders.Default.Get(o.ID); paid.Status != orders.StatusPaid {
+		t.Errorf("order status = %s, want %s", paid.Status, orders.StatusPaid)
+	}
+}
+
+func TestHandlePaymentIntentCanceled(t *testing.T) {
+	openTestStores(t)
+	api := stripetest.Start(t)
+
+	pi, o := checkout(t, "pi_1")
+	pi.Status = stripe.PaymentIntentStatusCanceled
+
+	if handled, err := HandlePaymentIntent(stripe.Event{Type: "payment_intent.canceled"}, pi); !handled || err != nil {
+		t.Fatalf("HandlePaymentIntent() = %v, %v, want handled", handled, err)
+	}
+
+	if _, held := inventory.Reservations.Held(pi.ID); held {
+		t.Errorf("reservation of a canceled payment intent still held")
+	}
+
+	if reserved := inventory.Reservations.Reserved("prod_1", ""); reserved != 0 {
+		t.Errorf("Reserved(prod_1) = %d, want 0", reserved)
+	}
+
+	if canceled, _ := orders.Default.Get(o.ID); canceled.Status != orders.StatusCanceled {
+		t.Errorf("order status = %s, want %s", canceled.Status, orders.StatusCanceled)
+	}
+
+	for _, wine := range []string{"prod_1", "prod_2"} {
+		if updates := api.Requests("POST", "/v1/products/"+wine); len(updates) != 0 {
+			t.Errorf("stock of %s updated for a canceled payment intent", wine)
+		}
+	}
+}
+
+func TestHandlePaymentIntentFailed(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     orders.Status
+		invoice    *orders.Invoice
+		wantStatus orders.Status
+		wantHeld   bool
+	}{
+		{"card declined", orders.StatusPending, nil, orders.StatusFailed, true},
+		{"debit failed after processing", orders.StatusProcessing, nil, orders.StatusFailed, false},
+		{"invoice payment failed", orders.StatusInvoiced, &orders.Invoice{ID: "in_1"}, orders.StatusInvoiced, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestStores(t)
+			stripetest.Start(t)
+
+			pi, o := checkout(t, "pi_1")
+			pi.Status = stripe.PaymentIntentStatusRequiresPaymentMethod
+
+			_, err := orders.Default.Update(o.ID, func(o *orders.Order) {
+				o.Status = tt.status
+				o.Invoice = tt.invoice
+			})
+
+			if err != nil {
+				t.Fatalf("Update() error = %v", err)
+			}
+
+			if handled, err := HandlePaymentIntent(stripe.Event{Type: "payment_intent.payment_failed"}, pi); !handled || err != nil {
+				t.Fatalf("HandlePaymentIntent() = %v, %v, want handled", handled, err)
+			}
+
+			if failed, _ := orders.Default.Get(o.ID); failed.Status != tt.wantStatus {
+				t.Errorf("order status = %s, want %s", failed.Status, tt.wantStatus)
+			}
+
+			if _, held := inventory.Reservations.Held(pi.ID); held != tt.wantHeld {
+				t.Errorf("reservation held = %v, want %v", held, tt.wantHeld)
+			}
+		})
+	}
+}
@@ -13,6 +13,10 @@ type Metadata struct {
 	DoImage          string `json:"doImage"`
 	Graduation       string `json:"graduation"`
 	Grape            string `json:"grape"`
+	LimitPeriodDays  string `json:"limitPeriodDays"`
+	MaxPerCustomer   string `json:"maxPerCustomer"`
+	MaxPerHousehold  string `json:"maxPerHousehold"`
+	MaxPerOrder      string `json:"maxPerOrder"`
 	PlaceholderImage string `json:"placeholderImage"`
 	Path             string `json:"path"`
 	Quantity         string `json:"quantity"`